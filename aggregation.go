@@ -0,0 +1,158 @@
+package tsdb
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+var (
+	percentileRegex = regexp.MustCompile(`^percentile\s*\(\s*([0-9]+(?:\.[0-9]+)?)\s*\)$`)
+	// functions without arguments
+	aggregationFunctions = []string{
+		aggregationAvg, aggregationMin, aggregationMax, aggregationSum, aggregationCount,
+		aggregationFirst, aggregationLast, aggregationSpread, aggregationStddev, aggregationTwa,
+	}
+)
+
+// Aggregation is the function used to reduce the values in one window, like "avg" or "percentile(95)"
+type Aggregation struct {
+	Function   string
+	Percentile float64 // 0-100, only for percentile
+}
+
+func ParseAggregation(in string) (out Aggregation, err error) {
+	function := strings.ToLower(strings.TrimSpace(in))
+	if function == "" {
+		return Aggregation{Function: aggregationDefault}, nil
+	}
+	if slices.Contains(aggregationFunctions, function) {
+		return Aggregation{Function: function}, nil
+	}
+	matches := percentileRegex.FindStringSubmatch(function)
+	if matches == nil {
+		return out, fmt.Errorf("unsupported aggregation: %q", in)
+	}
+	percentile, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil || percentile > 100 {
+		return out, fmt.Errorf("invalid percentile: %q", in)
+	}
+	return Aggregation{Function: aggregationPercentile, Percentile: percentile}, nil
+}
+
+func (a Aggregation) String() string {
+	if a.Function == aggregationPercentile {
+		return fmt.Sprintf("%s(%s)", a.Function, strconv.FormatFloat(a.Percentile, 'f', -1, 64))
+	}
+	return a.Function
+}
+
+// Apply reduces the points in window [start, end) the same way as tdengine does,
+// prev and next are the nearest points outside the window, they are only used by twa
+func (a Aggregation) Apply(points []*RedisDataPoint, prev *RedisDataPoint, next *RedisDataPoint, start *gtime.Time, end *gtime.Time) any {
+	if len(points) == 0 {
+		return nil
+	}
+	switch a.Function {
+	case aggregationFirst:
+		return points[0].Value
+	case aggregationLast:
+		return points[len(points)-1].Value
+	case aggregationCount:
+		return int64(len(points))
+	case aggregationTwa:
+		return timeWeightedAverage(points, prev, next, start, end)
+	}
+
+	values := make([]float64, 0, len(points))
+	for _, point := range points {
+		values = append(values, gconv.Float64(point.Value))
+	}
+	switch a.Function {
+	case aggregationAvg:
+		return sumOf(values) / float64(len(values))
+	case aggregationMin:
+		return slices.Min(values)
+	case aggregationMax:
+		return slices.Max(values)
+	case aggregationSum:
+		return sumOf(values)
+	case aggregationSpread:
+		return slices.Max(values) - slices.Min(values)
+	case aggregationStddev:
+		// population standard deviation
+		avg := sumOf(values) / float64(len(values))
+		var variance float64
+		for _, v := range values {
+			variance += (v - avg) * (v - avg)
+		}
+		return math.Sqrt(variance / float64(len(values)))
+	case aggregationPercentile:
+		// linear interpolation between closest ranks
+		slices.Sort(values)
+		rank := a.Percentile / 100 * float64(len(values)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+	}
+	return nil
+}
+
+func sumOf(values []float64) (sum float64) {
+	for _, v := range values {
+		sum += v
+	}
+	return
+}
+
+func timeWeightedAverage(points []*RedisDataPoint, prev *RedisDataPoint, next *RedisDataPoint, start *gtime.Time, end *gtime.Time) any {
+	/*
+		the curve is linear between points, values at window boundaries are interpolated from the points outside the window,
+		if there is no point outside, the curve starts or ends at the first or last point in the window
+	*/
+	type sample struct {
+		ts    float64
+		value float64
+	}
+	samples := make([]sample, 0, len(points)+2)
+	first, last := points[0], points[len(points)-1]
+	if prev != nil && first.Timestamp.After(start) {
+		samples = append(samples, sample{
+			ts:    float64(start.UnixMilli()),
+			value: interpolate(prev, first, start.UnixMilli()),
+		})
+	}
+	for _, point := range points {
+		samples = append(samples, sample{ts: float64(point.Timestamp.UnixMilli()), value: gconv.Float64(point.Value)})
+	}
+	if next != nil {
+		samples = append(samples, sample{
+			ts:    float64(end.UnixMilli()),
+			value: interpolate(last, next, end.UnixMilli()),
+		})
+	}
+	duration := samples[len(samples)-1].ts - samples[0].ts
+	if duration == 0 {
+		return samples[0].value
+	}
+	var area float64
+	for i := 1; i < len(samples); i++ {
+		area += (samples[i].value + samples[i-1].value) / 2 * (samples[i].ts - samples[i-1].ts)
+	}
+	return area / duration
+}
+
+func interpolate(from *RedisDataPoint, to *RedisDataPoint, ts int64) float64 {
+	fromTs, toTs := from.Timestamp.UnixMilli(), to.Timestamp.UnixMilli()
+	fromValue, toValue := gconv.Float64(from.Value), gconv.Float64(to.Value)
+	if toTs == fromTs {
+		return toValue
+	}
+	return fromValue + (toValue-fromValue)*float64(ts-fromTs)/float64(toTs-fromTs)
+}
@@ -7,6 +7,7 @@ type ClientType string
 const (
 	ClientTypeTdengine           ClientType = "tdengine"
 	ClientTypeRedis              ClientType = "redis"
+	ClientTypeRedisTimeSeries    ClientType = "redis_timeseries"
 	ClientTypeInfluxdbOfficialV1 ClientType = "influxdb_official_v1"
 	ClientTypeInfluxdbV1         ClientType = "influxdb_v1"
 )
@@ -17,6 +18,27 @@ const (
 	RealTimeWindowMinDuration     = time.Second
 	fillNone                      = "NONE"
 	fillNull                      = "NULL"
+	fillPrev                      = "PREV"
+	fillNext                      = "NEXT"
+	fillLinear                    = "LINEAR"
+	fillValue                     = "VALUE"
+	fillNullF                     = "NULL_F"
+	fillValueF                    = "VALUE_F"
+)
+
+const (
+	aggregationAvg        = "avg"
+	aggregationMin        = "min"
+	aggregationMax        = "max"
+	aggregationSum        = "sum"
+	aggregationCount      = "count"
+	aggregationFirst      = "first"
+	aggregationLast       = "last"
+	aggregationSpread     = "spread"
+	aggregationStddev     = "stddev"
+	aggregationTwa        = "twa" // time-weighted average
+	aggregationPercentile = "percentile"
+	aggregationDefault    = aggregationLast
 )
 
 const (
@@ -30,18 +52,98 @@ const (
 	tdengineColumnPseudoWindowEnd   = "_wend"   // it's a pseudo column, so back quote is not needed, or it will cause an error
 	tdengineTableTagsDevice         = "device"
 	tdengineTableTagsProject        = "project"
+	tdengineTableTagsModel          = "model" // tag of the alarm stable, which is shared by models
+	tdengineTableTagsKind           = "kind"  // tag of the alarm stable, status or event
+	tdengineAlarmKindStatus         = "status"
+	tdengineAlarmKindEvent          = "event"
 	tdengineTableTagsType           = "NCHAR(16)"
 	tdengineTableNameAlarm          = "alarm"
 	tdengineTableNameKey            = "tableName"
+	resultKeyIsStale                = "isStale" // last known values older than RealTimeWindow
 	tdengineDataKeepMinimumStr      = "1d"
 	tdengineDataKeepMinimumDuration = time.Hour * 24
 	tdengineDefaultPassword         = "taosdata"
+	tdengineDefaultAdmin            = "root"
+	tdengineDatabaseBufferDefault   = 48
+	tdengineDatabasePagesDefault    = 128
+	tdengineDatabaseDurationDefault = "6h"
 	tdengineDefaultDataType         = "DOUBLE"
 )
+
+// tdengine error codes, they are the lower 16 bits of codes like 0x80000357
+const (
+	tdengineCodeUserAlreadyExist     = 0x0350
+	tdengineCodeAuthFailure          = 0x0357 // 855
+	tdengineCodeSTableNotExist       = 0x0362
+	tdengineCodeColumnNotExist       = 0x036F
+	tdengineCodeDatabaseAlreadyExist = 0x0381 // 897
+	tdengineCodeDatabaseNotExist     = 0x0388 // 904
+	tdengineCodeTableNotExist        = 0x0603
+	tdengineCodeParserInvalidColumn  = 0x2602
+	tdengineCodeParserTableNotExist  = 0x2603
+)
+const (
+	EventStateRaised   = "raised"
+	EventStateCleared  = "cleared"
+	eventFieldSeverity = "severity"
+	eventFieldCode     = "code"
+	eventFieldMessage  = "message"
+	eventFieldState    = "state"
+	eventFieldAcked    = "acked"
+	eventFieldProject  = "project"
+)
+
+const (
+	maintenanceLeaseDuration      = 30 * time.Second
+	maintenanceLeaseRenewInterval = 10 * time.Second
+	maintenanceLeaseCronName      = "MaintenanceLeaseCron"
+)
+
 const (
 	redisKeyLatest               = "latest"
 	redisKeyTimestamp            = "_ts"
+	redisKeyDeviceIndex          = "_devices"
+	redisKeyProjects             = "_projects"
+	redisGlobWildcard            = "\x00" // placeholder of a wildcard in a key passed to redisGlob
+	redisKeyMaintenanceLeader    = "_maintenance:leader"
+	redisKeyMaintenanceJobs      = "_maintenance:jobs"
+	redisMinMajorVersion         = 6 // ZADD GT, ZMSCORE and XADD MINID require redis 6.2
+	redisMinMinorVersion         = 2
+	redisKeyVersion1             = 1
+	redisKeyVersion2             = 2
+	redisSeriesLayoutStream      = "stream"
+	redisSeriesLayoutZSet        = "zset"
+	redisSeriesLayoutDevice      = "device"
+	redisKeyDeviceSeries         = "_series"
+	redisKeyDeviceStatus         = "_status"      // status events of a device
+	redisKeyDeviceStatusLast     = "_status:last" // the last status events of a model
+	redisKeyDeviceEvents         = "_events"
+	redisKeyEventIndex           = "_events:devices"
+	redisKeyModels               = "_models"
+	redisKeyPoints               = "_points"
+	redisKeyRollup               = "_rollup"
+	redisKeyRollupWatermarks     = "_rollup:watermarks"
+	redisRollupCronName          = "RedisRollupCron"
+	redisRollupMinute            = "1m"
+	redisRollupHour              = "1h"
+	redisRollupKeepMinuteDefault = 24 * time.Hour
+	redisRollupKeepHourDefault   = 30 * 24 * time.Hour
+	redisCommandHSetGT           = "HSETGT" // not a redis command, it is run by redisBatchScript
+	redisBatchMaxCommands        = 5000     // a script blocks redis, so large writes are split into several scripts
+	redisShardVirtualNodes       = 160
+	redisReadConcurrency         = 16 // range calls of a series query in flight at the same time
 	redisAutoExpireCronName      = "RedisAutoExpireCron"
 	redisDataKeepDefaultStr      = "1h"
 	redisDataKeepDefaultDuration = time.Hour
+	redisTimeSeriesKey           = "ts"
+	redisTimeSeriesLabelPrefix   = "prefix"
+	redisTimeSeriesLabelModel    = "model"
+	redisTimeSeriesLabelDevice   = "device"
+	redisTimeSeriesLabelProject  = "project"
+	redisTimeSeriesLabelPoint    = "point"
+	redisValueTypeInt            = "i:"
+	redisValueTypeFloat          = "f:"
+	redisValueTypeBool           = "b:"
+	redisValueTypeString         = "s:"
+	redisValueTypeNil            = "n:"
 )
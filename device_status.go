@@ -0,0 +1,221 @@
+package tsdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gtime"
+)
+
+/*
+	device status is derived from the last seen time of devices:
+	a device is online if it has been seen within RealTimeWindow, otherwise it is offline.
+	TrackDeviceStatus compares the status now with the last recorded event of each device and records the transitions,
+	so it should be run periodically, e.g. by a cron job, and transitions are as precise as its interval:
+	an online event is at the last seen time of the run, an offline event is at the last seen time plus RealTimeWindow
+*/
+
+// DeviceStatusTracker is implemented by the clients that track device status, use it by type assertion on GetClient()
+type DeviceStatusTracker interface {
+	// TrackDeviceStatus records the online and offline transitions of devices of a model, it returns the new events
+	TrackDeviceStatus(ctx context.Context, deviceModelName string) ([]*DeviceStatusEvent, error)
+	// ReadDeviceStatus returns the status of devices now, derived from their last seen time
+	ReadDeviceStatus(ctx context.Context, in ReadDeviceStatusInput) ([]*DeviceStatus, error)
+	// ReadDeviceUptime returns how long a device is online in a range, from the recorded events
+	ReadDeviceUptime(ctx context.Context, in ReadDeviceUptimeInput) (*DeviceUptime, error)
+}
+
+type DeviceStatus struct {
+	DeviceModelName string `json:"tableName"`
+	DeviceId        string `json:"deviceId"`
+	ProjectId       string `json:"projectId"`
+	Online          bool   `json:"online"`
+	LastSeen        int64  `json:"lastSeen"` // unix time, milliseconds
+}
+
+type DeviceStatusEvent struct {
+	DeviceModelName string `json:"tableName"`
+	DeviceId        string `json:"deviceId"`
+	ProjectId       string `json:"projectId"`
+	Online          bool   `json:"online"`
+	Time            int64  `json:"_ts"` // unix time, milliseconds
+}
+
+// deviceStatusKey identifies a device in a project, since the same device id may be in several projects
+type deviceStatusKey struct {
+	deviceId  string
+	projectId string
+}
+
+type DeviceUptime struct {
+	DeviceModelName string               `json:"tableName"`
+	DeviceId        string               `json:"deviceId"`
+	ProjectId       string               `json:"projectId"`
+	StartTime       int64                `json:"startTime"`
+	EndTime         int64                `json:"endTime"` // it is now if the range ends in the future
+	Uptime          int64                `json:"uptime"`  // milliseconds
+	Ratio           float64              `json:"ratio"`   // uptime of the range, from 0 to 1
+	Events          []*DeviceStatusEvent `json:"events"`  // events in the range
+}
+
+// deviceStatusBackend reads last seen times and stores status events of a client
+type deviceStatusBackend interface {
+	// lastSeen returns devices of a model with their last seen time, all devices if deviceIds is empty
+	lastSeen(ctx context.Context, deviceModelName string, projectId string, deviceIds []string) ([]*DeviceStatus, error)
+	// lastStatusEvents returns (device, project) -> the last recorded event of devices of a model
+	lastStatusEvents(ctx context.Context, deviceModelName string) (map[deviceStatusKey]*DeviceStatusEvent, error)
+	writeStatusEvents(ctx context.Context, events []*DeviceStatusEvent) error
+	// statusEvents returns the events of a device in a project in a range in ascending order of time,
+	// led by the last event before the range if there is one, in the latest project of the device if projectId is empty
+	statusEvents(
+		ctx context.Context,
+		deviceModelName string,
+		projectId string,
+		deviceId string,
+		startTime int64,
+		endTime int64,
+	) ([]*DeviceStatusEvent, error)
+}
+
+func trackDeviceStatus(
+	ctx context.Context,
+	backend deviceStatusBackend,
+	deviceModelName string,
+	realTimeWindow time.Duration,
+) ([]*DeviceStatusEvent, error) {
+	devices, err := backend.lastSeen(ctx, deviceModelName, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	lastEvents, err := backend.lastStatusEvents(ctx, deviceModelName)
+	if err != nil {
+		return nil, err
+	}
+	events := deviceStatusTransitions(devices, lastEvents, realTimeWindow, gtime.Now().UnixMilli())
+	if len(events) == 0 {
+		return events, nil
+	}
+	if err = backend.writeStatusEvents(ctx, events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func deviceStatusTransitions(
+	devices []*DeviceStatus,
+	lastEvents map[deviceStatusKey]*DeviceStatusEvent,
+	realTimeWindow time.Duration,
+	now int64,
+) []*DeviceStatusEvent {
+	window := realTimeWindow.Milliseconds()
+	events := make([]*DeviceStatusEvent, 0)
+	for _, device := range devices {
+		online := device.LastSeen >= now-window
+		lastEvent, ok := lastEvents[deviceStatusKey{deviceId: device.DeviceId, projectId: device.ProjectId}]
+		if ok && lastEvent.Online == online {
+			continue
+		}
+		eventTime := device.LastSeen
+		if !online {
+			eventTime = device.LastSeen + window
+		}
+		// late data may be older than the last event, events of a device must stay in order
+		if ok && eventTime <= lastEvent.Time {
+			eventTime = lastEvent.Time + 1
+		}
+		events = append(events, &DeviceStatusEvent{
+			DeviceModelName: device.DeviceModelName,
+			DeviceId:        device.DeviceId,
+			ProjectId:       device.ProjectId,
+			Online:          online,
+			Time:            eventTime,
+		})
+	}
+	return events
+}
+
+// lastSeenProjects returns deviceId -> the project a device is last seen in, the smaller project on a tie
+func lastSeenProjects(devices []*DeviceStatus) map[string]string {
+	out := make(map[string]string)
+	lastSeen := make(map[string]int64)
+	for _, device := range devices {
+		current, ok := out[device.DeviceId]
+		if ok && (device.LastSeen < lastSeen[device.DeviceId] ||
+			device.LastSeen == lastSeen[device.DeviceId] && device.ProjectId > current) {
+			continue
+		}
+		out[device.DeviceId] = device.ProjectId
+		lastSeen[device.DeviceId] = device.LastSeen
+	}
+	return out
+}
+
+func readDeviceStatus(
+	ctx context.Context,
+	backend deviceStatusBackend,
+	in ReadDeviceStatusInput,
+	realTimeWindow time.Duration,
+) ([]*DeviceStatus, error) {
+	devices, err := backend.lastSeen(ctx, in.DeviceModelName, in.ProjectId, in.DeviceIds)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*DeviceStatus, 0, len(devices))
+	for _, device := range devices {
+		device.Online = !isStale(device.LastSeen, realTimeWindow)
+		if in.OfflineOnly && device.Online {
+			continue
+		}
+		out = append(out, device)
+	}
+	return out, nil
+}
+
+func readDeviceUptime(ctx context.Context, backend deviceStatusBackend, in ReadDeviceUptimeInput) (*DeviceUptime, error) {
+	events, err := backend.statusEvents(ctx, in.DeviceModelName, in.ProjectId, in.DeviceId, in.StartTime, in.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	out := &DeviceUptime{
+		DeviceModelName: in.DeviceModelName,
+		DeviceId:        in.DeviceId,
+		ProjectId:       in.ProjectId,
+		StartTime:       in.StartTime,
+		EndTime:         min(in.EndTime, gtime.Now().UnixMilli()),
+		Events:          make([]*DeviceStatusEvent, 0, len(events)),
+	}
+	for _, event := range events {
+		if event.Time >= in.StartTime {
+			out.Events = append(out.Events, event)
+		}
+	}
+	if len(events) > 0 {
+		out.ProjectId = events[len(events)-1].ProjectId
+	}
+	out.Uptime = deviceUptime(events, out.StartTime, out.EndTime)
+	if out.EndTime > out.StartTime {
+		out.Ratio = float64(out.Uptime) / float64(out.EndTime-out.StartTime)
+	}
+	return out, nil
+}
+
+// deviceUptime sums the online time in a range, a device is offline before its first event
+func deviceUptime(events []*DeviceStatusEvent, startTime int64, endTime int64) (uptime int64) {
+	online := false
+	since := startTime
+	for _, event := range events {
+		eventTime := max(event.Time, startTime)
+		if eventTime > endTime {
+			break
+		}
+		if online {
+			uptime += eventTime - since
+		}
+		online = event.Online
+		since = eventTime
+	}
+	if online && endTime > since {
+		uptime += endTime - since
+	}
+	return uptime
+}
@@ -0,0 +1,74 @@
+package tsdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeviceStatusTransitions(t *testing.T) {
+	const now = int64(100_000)
+	window := 10 * time.Second
+	devices := []*DeviceStatus{
+		{DeviceModelName: "m1", DeviceId: "d1", ProjectId: "p1", LastSeen: now - 1_000},
+		{DeviceModelName: "m1", DeviceId: "d1", ProjectId: "p2", LastSeen: now - 20_000},
+		{DeviceModelName: "m1", DeviceId: "d2", LastSeen: now - 30_000},
+	}
+	lastEvents := map[deviceStatusKey]*DeviceStatusEvent{
+		// the same device id in two projects has its own status
+		{deviceId: "d1", projectId: "p1"}: {DeviceId: "d1", ProjectId: "p1", Online: true, Time: now - 50_000},
+		{deviceId: "d1", projectId: "p2"}: {DeviceId: "d1", ProjectId: "p2", Online: true, Time: now - 50_000},
+		// late data is older than the last event
+		{deviceId: "d2"}: {DeviceId: "d2", Online: true, Time: now - 15_000},
+	}
+	want := []*DeviceStatusEvent{
+		{DeviceModelName: "m1", DeviceId: "d1", ProjectId: "p2", Online: false, Time: now - 10_000},
+		{DeviceModelName: "m1", DeviceId: "d2", Online: false, Time: now - 15_000 + 1},
+	}
+	if got := deviceStatusTransitions(devices, lastEvents, window, now); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// devices without a last event get their first one
+	got := deviceStatusTransitions(devices, map[deviceStatusKey]*DeviceStatusEvent{}, window, now)
+	if len(got) != 3 || !got[0].Online || got[1].Online || got[2].Online {
+		t.Fatalf("got %+v, want an online event of d1 in p1 and offline events of the others", got)
+	}
+}
+
+func TestDeviceUptime(t *testing.T) {
+	cases := []struct {
+		name   string
+		events []*DeviceStatusEvent
+		want   int64
+	}{
+		{name: "no events", events: nil, want: 0},
+		{
+			name:   "online before the range",
+			events: []*DeviceStatusEvent{{Online: true, Time: 0}},
+			want:   100,
+		},
+		{
+			name: "online and offline in the range",
+			events: []*DeviceStatusEvent{
+				{Online: false, Time: 50},
+				{Online: true, Time: 120},
+				{Online: false, Time: 150},
+				{Online: true, Time: 180},
+			},
+			want: 50,
+		},
+		{
+			name:   "events after the range",
+			events: []*DeviceStatusEvent{{Online: true, Time: 190}, {Online: false, Time: 300}},
+			want:   10,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := deviceUptime(c.events, 100, 200); got != c.want {
+				t.Fatalf("got %d, want %d", got, c.want)
+			}
+		})
+	}
+}
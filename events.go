@@ -0,0 +1,105 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+/*
+	events are discrete records of devices, like an alarm raised or cleared, besides numeric points,
+	they are appended and never updated: to ack an alarm, write an event of the same code with Acked set.
+	in tdengine, events are in the alarm stable, an event replaces the event of the same device and time,
+	in redis, events of a device are in a stream, an event older than the last event of its device is rejected
+*/
+
+// EventStore is implemented by the clients that store events, use it by type assertion on GetClient()
+type EventStore interface {
+	WriteEvents(ctx context.Context, events []*Event) error
+	// ReadEvents returns a page of events in descending order of time, and the number of all matched events
+	ReadEvents(ctx context.Context, in ReadEventsInput) (events []*Event, total int, err error)
+}
+
+type Event struct {
+	DeviceModelName string `json:"tableName"`
+	DeviceId        string `json:"deviceId"`
+	ProjectId       string `json:"projectId"`
+	Time            int64  `json:"_ts"` // unix time, milliseconds
+	Severity        string `json:"severity"`
+	Code            string `json:"code"`
+	Message         string `json:"message"`
+	State           string `json:"state"` // raised or cleared
+	Acked           bool   `json:"acked"`
+}
+
+type ReadEventsInput struct {
+	DeviceModelName string `v:"required"`
+	ProjectId       string
+	DeviceIds       []string
+	StartTime       int64 `v:"required"`
+	EndTime         int64 `v:"required"`
+	// any of them if empty
+	Severities []string
+	States     []string
+	// both acked and not acked events if nil
+	Acked *bool
+	// all events if Limit is 0
+	Limit  int
+	Offset int
+}
+
+func validateEvent(event *Event) error {
+	if event.DeviceModelName == "" || event.DeviceId == "" {
+		return fmt.Errorf("model and device of an event are required")
+	}
+	if event.Time <= 0 {
+		return fmt.Errorf("time of an event is required")
+	}
+	if event.State != EventStateRaised && event.State != EventStateCleared {
+		return fmt.Errorf("unsupported event state: %s", event.State)
+	}
+	return nil
+}
+
+// match tells whether an event passes the filters other than time and device
+func (s ReadEventsInput) match(event *Event) bool {
+	if len(s.Severities) > 0 && !slices.Contains(s.Severities, event.Severity) {
+		return false
+	}
+	if len(s.States) > 0 && !slices.Contains(s.States, event.State) {
+		return false
+	}
+	if s.Acked != nil && *s.Acked != event.Acked {
+		return false
+	}
+	return true
+}
+
+// pageEvents sorts events in descending order of time and returns a page of them
+func pageEvents(events []*Event, offset int, limit int) []*Event {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Time != events[j].Time {
+			return events[i].Time > events[j].Time
+		}
+		return events[i].DeviceId < events[j].DeviceId
+	})
+	if offset >= len(events) {
+		return make([]*Event, 0)
+	}
+	events = events[max(offset, 0):]
+	if limit > 0 && limit < len(events) {
+		events = events[:limit]
+	}
+	return events
+}
+
+// lineProtocolStringEscaper escapes string field values, only quotes and backslashes are escaped in quoted strings,
+// newlines are kept as they are
+var lineProtocolStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// lineProtocolString quotes a string field value of the influxdb line protocol
+func lineProtocolString(in string) string {
+	return `"` + lineProtocolStringEscaper.Replace(in) + `"`
+}
@@ -0,0 +1,146 @@
+package tsdb
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// ApplyFill fills the empty windows (nil values) of aligned series with the same semantics as tdengine FILL,
+// it is for the backends that cannot fill natively.
+// seriesData are ordered by devices and then by point codes, every pointCount series are the partition of a device,
+// as in tdengine, a partition without any data is only filled by the forced modes,
+// and VALUE/VALUE_F must have one value for each point code.
+func ApplyFill(
+	seriesData [][]any,
+	timestamps []int64,
+	fillType string,
+	pointCount int,
+) ([][]any, []int64, error) {
+	option, err := parseFillOption(fillType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pointCount <= 0 || len(seriesData)%pointCount != 0 {
+		return nil, nil, fmt.Errorf("%d series cannot be partitioned by %d point codes", len(seriesData), pointCount)
+	}
+	isForced := option.Mode == fillNullF || option.Mode == fillValueF
+	if (option.Mode == fillValue || option.Mode == fillValueF) && len(option.Values) != pointCount {
+		return nil, nil, fmt.Errorf("fill option %s has %d values for %d point codes", option.Mode, len(option.Values), pointCount)
+	}
+
+	if !isForced && !slices.ContainsFunc(seriesData, hasValue) {
+		// only the forced modes fill a range without any data
+		emptySeriesData := make([][]any, len(seriesData))
+		for i := range emptySeriesData {
+			emptySeriesData[i] = make([]any, 0)
+		}
+		return emptySeriesData, make([]int64, 0), nil
+	}
+
+	switch option.Mode {
+	case fillNone:
+		return removeEmptyWindows(seriesData, timestamps)
+	case fillNull, fillNullF:
+		return seriesData, timestamps, nil
+	}
+	for partitionStart := 0; partitionStart < len(seriesData); partitionStart += pointCount {
+		partition := seriesData[partitionStart : partitionStart+pointCount]
+		if !isForced && !slices.ContainsFunc(partition, hasValue) {
+			// tdengine returns no windows of a device without data, they are left empty
+			continue
+		}
+		for pointIdx, series := range partition {
+			fillSeries(series, timestamps, option, pointIdx)
+		}
+	}
+	return seriesData, timestamps, nil
+}
+
+func fillSeries(series []any, timestamps []int64, option fillOption, pointIdx int) {
+	switch option.Mode {
+	case fillValue, fillValueF:
+		value := option.Values[pointIdx]
+		for j := range series {
+			if series[j] == nil {
+				series[j] = value
+			}
+		}
+	case fillPrev:
+		var prev any
+		for j := range series {
+			if series[j] == nil {
+				series[j] = prev
+			} else {
+				prev = series[j]
+			}
+		}
+	case fillNext:
+		var next any
+		for j := len(series) - 1; j >= 0; j-- {
+			if series[j] == nil {
+				series[j] = next
+			} else {
+				next = series[j]
+			}
+		}
+	case fillLinear:
+		fillLinearSeries(series, timestamps)
+	}
+}
+
+func hasValue(series []any) bool {
+	return slices.ContainsFunc(series, func(v any) bool { return v != nil })
+}
+
+func removeEmptyWindows(seriesData [][]any, timestamps []int64) ([][]any, []int64, error) {
+	keptTimestamps := make([]int64, 0, len(timestamps))
+	keptSeriesData := make([][]any, len(seriesData))
+	for i := range keptSeriesData {
+		keptSeriesData[i] = make([]any, 0, len(timestamps))
+	}
+	for j, ts := range timestamps {
+		isEmpty := true
+		for _, series := range seriesData {
+			if series[j] != nil {
+				isEmpty = false
+				break
+			}
+		}
+		if isEmpty {
+			continue
+		}
+		keptTimestamps = append(keptTimestamps, ts)
+		for i, series := range seriesData {
+			keptSeriesData[i] = append(keptSeriesData[i], series[j])
+		}
+	}
+	return keptSeriesData, keptTimestamps, nil
+}
+
+func fillLinearSeries(series []any, timestamps []int64) {
+	// windows before the first value and after the last value stay nil
+	prevIdx := -1
+	for j := range series {
+		if series[j] == nil {
+			continue
+		}
+		if prevIdx >= 0 && j-prevIdx > 1 && isNumericValue(series[prevIdx]) && isNumericValue(series[j]) {
+			fromValue, toValue := gconv.Float64(series[prevIdx]), gconv.Float64(series[j])
+			fromTs, toTs := timestamps[prevIdx], timestamps[j]
+			for k := prevIdx + 1; k < j; k++ {
+				series[k] = fromValue + (toValue-fromValue)*float64(timestamps[k]-fromTs)/float64(toTs-fromTs)
+			}
+		}
+		prevIdx = j
+	}
+}
+
+func isNumericValue(v any) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
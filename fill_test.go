@@ -0,0 +1,174 @@
+package tsdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyFill(t *testing.T) {
+	timestamps := []int64{0, 10, 20, 30, 40}
+	// two devices of two points, d2 has no data
+	newSeriesData := func() [][]any {
+		return [][]any{
+			{nil, 1.0, nil, 3.0, nil}, // d1 p1
+			{nil, nil, 5.0, nil, nil}, // d1 p2
+			{nil, nil, nil, nil, nil}, // d2 p1
+			{nil, nil, nil, nil, nil}, // d2 p2
+		}
+	}
+	newEmptySeriesData := func() [][]any {
+		return [][]any{
+			{nil, nil, nil, nil, nil},
+			{nil, nil, nil, nil, nil},
+			{nil, nil, nil, nil, nil},
+			{nil, nil, nil, nil, nil},
+		}
+	}
+	empty := [][]any{{}, {}, {}, {}}
+	cases := []struct {
+		name           string
+		seriesData     [][]any
+		fill           string
+		wantSeriesData [][]any
+		wantTimestamps []int64
+		wantErr        bool
+	}{
+		{
+			name:       "none removes windows empty in all series",
+			seriesData: newSeriesData(),
+			fill:       "NONE",
+			wantSeriesData: [][]any{
+				{1.0, nil, 3.0},
+				{nil, 5.0, nil},
+				{nil, nil, nil},
+				{nil, nil, nil},
+			},
+			wantTimestamps: []int64{10, 20, 30},
+		},
+		{
+			name:           "null keeps empty windows",
+			seriesData:     newSeriesData(),
+			fill:           "NULL",
+			wantSeriesData: newSeriesData(),
+			wantTimestamps: timestamps,
+		},
+		{
+			name:       "value fills each point with its value, not the partition without data",
+			seriesData: newSeriesData(),
+			fill:       "VALUE(0, -1)",
+			wantSeriesData: [][]any{
+				{0.0, 1.0, 0.0, 3.0, 0.0},
+				{-1.0, -1.0, 5.0, -1.0, -1.0},
+				{nil, nil, nil, nil, nil},
+				{nil, nil, nil, nil, nil},
+			},
+			wantTimestamps: timestamps,
+		},
+		{
+			name:       "value_f fills the partition without data",
+			seriesData: newSeriesData(),
+			fill:       "VALUE_F, 0, -1",
+			wantSeriesData: [][]any{
+				{0.0, 1.0, 0.0, 3.0, 0.0},
+				{-1.0, -1.0, 5.0, -1.0, -1.0},
+				{0.0, 0.0, 0.0, 0.0, 0.0},
+				{-1.0, -1.0, -1.0, -1.0, -1.0},
+			},
+			wantTimestamps: timestamps,
+		},
+		{
+			name:       "prev",
+			seriesData: newSeriesData(),
+			fill:       "PREV",
+			wantSeriesData: [][]any{
+				{nil, 1.0, 1.0, 3.0, 3.0},
+				{nil, nil, 5.0, 5.0, 5.0},
+				{nil, nil, nil, nil, nil},
+				{nil, nil, nil, nil, nil},
+			},
+			wantTimestamps: timestamps,
+		},
+		{
+			name:       "next",
+			seriesData: newSeriesData(),
+			fill:       "NEXT",
+			wantSeriesData: [][]any{
+				{1.0, 1.0, 3.0, 3.0, nil},
+				{5.0, 5.0, 5.0, nil, nil},
+				{nil, nil, nil, nil, nil},
+				{nil, nil, nil, nil, nil},
+			},
+			wantTimestamps: timestamps,
+		},
+		{
+			name:       "linear only fills between values",
+			seriesData: newSeriesData(),
+			fill:       "LINEAR",
+			wantSeriesData: [][]any{
+				{nil, 1.0, 2.0, 3.0, nil},
+				{nil, nil, 5.0, nil, nil},
+				{nil, nil, nil, nil, nil},
+				{nil, nil, nil, nil, nil},
+			},
+			wantTimestamps: timestamps,
+		},
+		{
+			name:           "no data is not filled",
+			seriesData:     newEmptySeriesData(),
+			fill:           "VALUE(0, 0)",
+			wantSeriesData: empty,
+			wantTimestamps: []int64{},
+		},
+		{
+			name:           "no data is filled by null_f",
+			seriesData:     newEmptySeriesData(),
+			fill:           "NULL_F",
+			wantSeriesData: newEmptySeriesData(),
+			wantTimestamps: timestamps,
+		},
+		{
+			name:       "fewer values than point codes",
+			seriesData: newSeriesData(),
+			fill:       "VALUE(0)",
+			wantErr:    true,
+		},
+		{
+			name:       "more values than point codes",
+			seriesData: newSeriesData(),
+			fill:       "VALUE(0, 1, 2)",
+			wantErr:    true,
+		},
+		{
+			name:       "invalid mode",
+			seriesData: newSeriesData(),
+			fill:       "AVG",
+			wantErr:    true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gotSeriesData, gotTimestamps, err := ApplyFill(c.seriesData, timestamps, c.fill, 2)
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(gotSeriesData, c.wantSeriesData) {
+				t.Fatalf("got series %v, want %v", gotSeriesData, c.wantSeriesData)
+			}
+			if !reflect.DeepEqual(gotTimestamps, c.wantTimestamps) {
+				t.Fatalf("got timestamps %v, want %v", gotTimestamps, c.wantTimestamps)
+			}
+		})
+	}
+}
+
+func TestApplyFillInvalidPointCount(t *testing.T) {
+	if _, _, err := ApplyFill([][]any{{nil}, {nil}, {nil}}, []int64{0}, "NULL", 2); err == nil {
+		t.Fatal("expected an error of 3 series of 2 point codes")
+	}
+}
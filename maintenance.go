@@ -0,0 +1,226 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gcron"
+	"github.com/gogf/gf/v2/os/gtime"
+)
+
+/*
+	maintenance jobs, like trimming and rollups, are run by only one instance at a time:
+	the instance holding the leader lease runs the jobs, other instances skip them,
+	the lease is renewed periodically and during a job run, it expires if the leader stops,
+	a job run is canceled once its node loses the lease, and a stopped scheduler releases the lease at once
+*/
+
+// MaintenanceReporter is implemented by the clients that run maintenance jobs, use it by type assertion on GetClient()
+type MaintenanceReporter interface {
+	MaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error)
+}
+
+type MaintenanceStatus struct {
+	NodeId string       `json:"nodeId"` // this instance
+	Leader string       `json:"leader"` // empty if no instance holds the lease now
+	Jobs   []*JobStatus `json:"jobs"`
+}
+
+type JobStatus struct {
+	Name         string      `json:"name"`
+	NodeId       string      `json:"nodeId"` // the instance of the last run
+	LastRunAt    *gtime.Time `json:"lastRunAt"`
+	LastDuration int64       `json:"lastDuration"` // milliseconds
+	LastError    string      `json:"lastError"`
+}
+
+// maintenanceBackend stores the leader lease and job statuses, so that all instances share them
+type maintenanceBackend interface {
+	// TryLead acquires or renews the lease, it returns false if another node holds the lease
+	TryLead(ctx context.Context, nodeId string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if nodeId holds it, so that another node can take over without waiting
+	Release(ctx context.Context, nodeId string) error
+	Leader(ctx context.Context) (string, error)
+	SaveJobStatus(ctx context.Context, status *JobStatus) error
+	JobStatuses(ctx context.Context) ([]*JobStatus, error)
+}
+
+type maintenanceJob struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// maintenanceSchedulerSeq numbers schedulers, gcron entry names are global, so each scheduler has its own names
+var maintenanceSchedulerSeq atomic.Int64
+
+// errMaintenanceLeaseLost is the cause of canceling a job run whose node cannot renew the lease
+var errMaintenanceLeaseLost = errors.New("maintenance lease lost")
+
+type maintenanceScheduler struct {
+	nodeId        string
+	backend       maintenanceBackend
+	jobs          []*maintenanceJob
+	seq           int64
+	cronNames     []string
+	renewInterval time.Duration
+	stopCtx       context.Context // done once stopped, it cancels the running jobs
+	stop          context.CancelFunc
+	sync.Mutex
+}
+
+func newMaintenanceScheduler(nodeId string, backend maintenanceBackend) *maintenanceScheduler {
+	if nodeId == "" {
+		hostname, _ := os.Hostname()
+		nodeId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	stopCtx, stop := context.WithCancel(context.Background())
+	return &maintenanceScheduler{
+		nodeId:        nodeId,
+		backend:       backend,
+		seq:           maintenanceSchedulerSeq.Add(1),
+		renewInterval: maintenanceLeaseRenewInterval,
+		stopCtx:       stopCtx,
+		stop:          stop,
+	}
+}
+
+// addCron adds a singleton cron entry named after this scheduler
+func (s *maintenanceScheduler) addCron(ctx context.Context, interval time.Duration, job func(ctx context.Context), name string) error {
+	cronName := fmt.Sprintf("%s-%d", name, s.seq)
+	if _, err := gcron.AddSingleton(ctx, fmt.Sprintf("@every %s", interval), job, cronName); err != nil {
+		return err
+	}
+	s.cronNames = append(s.cronNames, cronName)
+	return nil
+}
+
+// Stop removes the cron entries of this scheduler, so that a client can be initialized again,
+// it cancels the running jobs and releases the lease, so that another node takes over at its next run
+func (s *maintenanceScheduler) Stop(ctx context.Context) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, cronName := range s.cronNames {
+		gcron.Remove(cronName)
+	}
+	s.cronNames = nil
+	s.jobs = nil
+	s.stop()
+	if err := s.backend.Release(ctx, s.nodeId); err != nil {
+		g.Log().Errorf(ctx, "maintenance lease release error: %v", err)
+	}
+}
+
+// Start renews the lease in background, so that the leader keeps its lease between job runs
+func (s *maintenanceScheduler) Start(ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.addCron(ctx, maintenanceLeaseRenewInterval, func(ctx context.Context) {
+		if _, innErr := s.backend.TryLead(ctx, s.nodeId, maintenanceLeaseDuration); innErr != nil {
+			g.Log().Errorf(ctx, "maintenance lease error: %v", innErr)
+		}
+	}, maintenanceLeaseCronName)
+}
+
+func (s *maintenanceScheduler) AddJob(ctx context.Context, name string, interval time.Duration, run func(ctx context.Context) error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	job := &maintenanceJob{name: name, interval: interval, run: run}
+	// singleton prevents overlap in this instance, the lease prevents overlap between instances
+	err := s.addCron(ctx, interval, func(ctx context.Context) {
+		s.runJob(ctx, job)
+	}, name)
+	if err != nil {
+		return err
+	}
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+func (s *maintenanceScheduler) runJob(ctx context.Context, job *maintenanceJob) {
+	isLeader, err := s.backend.TryLead(ctx, s.nodeId, maintenanceLeaseDuration)
+	if err != nil {
+		g.Log().Errorf(ctx, "maintenance lease error: %v", err)
+		return
+	}
+	if !isLeader {
+		return
+	}
+
+	// keep the lease during long runs, and stop the run once the lease is lost,
+	// since another node may take over and run the same job
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stopAfter := context.AfterFunc(s.stopCtx, func() {
+		cancel(context.Canceled)
+	})
+	defer stopAfter()
+	go func() {
+		ticker := time.NewTicker(s.renewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+				renewed, innErr := s.backend.TryLead(runCtx, s.nodeId, maintenanceLeaseDuration)
+				if runCtx.Err() != nil {
+					return
+				}
+				if innErr != nil {
+					cancel(fmt.Errorf("%w: %w", errMaintenanceLeaseLost, innErr))
+					return
+				}
+				if !renewed {
+					cancel(errMaintenanceLeaseLost)
+					return
+				}
+			}
+		}
+	}()
+
+	startTime := gtime.Now()
+	runErr := job.run(runCtx)
+	if cause := context.Cause(runCtx); errors.Is(cause, errMaintenanceLeaseLost) {
+		g.Log().Errorf(ctx, "maintenance job [ %s ] is canceled: %v", job.name, cause)
+		runErr = cause
+	}
+	status := &JobStatus{
+		Name:         job.name,
+		NodeId:       s.nodeId,
+		LastRunAt:    startTime,
+		LastDuration: gtime.Now().Sub(startTime).Milliseconds(),
+	}
+	if runErr != nil {
+		status.LastError = runErr.Error()
+		g.Log().Errorf(ctx, "maintenance job [ %s ] error: %v", job.name, runErr)
+	}
+	if err = s.backend.SaveJobStatus(ctx, status); err != nil {
+		g.Log().Errorf(ctx, "maintenance job status error: %v", err)
+	}
+}
+
+func (s *maintenanceScheduler) Status(ctx context.Context) (*MaintenanceStatus, error) {
+	leader, err := s.backend.Leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := s.backend.JobStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &MaintenanceStatus{
+		NodeId: s.nodeId,
+		Leader: leader,
+		Jobs:   jobs,
+	}, nil
+}
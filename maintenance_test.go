@@ -0,0 +1,207 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/os/gcron"
+)
+
+// memoryMaintenanceBackend keeps the lease in memory, the lease does not expire
+type memoryMaintenanceBackend struct {
+	leader   string
+	statuses map[string]*JobStatus
+	leadErr  error
+	sync.Mutex
+}
+
+func newMemoryMaintenanceBackend() *memoryMaintenanceBackend {
+	return &memoryMaintenanceBackend{statuses: make(map[string]*JobStatus)}
+}
+
+func (s *memoryMaintenanceBackend) TryLead(_ context.Context, nodeId string, _ time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.leadErr != nil {
+		return false, s.leadErr
+	}
+	if s.leader == "" {
+		s.leader = nodeId
+	}
+	return s.leader == nodeId, nil
+}
+
+func (s *memoryMaintenanceBackend) Release(_ context.Context, nodeId string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.leader == nodeId {
+		s.leader = ""
+	}
+	return nil
+}
+
+func (s *memoryMaintenanceBackend) Leader(context.Context) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.leader, nil
+}
+
+// setLeader hands the lease over as if it expired and another node took it, or fails the renewal by leadErr
+func (s *memoryMaintenanceBackend) setLeader(leader string, leadErr error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.leader = leader
+	s.leadErr = leadErr
+}
+
+func (s *memoryMaintenanceBackend) SaveJobStatus(_ context.Context, status *JobStatus) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.statuses[status.Name] = status
+	return nil
+}
+
+func (s *memoryMaintenanceBackend) JobStatuses(context.Context) ([]*JobStatus, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	statuses := make([]*JobStatus, 0, len(s.statuses))
+	for _, status := range s.statuses {
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func TestMaintenanceSchedulerCronNames(t *testing.T) {
+	ctx := context.Background()
+	noop := func(context.Context) error { return nil }
+	schedulers := []*maintenanceScheduler{
+		newMaintenanceScheduler("n1", newMemoryMaintenanceBackend()),
+		newMaintenanceScheduler("n1", newMemoryMaintenanceBackend()),
+	}
+	// the same jobs of two clients, or of a client initialized again, do not conflict
+	for _, scheduler := range schedulers {
+		if err := scheduler.Start(ctx); err != nil {
+			t.Fatalf("unexpected error of start: %v", err)
+		}
+		if err := scheduler.AddJob(ctx, "TestJob", time.Hour, noop); err != nil {
+			t.Fatalf("unexpected error of adding a job: %v", err)
+		}
+	}
+	for _, scheduler := range schedulers {
+		cronNames := scheduler.cronNames
+		scheduler.Stop(ctx)
+		for _, cronName := range cronNames {
+			if gcron.Search(cronName) != nil {
+				t.Fatalf("cron entry [ %s ] is not removed", cronName)
+			}
+		}
+	}
+}
+
+func TestMaintenanceJobLeaseLost(t *testing.T) {
+	cases := []struct {
+		name    string
+		leader  string
+		leadErr error
+	}{
+		{name: "another node takes the lease", leader: "n2"},
+		{name: "the lease cannot be renewed", leader: "n1", leadErr: errors.New("connection refused")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			backend := newMemoryMaintenanceBackend()
+			scheduler := newMaintenanceScheduler("n1", backend)
+			scheduler.renewInterval = 10 * time.Millisecond
+			job := &maintenanceJob{name: "TestJob", run: func(ctx context.Context) error {
+				// the job runs until it is canceled
+				backend.setLeader(c.leader, c.leadErr)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(5 * time.Second):
+					return nil
+				}
+			}}
+			scheduler.runJob(ctx, job)
+
+			statuses, _ := backend.JobStatuses(ctx)
+			if len(statuses) != 1 || !strings.Contains(statuses[0].LastError, errMaintenanceLeaseLost.Error()) {
+				t.Fatalf("got statuses %+v, want an error of the lost lease", statuses)
+			}
+			if statuses[0].LastDuration >= 5000 {
+				t.Fatalf("the job is not canceled once the lease is lost")
+			}
+		})
+	}
+}
+
+func TestMaintenanceLeaseHandover(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemoryMaintenanceBackend()
+	var runNodes []string
+	newJob := func(nodeId string) *maintenanceJob {
+		return &maintenanceJob{name: "TestJob", run: func(context.Context) error {
+			runNodes = append(runNodes, nodeId)
+			return nil
+		}}
+	}
+	n1 := newMaintenanceScheduler("n1", backend)
+	n2 := newMaintenanceScheduler("n2", backend)
+
+	n1.runJob(ctx, newJob("n1"))
+	n2.runJob(ctx, newJob("n2"))
+	if leader, _ := backend.Leader(ctx); leader != "n1" {
+		t.Fatalf("got leader %q, want n1", leader)
+	}
+	// a stopped node releases the lease, the other node takes over at its next run
+	n1.Stop(ctx)
+	if leader, _ := backend.Leader(ctx); leader != "" {
+		t.Fatalf("got leader %q after stop, want the lease released", leader)
+	}
+	n2.runJob(ctx, newJob("n2"))
+	n1.runJob(ctx, newJob("n1"))
+	if leader, _ := backend.Leader(ctx); leader != "n2" {
+		t.Fatalf("got leader %q, want n2", leader)
+	}
+	if want := []string{"n1", "n2"}; strings.Join(runNodes, ",") != strings.Join(want, ",") {
+		t.Fatalf("got runs of %v, want %v", runNodes, want)
+	}
+	n2.Stop(ctx)
+}
+
+func TestMaintenanceStopCancelsJob(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemoryMaintenanceBackend()
+	scheduler := newMaintenanceScheduler("n1", backend)
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		scheduler.runJob(ctx, &maintenanceJob{name: "TestJob", run: func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			done <- ctx.Err()
+			return ctx.Err()
+		}})
+	}()
+	<-started
+	scheduler.Stop(ctx)
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got error %v, want canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the running job is not canceled by stop")
+	}
+}
@@ -1,6 +1,8 @@
 package tsdb
 
 import (
+	"fmt"
+
 	"github.com/gogf/gf/v2/os/gtime"
 )
 
@@ -12,6 +14,44 @@ type Config struct {
 	Database       string
 	DataKeep       string
 	RealTimeWindow string
+	// redis only, how long the latest data are kept for last known values, DataKeep by default, "0" to keep them forever,
+	// it is at least RealTimeWindow
+	LatestKeep string
+	// identifies this instance for maintenance leader election, hostname-pid by default
+	NodeId string
+	// remove literals from the statements attached to trace spans
+	RedactStatement bool
+	// tdengine only, options of the database, see tdengine_database.go
+	TdengineDatabase TdengineDatabaseOptions
+	// redis only, 1 by default, 2 is required to serve multiple projects, see redis_key.go
+	RedisKeyVersion int
+	// redis only, prepended to all keys of version 2, so that redis can be shared with other apps
+	RedisKeyPrefix string
+	// redis only, "stream" by default, "zset" accepts out of order and duplicate timestamps,
+	// "device" keeps all points of a device in one stream, see redis.go
+	RedisSeriesLayout string
+	// redis only, roll up series into windows of 1m and 1h, so that long ranges can be read, see redis_rollup.go
+	RedisRollups bool
+	// redis only, retention of 1m rollups, 1d by default, it is at least DataKeep
+	RedisRollupKeepMinute string
+	// redis only, retention of 1h rollups, 30d by default, it is at least the retention of 1m rollups
+	RedisRollupKeepHour string
+	// redis only, data later than this are still rolled up, windows within it before the last rollup are computed again,
+	// "0" by default, which only computes the last window again, see redis_rollup.go
+	RedisRollupLateWindow string
+	// redis only, name of the goframe redis group, the default group by default
+	RedisGroup string
+	// redis only, wrap the device part of keys in a hash tag, so that keys of a device are in one slot of a redis cluster,
+	// it requires key version 2
+	RedisClusterMode bool
+	// redis only, data of devices are sharded across these goframe redis groups by consistent hashing,
+	// keys shared by devices stay in RedisGroup, see redis_shard.go
+	RedisShardGroups []string
+	// redis only, interval to sweep streams without TTL, DataKeep by default, "0" to disable
+	RedisSweepInterval string
+	// redis only, Write returns a RedisBatchError if some commands failed, e.g. out of order data of the stream layout,
+	// they are only logged by default
+	RedisStrictWrite bool
 }
 
 type ReadDeviceLatestDataInput struct {
@@ -21,15 +61,87 @@ type ReadDeviceLatestDataInput struct {
 	DeviceIds                   []string
 	HaveProjectIdInResult       bool
 	HaveDeviceModelNameInResult bool
+	// return the last known values regardless of age, with their timestamp in "_ts" and "isStale",
+	// values older than RealTimeWindow are stale, they are omitted if it is false
+	LastKnownValue bool
+}
+type ReadDeviceStatusInput struct {
+	DeviceModelName string `v:"required"`
+	ProjectId       string
+	DeviceIds       []string
+	// return offline devices only
+	OfflineOnly bool
 }
+
+type ReadDeviceUptimeInput struct {
+	DeviceModelName string `v:"required"`
+	DeviceId        string `v:"required"`
+	// the project of the device, its latest project if it is empty
+	ProjectId string
+	StartTime int64 `v:"required"`
+	EndTime   int64 `v:"required"`
+}
+
 type ReadDeviceSeriesDataInput struct {
 	DeviceIds       []string `v:"required"`
 	DeviceModelName string   `v:"required"`
+	ProjectId       string
 	PointCodes      []string `v:"required"`
 	StartTime       int64    `v:"required"`
 	EndTime         int64    `v:"required"`
 	Interval        string   `v:"required"`
 	FillOption      string
+	// avg, min, max, sum, count, first, last, spread, stddev, twa or percentile(n), last by default
+	Aggregation string
+	// overrides Aggregation for the given point codes
+	PointAggregations map[string]string
+}
+
+// aggregations returns the aggregation of each point code in order
+func (s ReadDeviceSeriesDataInput) aggregations() ([]Aggregation, error) {
+	defaultAggregation, err := ParseAggregation(s.Aggregation)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Aggregation, 0, len(s.PointCodes))
+	for _, pointCode := range s.PointCodes {
+		pointAggregation, ok := s.PointAggregations[pointCode]
+		if !ok {
+			out = append(out, defaultAggregation)
+			continue
+		}
+		aggregation, innErr := ParseAggregation(pointAggregation)
+		if innErr != nil {
+			return nil, innErr
+		}
+		out = append(out, aggregation)
+	}
+	return out, nil
+}
+
+// validateDeviceIds rejects duplicate device ids, since results are ordered by DeviceIds
+func (s ReadDeviceSeriesDataInput) validateDeviceIds() error {
+	seen := make(map[string]struct{}, len(s.DeviceIds))
+	for _, deviceId := range s.DeviceIds {
+		if _, ok := seen[deviceId]; ok {
+			return fmt.Errorf("duplicate deviceId: %s", deviceId)
+		}
+		seen[deviceId] = struct{}{}
+	}
+	return nil
+}
+
+// SeriesResult is one windowed series of a device point, results are ordered by DeviceIds and then PointCodes
+type SeriesResult struct {
+	DeviceModelName string  `json:"tableName"`
+	DeviceId        string  `json:"deviceId"`
+	ProjectId       string  `json:"projectId"`
+	PointCode       string  `json:"pointCode"`
+	WindowStarts    []int64 `json:"_wstart"` // unix time, milliseconds
+	WindowEnds      []int64 `json:"_wend"`   // unix time, milliseconds
+	Values          []any   `json:"values"`
+	// redis only, entries of the range skipped because they cannot be decoded
+	Malformed int `json:"malformed,omitempty"`
 }
 
 type MetricTag struct {
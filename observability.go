@@ -0,0 +1,187 @@
+package tsdb
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/net/gtrace"
+	"github.com/gogf/gf/v2/os/gmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentName            = "github.com/mayugene/tsdb"
+	traceAttrKeyBackend       = "tsdb.backend"
+	traceAttrKeyOperation     = "tsdb.operation"
+	traceAttrKeyDeviceModel   = "tsdb.device_model"
+	traceAttrKeyPointCount    = "tsdb.point_count"
+	traceAttrKeyStatement     = "db.statement"
+	traceAttrKeyRedisKey      = "db.redis.key"
+	traceAttrKeyMalformed     = "tsdb.malformed_entries"
+	traceEventStatement       = "statement"
+	metricAttrKeyBackend      = "tsdb.backend"
+	metricAttrKeyOperation    = "tsdb.operation"
+	operationWrite            = "Write"
+	operationReadToMap        = "ReadToMap"
+	operationReadSeries       = "ReadSeries"
+	operationCreateSTable     = "CreateSTable"
+	operationDescribeSTable   = "DescribeSTable"
+	operationListSTables      = "ListSTables"
+	operationAlterSTable      = "AlterSTable"
+	operationDropSTable       = "DropSTable"
+	operationTrackStatus      = "TrackDeviceStatus"
+	operationReadStatus       = "ReadDeviceStatus"
+	operationReadUptime       = "ReadDeviceUptime"
+	operationWriteEvents      = "WriteEvents"
+	operationReadEvents       = "ReadEvents"
+	statementRedactedLiteral  = "?"
+	statementMaxTraceByteSize = 4096
+)
+
+var (
+	metricManager = newMetricManager()
+	// string literals and numbers are replaced when statements are redacted
+	statementLiteralRegex = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|\b\d+(?:\.\d+)?\b`)
+)
+
+type localMetricManager struct {
+	WritePoints       gmetric.Counter
+	WriteBytes        gmetric.Counter
+	OperationDuration gmetric.Histogram
+	OperationErrors   gmetric.Counter
+}
+
+func newMetricManager() *localMetricManager {
+	meter := gmetric.GetGlobalProvider().Meter(gmetric.MeterOption{
+		Instrument: instrumentName,
+	})
+	return &localMetricManager{
+		WritePoints: meter.MustCounter(
+			"tsdb.client.write.points",
+			gmetric.MetricOption{
+				Help: "Total number of points written.",
+				Unit: "",
+			},
+		),
+		WriteBytes: meter.MustCounter(
+			"tsdb.client.write.bytes",
+			gmetric.MetricOption{
+				Help: "Total payload bytes written.",
+				Unit: "bytes",
+			},
+		),
+		OperationDuration: meter.MustHistogram(
+			"tsdb.client.operation.duration",
+			gmetric.MetricOption{
+				Help:    "Measures the duration of client operations.",
+				Unit:    "s",
+				Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			},
+		),
+		OperationErrors: meter.MustCounter(
+			"tsdb.client.operation.errors",
+			gmetric.MetricOption{
+				Help: "Total number of failed client operations.",
+				Unit: "",
+			},
+		),
+	}
+}
+
+func (m *localMetricManager) option(clientType ClientType, operation string) gmetric.Option {
+	return gmetric.Option{
+		Attributes: gmetric.Attributes{
+			gmetric.NewAttribute(metricAttrKeyBackend, string(clientType)),
+			gmetric.NewAttribute(metricAttrKeyOperation, operation),
+		},
+	}
+}
+
+// operationObserver records the span and metrics of one client operation
+type operationObserver struct {
+	span       *gtrace.Span
+	clientType ClientType
+	operation  string
+	startTime  time.Time
+}
+
+func startOperation(
+	ctx context.Context,
+	clientType ClientType,
+	operation string,
+	deviceModelName string,
+	pointCount int,
+) (context.Context, *operationObserver) {
+	ctx, span := gtrace.NewSpan(ctx, "tsdb."+operation, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		attribute.String(traceAttrKeyBackend, string(clientType)),
+		attribute.String(traceAttrKeyOperation, operation),
+		attribute.Int(traceAttrKeyPointCount, pointCount),
+	)
+	if deviceModelName != "" {
+		span.SetAttributes(attribute.String(traceAttrKeyDeviceModel, deviceModelName))
+	}
+	return ctx, &operationObserver{
+		span:       span,
+		clientType: clientType,
+		operation:  operation,
+		startTime:  time.Now(),
+	}
+}
+
+func (o *operationObserver) End(ctx context.Context, err error) {
+	option := metricManager.option(o.clientType, o.operation)
+	metricManager.OperationDuration.Record(time.Since(o.startTime).Seconds(), option)
+	if err != nil {
+		metricManager.OperationErrors.Inc(ctx, option)
+		o.span.RecordError(err)
+		o.span.SetStatus(codes.Error, err.Error())
+	}
+	o.span.End()
+}
+
+// RecordWrite counts written points and bytes, it is called after the server accepts them
+func (o *operationObserver) RecordWrite(ctx context.Context, points int, bytes int) {
+	option := metricManager.option(o.clientType, o.operation)
+	metricManager.WritePoints.Add(ctx, float64(points), option)
+	metricManager.WriteBytes.Add(ctx, float64(bytes), option)
+}
+
+// addSpanStatement records the statement as an event of the span in ctx, since an operation may run several statements,
+// literals are removed if redact is true, before the statement is cut to the size limit
+func addSpanStatement(ctx context.Context, statement string, redact bool) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	if redact {
+		statement = RedactStatement(statement)
+	}
+	if len(statement) > statementMaxTraceByteSize {
+		statement = strings.ToValidUTF8(statement[:statementMaxTraceByteSize], "")
+	}
+	span.AddEvent(traceEventStatement, trace.WithAttributes(attribute.String(traceAttrKeyStatement, statement)))
+}
+
+// addSpanMalformed records the entries of a key skipped because they cannot be decoded on the span in ctx
+func addSpanMalformed(ctx context.Context, key string, malformed int) {
+	trace.SpanFromContext(ctx).AddEvent("malformed entries skipped", trace.WithAttributes(
+		attribute.String(traceAttrKeyRedisKey, key),
+		attribute.Int(traceAttrKeyMalformed, malformed),
+	))
+}
+
+func RedactStatement(statement string) string {
+	return statementLiteralRegex.ReplaceAllString(statement, statementRedactedLiteral)
+}
+
+func countMetricPoints(metrics []*Metric) (count int) {
+	for _, metric := range metrics {
+		count += len(metric.FieldList)
+	}
+	return
+}
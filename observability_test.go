@@ -0,0 +1,59 @@
+package tsdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpanStatements(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(provider)
+
+	cases := []struct {
+		name   string
+		redact bool
+		want   []string
+	}{
+		{
+			name: "every statement",
+			want: []string{"DESCRIBE `m1`", "ALTER STABLE `m1` MODIFY COLUMN `s1` NCHAR(64)", "DESCRIBE `m1`"},
+		},
+		{
+			name:   "redacted statements",
+			redact: true,
+			want:   []string{"DESCRIBE `m1`", "ALTER STABLE `m1` MODIFY COLUMN `s1` NCHAR(?)", "DESCRIBE `m1`"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, _ := newTdengineTestServer(t, describeOutput)
+			s.redactStatement = c.redact
+			if _, err := s.ModifyColumnLength(context.Background(), "m1", "s1", 64); err != nil {
+				t.Fatal(err)
+			}
+			spans := recorder.Ended()
+			span := spans[len(spans)-1]
+			got := make([]string, 0)
+			for _, event := range span.Events() {
+				if event.Name != traceEventStatement {
+					continue
+				}
+				for _, attr := range event.Attributes {
+					if string(attr.Key) == traceAttrKeyStatement {
+						got = append(got, attr.Value.AsString())
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got statements %q of span %s, want %q", got, span.Name(), c.want)
+			}
+		})
+	}
+}
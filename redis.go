@@ -2,33 +2,49 @@ package tsdb
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/gogf/gf/v2/container/gmap"
 	"github.com/gogf/gf/v2/database/gredis"
 	"github.com/gogf/gf/v2/frame/g"
-	"github.com/gogf/gf/v2/os/gcron"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 )
 
 /*
-	!!! redis here is not for multiple projects !!!
-	!!! when apis don't pass deviceIds, redis will return untrusted results !!!
+	!!! redis serves multiple projects only with key version 2, see redis_key.go !!!
 
-	create 1 hash set for the latest data of device points, with TTL
-	create 1 stream for time series data of devices
-	we need to remove the outdated data manually using cron
+	create 1 hash set for the latest data of device points, with TTL,
+	it is only updated by data newer than it, so out of order data do not overwrite the latest data
+	create 1 stream or 1 sorted set for time series data of each device point,
+	or 1 stream for time series data of all points of a device, see Config.RedisSeriesLayout
+	create 1 sorted set per model and 1 per model and project as device index, scored by the last seen time
+	create 1 hash per model for the project of each device
+	outdated entries are trimmed when writing, and series expire after dataKeep without writes,
+	a low frequency cron sweeps streams that have no TTL, e.g. written by older versions
 
-	zset is currently commented, since for the same member of zset, although with different scores,
-	zadd will update the score instead of insert a new record, that is not what we need
+	stream layout: the entry id is <timestamp>-*, redis rejects ids lower than the last one,
+	so out of order data of a point are rejected, and data of the same timestamp are all kept
+	zset layout: the score is the timestamp, and the member is <timestamp>:<value> to keep it unique,
+	data of any timestamp are accepted, and the last write wins for the same timestamp
+	device layout: an entry holds all fields of a metric, so reading many points is one range call per device,
+	out of order data of a device are rejected as the stream layout does
 */
 
 type redis struct {
+	keys           redisKeyBuilder
+	shards         *redisShards
+	scheduler      *maintenanceScheduler
+	seriesLayout   string
+	strictWrite    bool
+	rollupLevels   []redisRollupLevel
 	dataKeep       time.Duration
-	realTimeWindow int64 // seconds
+	realTimeWindow time.Duration
+	latestKeep     time.Duration // 0 if latest data are kept forever
 	sync.Mutex
 }
 
@@ -45,70 +61,210 @@ func (s *redis) Init(ctx context.Context, config Config) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
-	redisClient := g.Redis()
-	if redisClient == nil {
-		return fmt.Errorf("redis is not initialized because of no configs")
-	}
-	isHealthy := s.IsHealthy(ctx)
-	if !isHealthy {
-		return fmt.Errorf("we cannot connect to the redis server now")
+	s.shards = newRedisShards(config.RedisGroup, config.RedisShardGroups)
+	for _, group := range s.shards.Groups() {
+		redisClient := g.Redis(group)
+		if redisClient == nil {
+			return fmt.Errorf("redis group [ %s ] is not initialized because of no configs", group)
+		}
+		if res, innErr := redisClient.Do(ctx, "PING"); innErr != nil || res.IsEmpty() {
+			return fmt.Errorf("we cannot connect to the redis server of group [ %s ] now", group)
+		}
+		if err = checkRedisVersion(ctx, redisClient); err != nil {
+			return fmt.Errorf("redis group [ %s ]: %w", group, err)
+		}
 	}
 
+	s.keys, err = newRedisKeyBuilder(config)
+	if err != nil {
+		return err
+	}
+	switch config.RedisSeriesLayout {
+	case "", redisSeriesLayoutStream:
+		s.seriesLayout = redisSeriesLayoutStream
+	case redisSeriesLayoutZSet, redisSeriesLayoutDevice:
+		s.seriesLayout = config.RedisSeriesLayout
+	default:
+		return fmt.Errorf("unsupported redis series layout: %s", config.RedisSeriesLayout)
+	}
+	s.strictWrite = config.RedisStrictWrite
 	_, s.dataKeep = mustGetDataKeepFromConfig(config, ClientTypeRedis)
-	realTimeWindowString, realTimeWindowDuration := mustGetRealTimeWindowFromConfig(config)
-	s.realTimeWindow = gconv.Int64(realTimeWindowDuration.Seconds())
+	_, s.realTimeWindow = mustGetRealTimeWindowFromConfig(config)
+	if s.latestKeep, err = getLatestKeepFromConfig(config, s.dataKeep, s.realTimeWindow); err != nil {
+		return err
+	}
 
-	cronPattern := fmt.Sprintf("@every %s", realTimeWindowString)
-	_, err = gcron.AddSingleton(ctx, cronPattern, func(ctx context.Context) {
-		s.streamAutoExpire(ctx)
-	}, redisAutoExpireCronName)
-	return
+	// maintenance jobs are run by only one instance sharing this redis
+	if s.scheduler != nil {
+		// initialized again, the jobs of the previous config are replaced
+		s.scheduler.Stop(ctx)
+	}
+	s.scheduler = newMaintenanceScheduler(config.NodeId, &redisMaintenanceBackend{keys: s.keys, group: config.RedisGroup})
+	if err = s.scheduler.Start(ctx); err != nil {
+		return err
+	}
+	sweepInterval := mustGetRedisSweepIntervalFromConfig(config, s.dataKeep)
+	if sweepInterval > 0 {
+		if err = s.scheduler.AddJob(ctx, redisAutoExpireCronName, sweepInterval, s.streamAutoExpire); err != nil {
+			return err
+		}
+	}
+	s.rollupLevels = newRollupLevels(config, s.dataKeep)
+	if len(s.rollupLevels) > 0 {
+		return s.scheduler.AddJob(ctx, redisRollupCronName, s.rollupLevels[0].Interval, s.rollup)
+	}
+	return nil
 }
 
 func (s *redis) IsHealthy(ctx context.Context) bool {
-	res, err := g.Redis().Do(ctx, "PING")
+	if s.shards == nil {
+		return false
+	}
+	res, err := s.shards.Primary().Do(ctx, "PING")
 	if err != nil {
 		return false
 	}
 	return !res.IsEmpty()
 }
 
-func (s *redis) Write(ctx context.Context, metrics []*Metric) error {
+func (s *redis) Write(ctx context.Context, metrics []*Metric) (err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedis, operationWrite, "", countMetricPoints(metrics))
+	defer func() { observer.End(ctx, err) }()
+
+	/*
+		all commands are sent in batches of lua scripts instead of one round trip per command,
+		a metric is never split into two batches, so that the data of a device are written atomically,
+		keys shared by devices are written in another batch of the primary group.
+		caution: the batches of different groups, and of different slots in cluster mode, are separate scripts,
+		so they are not atomic with each other, e.g. device indexes miss the data written if the primary group fails,
+		a failed group is returned in a RedisGroupError, and the batches of other groups are still applied
+	*/
+	var writtenPoints, writtenBytes int
+	minId := gtime.Now().Add(-1 * s.dataKeep).UnixMilli()
+	dataKeepSeconds := gconv.Int64(s.dataKeep.Seconds())
+	// latest data and device indexes are kept for last known values
+	latestKeepSeconds := gconv.Int64(s.latestKeep.Seconds())
+	indexKeepSeconds := gconv.Int64(max(s.dataKeep, s.latestKeep).Seconds())
+	minIndexTime := gtime.Now().Add(-1 * max(s.dataKeep, s.latestKeep)).UnixMilli()
+	trimmedModels := make(map[string]bool)
+	commandErrors := make([]RedisCommandError, 0)
+	batches := make(redisGroupBatches)
+	flush := func() error {
+		failed, points, innErr := s.execBatches(ctx, batches)
+		writtenPoints += points
+		commandErrors = append(commandErrors, failed...)
+		batches = make(redisGroupBatches)
+		return innErr
+	}
 	for _, metric := range metrics {
 		// tags and fields of a valid metric should not be empty
 		if metric.TagList == nil || len(metric.TagList) == 0 || metric.FieldList == nil || len(metric.FieldList) == 0 {
 			continue
 		}
 		// get deviceId from tag
-		var deviceId string
-		for _, tag := range metric.TagList {
-			if tag.Key == tdengineColumnDevice {
-				deviceId = tag.Value
-			}
-		}
+		deviceId, _ := metric.GetTag(tdengineColumnDevice)
 		if deviceId == "" {
 			// deviceId is a must
 			continue
 		}
-		latestDataKey := fmt.Sprintf("%s:%s_%s", metric.Name, deviceId, redisKeyLatest)
+		projectId, _ := metric.GetTag(tdengineColumnProject)
+		latestDataKey := s.keys.Latest(metric.Name, projectId, deviceId)
 		// millisecond
 		timestamp := metric.Time.UnixMilli()
+		batch := batches.For(s.shards.DeviceGroup(metric.Name, deviceId))
+		sharedBatch := batches.For(s.shards.primary)
 
-		devicePointDataMap := gmap.NewStrAnyMap()
-		devicePointDataMap.Set(redisKeyTimestamp, timestamp)
+		latestArgs := []any{redisKeyTimestamp, timestamp}
+		deviceSeriesArgs := []any{"MINID", "~", minId, fmt.Sprintf("%d-*", timestamp)}
 		for _, field := range metric.FieldList {
-			devicePointDataMap.Set(field.Key, field.Value)
-			seriesDataKey := fmt.Sprintf("%s:%s", deviceId, field.Key)
-			err := s.xAdd(ctx, seriesDataKey, timestamp, field.Value)
-			if err != nil {
-				g.Log().Errorf(ctx, "%s: %v", "xadd error", err)
+			encodedValue := EncodeRedisValue(field.Value)
+			latestArgs = append(latestArgs, field.Key, encodedValue)
+			if s.seriesLayout == redisSeriesLayoutDevice {
+				deviceSeriesArgs = append(deviceSeriesArgs, field.Key, encodedValue)
+				writtenBytes += len(field.Key) + len(encodedValue)
+				continue
+			}
+			seriesDataKey := s.keys.Series(metric.Name, projectId, deviceId, field.Key)
+			writtenBytes += len(seriesDataKey) + len(encodedValue)
+			switch s.seriesLayout {
+			case redisSeriesLayoutZSet:
+				// last write wins: the entry of the same timestamp is replaced
+				batch.Add("ZREMRANGEBYSCORE", seriesDataKey, timestamp, timestamp)
+				batch.AddWrite(1, "ZADD", seriesDataKey, timestamp, fmt.Sprintf("%d:%s", timestamp, encodedValue))
+				batch.Add("ZREMRANGEBYSCORE", seriesDataKey, "-inf", fmt.Sprintf("(%d", minId))
+			default:
+				// MINID ~: outdated entries are trimmed efficiently
+				batch.AddWrite(1, "XADD", seriesDataKey, "MINID", "~", minId, fmt.Sprintf("%d-*", timestamp), "value", encodedValue)
 			}
+			batch.Add("EXPIRE", seriesDataKey, dataKeepSeconds)
+		}
+		if s.useRegistry() {
+			// record models and points to roll up or sweep
+			pointCodes := make([]any, 0, len(metric.FieldList))
+			for _, field := range metric.FieldList {
+				pointCodes = append(pointCodes, field.Key)
+			}
+			sharedBatch.Add("SADD", s.keys.Models(), metric.Name)
+			sharedBatch.Add("SADD", s.keys.Points(metric.Name), pointCodes...)
+		}
+		if s.seriesLayout == redisSeriesLayoutDevice {
+			deviceSeriesKey := s.keys.DeviceSeries(metric.Name, projectId, deviceId)
+			batch.AddWrite(len(metric.FieldList), "XADD", deviceSeriesKey, deviceSeriesArgs...)
+			writtenBytes += len(deviceSeriesKey)
+			batch.Add("EXPIRE", deviceSeriesKey, dataKeepSeconds)
+		}
+		// update latest data, unless it is newer than this metric
+		batch.Add(redisCommandHSetGT, latestDataKey, latestArgs...)
+		if s.latestKeep > 0 {
+			batch.Add("EXPIRE", latestDataKey, latestKeepSeconds)
+		} else {
+			batch.Add("PERSIST", latestDataKey)
+		}
+		// update device index, GT: out of order data will not move the last seen time backwards
+		indexKeys := []string{s.keys.DeviceIndex(metric.Name, "")}
+		indexMembers := []string{deviceId}
+		if projectId != "" {
+			// the device is found in its project by the device index of the project
+			indexKeys = append(indexKeys, s.keys.DeviceIndex(metric.Name, projectId), s.keys.Projects(metric.Name))
+			indexMembers = append(indexMembers, deviceId, projectId)
+		}
+		for i, indexKey := range indexKeys {
+			sharedBatch.Add("ZADD", indexKey, "GT", timestamp, indexMembers[i])
+			if s.latestKeep > 0 {
+				sharedBatch.Add("EXPIRE", indexKey, indexKeepSeconds)
+			} else {
+				sharedBatch.Add("PERSIST", indexKey)
+			}
+		}
+		if projectId != "" && s.latestKeep > 0 && !trimmedModels[metric.Name] {
+			// projects not seen for a long time are removed, once a call
+			sharedBatch.Add("ZREMRANGEBYSCORE", s.keys.Projects(metric.Name), "-inf", fmt.Sprintf("(%d", minIndexTime))
+			trimmedModels[metric.Name] = true
 		}
-		// update latest data
-		_, _ = g.Redis().HSet(ctx, latestDataKey, devicePointDataMap.Map())
-		_, _ = g.Redis().Expire(ctx, latestDataKey, s.realTimeWindow)
-	}
 
+		if batches.Len() >= redisBatchMaxCommands {
+			if err = flush(); err != nil {
+				// points of other groups are written
+				observer.RecordWrite(ctx, writtenPoints, writtenBytes)
+				return err
+			}
+		}
+	}
+	err = flush()
+	observer.RecordWrite(ctx, writtenPoints, writtenBytes)
+	if err != nil {
+		return err
+	}
+	if len(commandErrors) == 0 {
+		return nil
+	}
+	if s.strictWrite {
+		return &RedisBatchError{Errors: commandErrors}
+	}
+	// other commands are applied, so failed ones are logged as before
+	for _, commandErr := range commandErrors {
+		g.Log().Errorf(ctx, "%s error of redis key [ %s ]: %s", strings.ToLower(commandErr.Command), commandErr.Key, commandErr.Message)
+	}
 	return nil
 }
 
@@ -117,22 +273,39 @@ func (s *redis) ReadToMap(
 	in ReadDeviceLatestDataInput,
 	dataFilterMap map[string]float64,
 ) (pointCodeValueMaps []map[string]any, pointCodes [][]string, err error) {
-	/*
-		caution: projectId will not be used here
-	*/
+	ctx, observer := startOperation(ctx, ClientTypeRedis, operationReadToMap, in.DeviceModelName, len(in.PointCodes))
+	defer func() { observer.End(ctx, err) }()
+
 	pointCodeValueMaps = make([]map[string]any, 0)
 	pointCodes = make([][]string, 0)
 	targetDeviceIds := in.DeviceIds
 	if len(in.DeviceIds) == 0 {
-		keys, innErr := useRedisScan(ctx, gredis.ScanOption{Match: fmt.Sprintf("%s:*", in.DeviceModelName)})
+		// devices seen in the real time window, or all devices having last known values
+		var since int64
+		if !in.LastKnownValue {
+			since = gtime.Now().Add(-1 * s.realTimeWindow).UnixMilli()
+		} else if s.latestKeep > 0 {
+			since = gtime.Now().Add(-1 * s.latestKeep).UnixMilli()
+		}
+		deviceIds, innErr := s.zRangeSeenSince(
+			ctx,
+			s.keys.DeviceIndex(in.DeviceModelName, in.ProjectId),
+			since,
+		)
 		if innErr != nil {
 			return nil, nil, innErr
 		}
-		targetDeviceIds = keys
+		targetDeviceIds = deviceIds
+	}
+	// a device id in several projects has a result for each project
+	devices, err := s.findDevices(ctx, in.DeviceModelName, in.ProjectId, targetDeviceIds, s.deviceIndexes(in.DeviceModelName))
+	if err != nil {
+		return nil, nil, err
 	}
-	for _, deviceId := range targetDeviceIds {
-		latestDataKey := fmt.Sprintf("%s:%s_%s", in.DeviceModelName, deviceId, redisKeyLatest)
-		rawZSet, loopErr := g.Redis().HGetAll(ctx, latestDataKey)
+	for _, device := range devices {
+		deviceId := device.DeviceId
+		latestDataKey := s.keys.Latest(in.DeviceModelName, device.ProjectId, deviceId)
+		rawZSet, loopErr := s.shards.Device(in.DeviceModelName, deviceId).HGetAll(ctx, latestDataKey)
 		if loopErr != nil {
 			return nil, nil, loopErr
 		}
@@ -140,11 +313,24 @@ func (s *redis) ReadToMap(
 			continue
 		}
 		zSetMap := rawZSet.Map()
+		/*
+			latest data are kept longer than the real time window for last known values,
+			so stale data are omitted unless last known values are asked for
+		*/
+		timestamp := gconv.Int64(zSetMap[redisKeyTimestamp])
+		stale := isStale(timestamp, s.realTimeWindow)
+		if stale && !in.LastKnownValue {
+			continue
+		}
 		newMap := make(map[string]any)
 		pointCodesInOneTimestamp := make([]string, 0)
 		isPassedFilter := true // whether equals the value given by the filter data map
 		for _, pointCode := range in.PointCodes {
-			valueNow := zSetMap[pointCode]
+			rawValue, ok := zSetMap[pointCode]
+			var valueNow any
+			if ok && rawValue != nil {
+				valueNow = DecodeRedisValue(gconv.String(rawValue))
+			}
 			// dataFilterMap must not be nil and key must be contained
 			// then compare value
 			// if one point value is not equaled to the given value in filter map, this device will be omitted
@@ -157,12 +343,22 @@ func (s *redis) ReadToMap(
 				}
 			}
 			if valueNow != nil {
-				newMap[pointCode] = gconv.Int64(valueNow)
+				newMap[pointCode] = valueNow
 				pointCodesInOneTimestamp = append(pointCodesInOneTimestamp, pointCode)
 			}
 		}
 		if isPassedFilter && !(len(newMap) == 0) {
 			newMap[tdengineColumnAliasDevice] = deviceId
+			if in.HaveProjectIdInResult {
+				newMap[tdengineColumnAliasProject] = device.ProjectId
+			}
+			if in.HaveDeviceModelNameInResult {
+				newMap[tdengineTableNameKey] = in.DeviceModelName
+			}
+			if in.LastKnownValue {
+				newMap[tdengineColumnTimestamp] = timestamp
+				newMap[resultKeyIsStale] = stale
+			}
 			pointCodeValueMaps = append(pointCodeValueMaps, newMap)
 			pointCodes = append(pointCodes, pointCodesInOneTimestamp)
 		}
@@ -175,8 +371,78 @@ func (s *redis) ReadToSeries(
 	ctx context.Context,
 	in ReadDeviceSeriesDataInput,
 ) (seriesData [][]any, timestamps []int64, err error) {
-	allDeviceData, totalPointsCount := s.batchQueryDeviceData(ctx, in.DeviceIds, in.PointCodes, in.StartTime, in.EndTime)
-	return ApplyTimeWindowAndFill(allDeviceData, totalPointsCount, in.DeviceModelName, in.StartTime, in.EndTime, in.Interval, in.FillOption)
+	// windows start at StartTime, timestamps are the window ends, and empty windows are kept by default,
+	// as before ReadSeries
+	if in.FillOption == "" {
+		in.FillOption = fillNull
+	}
+	results, err := s.readSeries(ctx, in, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	seriesData, _ = SeriesResultsToLegacy(results)
+	timestamps = make([]int64, 0)
+	if len(results) > 0 {
+		timestamps = results[0].WindowEnds
+	}
+	return
+}
+
+func (s *redis) ReadSeries(ctx context.Context, in ReadDeviceSeriesDataInput) ([]*SeriesResult, error) {
+	return s.readSeries(ctx, in, true)
+}
+
+// readSeries reads windows aligned to the multiples of interval, or windows starting at StartTime,
+// from the coarsest rollup fitting the interval if there is one, since raw data only cover dataKeep
+func (s *redis) readSeries(ctx context.Context, in ReadDeviceSeriesDataInput, alignWindows bool) (results []*SeriesResult, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedis, operationReadSeries, in.DeviceModelName, len(in.PointCodes))
+	defer func() { observer.End(ctx, err) }()
+
+	if err = in.validateDeviceIds(); err != nil {
+		return nil, err
+	}
+	aggregations, err := in.aggregations()
+	if err != nil {
+		return nil, err
+	}
+	// a device id in several projects is read in ProjectId, or in its last seen project
+	projectIds, err := s.findProjectIds(ctx, in.DeviceModelName, in.ProjectId, in.DeviceIds, s.deviceIndexes(in.DeviceModelName))
+	if err != nil {
+		return nil, err
+	}
+	malformed := make(redisMalformedCounts)
+	if levelIdx := s.chooseRollupLevel(in.Interval, aggregations); levelIdx >= 0 {
+		results, err = s.readSeriesFromRollups(ctx, in, levelIdx, projectIds, aggregations, alignWindows, malformed)
+	} else {
+		var allDeviceData map[string]map[string][]*RedisDataPoint
+		allDeviceData, err = s.batchQueryDeviceData(
+			ctx, in.DeviceModelName, in.DeviceIds, projectIds, in.PointCodes, in.StartTime, in.EndTime, malformed,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results, err = applyTimeWindowAndFill(
+			allDeviceData,
+			in.DeviceModelName,
+			in.StartTime,
+			in.EndTime,
+			in.Interval,
+			in.FillOption,
+			in.DeviceIds,
+			in.PointCodes,
+			aggregations,
+			alignWindows,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// results are ordered by deviceIds first and then by point codes
+	for i, result := range results {
+		result.ProjectId = projectIds[i/len(in.PointCodes)]
+		result.Malformed = malformed[result.DeviceId][result.PointCode]
+	}
+	return results, nil
 }
 
 func (s *redis) CreateSTable(ctx context.Context, stableName string, columns []TdengineColumn) error {
@@ -185,59 +451,344 @@ func (s *redis) CreateSTable(ctx context.Context, stableName string, columns []T
 
 func (s *redis) batchQueryDeviceData(
 	ctx context.Context,
+	deviceModelName string,
 	deviceIds []string,
+	projectIds []string, // aligned with deviceIds
 	pointCodes []string,
 	start int64,
 	end int64,
-) (allDeviceData map[string]map[string][]*RedisDataPoint, totalPointsCount int) {
-	allDeviceData = make(map[string]map[string][]*RedisDataPoint)
-	for _, deviceId := range deviceIds {
-		deviceData := make(map[string][]*RedisDataPoint)
+	malformed redisMalformedCounts, // counts of skipped entries are added to it
+) (map[string]map[string][]*RedisDataPoint, error) {
+	type readTask struct {
+		deviceId   string
+		pointCodes []string // points of the malformed entries
+		read       func() (map[string][]*RedisDataPoint, int, error)
+	}
+	allDeviceData := make(map[string]map[string][]*RedisDataPoint)
+	tasks := make([]readTask, 0)
+	for i, deviceId := range deviceIds {
+		allDeviceData[deviceId] = make(map[string][]*RedisDataPoint)
+		client := s.shards.Device(deviceModelName, deviceId)
+		if s.seriesLayout == redisSeriesLayoutDevice {
+			deviceSeriesKey := s.keys.DeviceSeries(deviceModelName, projectIds[i], deviceId)
+			// a malformed entry of a device stream is missed by all points
+			tasks = append(tasks, readTask{deviceId: deviceId, pointCodes: pointCodes, read: func() (map[string][]*RedisDataPoint, int, error) {
+				return s.rangeDeviceSeries(ctx, client, deviceSeriesKey, pointCodes, start, end)
+			}})
+			continue
+		}
 		for _, pointCode := range pointCodes {
-			seriesDataKey := fmt.Sprintf("%s:%s", deviceId, pointCode)
-			resultArray, err := s.xRange(ctx, seriesDataKey, start, end)
-			if err != nil || len(resultArray) == 0 {
+			seriesDataKey := s.keys.Series(deviceModelName, projectIds[i], deviceId, pointCode)
+			tasks = append(tasks, readTask{deviceId: deviceId, pointCodes: []string{pointCode}, read: func() (map[string][]*RedisDataPoint, int, error) {
+				dataPoints, malformedEntries, err := s.rangeSeries(ctx, client, seriesDataKey, start, end)
+				return map[string][]*RedisDataPoint{pointCode: dataPoints}, malformedEntries, err
+			}})
+		}
+	}
+
+	// range calls are sent concurrently, at most redisReadConcurrency at the same time
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	semaphore := make(chan struct{}, redisReadConcurrency)
+	for _, task := range tasks {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			deviceData, malformedEntries, err := task.read()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for _, pointCode := range task.pointCodes {
+				malformed.add(task.deviceId, pointCode, malformedEntries)
+			}
+			for pointCode, dataPoints := range deviceData {
+				if len(dataPoints) > 0 {
+					allDeviceData[task.deviceId][pointCode] = dataPoints
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return allDeviceData, firstErr
+}
+
+// rangeSeries returns the data points of a series in ascending order of time
+func (s *redis) rangeSeries(
+	ctx context.Context,
+	client *gredis.Redis,
+	key string,
+	startTime int64,
+	endTime int64,
+) ([]*RedisDataPoint, int, error) {
+	dataPoints := make([]*RedisDataPoint, 0)
+	malformed := 0
+	switch s.seriesLayout {
+	case redisSeriesLayoutZSet:
+		members, err := s.zRangeByScore(ctx, client, key, startTime, endTime)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, member := range members {
+			parsedDataPoint := ParseZSetMember(member)
+			if parsedDataPoint == nil {
+				malformed++
 				continue
 			}
-			for _, res := range resultArray {
-				parsedDataPoint := ParseStreamResult(res)
-				deviceData[pointCode] = append(deviceData[pointCode], parsedDataPoint)
+			dataPoints = append(dataPoints, parsedDataPoint)
+		}
+	default:
+		reply, err := s.xRange(ctx, client, key, startTime, endTime)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries, malformedEntries := parseStreamReply(reply)
+		malformed += malformedEntries
+		for _, entry := range entries {
+			// data format: value, <encoded value>
+			if len(entry.Fields) != 2 {
+				malformed++
+				continue
 			}
-			totalPointsCount++
+			dataPoints = append(dataPoints, &RedisDataPoint{
+				Value:     DecodeRedisValue(entry.Fields[1]),
+				Timestamp: entry.Timestamp,
+			})
 		}
-		allDeviceData[deviceId] = deviceData
 	}
-	return
+	s.reportMalformed(ctx, key, malformed)
+	return dataPoints, malformed, nil
 }
 
-func (s *redis) xAdd(ctx context.Context, key string, timestamp int64, value any) error {
-	_, err := g.Redis().Do(ctx, "XADD", key, fmt.Sprintf("%d-*", timestamp), "value", value)
+// rangeDeviceSeries returns the data points of the given points of a device, in ascending order of time
+func (s *redis) rangeDeviceSeries(
+	ctx context.Context,
+	client *gredis.Redis,
+	key string,
+	pointCodes []string,
+	startTime int64,
+	endTime int64,
+) (map[string][]*RedisDataPoint, int, error) {
+	reply, err := s.xRange(ctx, client, key, startTime, endTime)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, malformed := parseStreamReply(reply)
+	s.reportMalformed(ctx, key, malformed)
+	wanted := make(map[string]bool, len(pointCodes))
+	for _, pointCode := range pointCodes {
+		wanted[pointCode] = true
+	}
+	deviceData := make(map[string][]*RedisDataPoint)
+	for _, entry := range entries {
+		// data format: point, <encoded value>, point, <encoded value>...
+		for i := 0; i+1 < len(entry.Fields); i += 2 {
+			pointCode := entry.Fields[i]
+			if !wanted[pointCode] {
+				continue
+			}
+			deviceData[pointCode] = append(deviceData[pointCode], &RedisDataPoint{
+				Value:     DecodeRedisValue(entry.Fields[i+1]),
+				Timestamp: entry.Timestamp,
+			})
+		}
+	}
+	return deviceData, malformed, nil
+}
+
+// reportMalformed logs entries that cannot be decoded, they are skipped instead of failing the query,
+// readers of series also get their counts in SeriesResult.Malformed
+func (s *redis) reportMalformed(ctx context.Context, key string, malformed int) {
+	if malformed > 0 {
+		g.Log().Warningf(ctx, "%d malformed entries of redis key [ %s ] are skipped", malformed, key)
+		addSpanMalformed(ctx, key, malformed)
+	}
+}
+
+// redisMalformedCounts counts skipped entries that cannot be decoded, deviceId -> pointCode -> count
+type redisMalformedCounts map[string]map[string]int
+
+func (c redisMalformedCounts) add(deviceId string, pointCode string, count int) {
+	if c == nil || count == 0 {
+		return
+	}
+	if c[deviceId] == nil {
+		c[deviceId] = make(map[string]int)
+	}
+	c[deviceId][pointCode] += count
+}
+
+func (s *redis) zRangeByScore(ctx context.Context, client *gredis.Redis, key string, startTime int64, endTime int64) ([]string, error) {
+	res, err := client.Do(ctx, "ZRANGEBYSCORE", key, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return res.Strings(), nil
+}
+
+// xRange returns the raw reply, so that entries are decoded from the RESP structure instead of strings
+func (s *redis) xRange(ctx context.Context, client *gredis.Redis, key string, startTime int64, endTime int64) (any, error) {
+	res, err := client.Do(ctx, "XRANGE", key, gconv.String(startTime), gconv.String(endTime))
+	if err != nil {
+		return nil, err
+	}
+	return res.Val(), nil
+}
+
+func (s *redis) zAddLastSeen(ctx context.Context, key string, timestamp int64, member string) error {
+	// GT: out of order data will not move the last seen time backwards
+	_, err := s.shards.Primary().Do(ctx, "ZADD", key, "GT", timestamp, member)
+	if err != nil {
+		return err
+	}
+	_, err = s.shards.Primary().Expire(ctx, key, gconv.Int64(s.dataKeep.Seconds()))
 	return err
 }
 
-func (s *redis) xRange(ctx context.Context, key string, startTime int64, endTime int64) ([]string, error) {
-	res, err := g.Redis().Do(ctx, "XRANGE", key, gconv.String(startTime), gconv.String(endTime))
+func (s *redis) zRangeSeenSince(ctx context.Context, key string, since int64) ([]string, error) {
+	res, err := s.shards.Primary().Do(ctx, "ZRANGEBYSCORE", key, since, "+inf")
 	if err != nil {
 		return nil, err
 	}
 	return res.Strings(), nil
 }
 
-func (s *redis) xTrim(ctx context.Context, key string, endTime int64) error {
-	_, err := g.Redis().Do(ctx, "XTRIM", fmt.Sprintf("%s", key), "MINID", gconv.String(endTime))
+func (s *redis) xTrim(ctx context.Context, client *gredis.Redis, key string, endTime int64) error {
+	_, err := client.Do(ctx, "XTRIM", key, "MINID", gconv.String(endTime))
 	return err
 }
 
-func (s *redis) streamAutoExpire(ctx context.Context) {
-	keys, err := useRedisScan(ctx, gredis.ScanOption{Type: "stream"})
+// useRegistry returns whether models and points are recorded when writing,
+// rollups need them, and the sweep needs them in place of SCAN in cluster and sharded mode
+func (s *redis) useRegistry() bool {
+	return len(s.rollupLevels) > 0 || s.keys.hashTag || s.shards.IsSharded()
+}
+
+// execBatches runs the batches in their groups, batches are split by hash tag in cluster mode,
+// all groups are run even if some of them failed, the failed groups are returned in a RedisGroupError
+func (s *redis) execBatches(ctx context.Context, batches redisGroupBatches) (commandErrors []RedisCommandError, points int, err error) {
+	groupErrors := make(map[string]error)
+	for group, batch := range batches {
+		slotBatches := []*redisBatch{batch}
+		if s.keys.hashTag {
+			slotBatches = batch.SplitBySlot()
+		}
+		for _, slotBatch := range slotBatches {
+			failed, innErr := execRedisBatch(ctx, g.Redis(group), slotBatch)
+			if innErr != nil {
+				// scripts of other slots of the group are still run
+				groupErrors[group] = errors.Join(groupErrors[group], innErr)
+				continue
+			}
+			points += slotBatch.Points()
+			for _, commandErr := range failed {
+				points -= commandErr.points
+			}
+			commandErrors = append(commandErrors, failed...)
+		}
+	}
+	if len(groupErrors) > 0 {
+		return commandErrors, points, &RedisGroupError{Errors: groupErrors}
+	}
+	return commandErrors, points, nil
+}
+
+func (s *redis) MaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error) {
+	if s.scheduler == nil {
+		return nil, fmt.Errorf("redis client is not initialized")
+	}
+	return s.scheduler.Status(ctx)
+}
+
+func (s *redis) streamAutoExpire(ctx context.Context) error {
+	streamKeys, err := s.findStreams(ctx)
 	if err != nil {
-		return
+		return err
 	}
 	endTime := gtime.Now().Add(-1 * s.dataKeep).UnixMilli()
-	for _, streamKey := range keys {
-		err = s.xTrim(ctx, streamKey, endTime)
+	dataKeepSeconds := gconv.Int64(s.dataKeep.Seconds())
+	for group, keys := range streamKeys {
+		client := g.Redis(group)
+		for _, streamKey := range keys {
+			// only streams written by older versions have no TTL, others are trimmed when written
+			ttlRes, innErr := client.Do(ctx, "TTL", streamKey)
+			if innErr != nil {
+				g.Log().Errorf(ctx, "ttl error: %v", innErr)
+				continue
+			}
+			if ttlRes.Int64() != -1 {
+				continue
+			}
+			err = s.xTrim(ctx, client, streamKey, endTime)
+			if err != nil {
+				g.Log().Errorf(ctx, "xtrim error: %v", err)
+			}
+			_, err = client.Do(ctx, "EXPIRE", streamKey, dataKeepSeconds)
+			if err != nil {
+				g.Log().Errorf(ctx, "expire error: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// findStreams returns group -> stream keys,
+// SCAN only sees one node of a cluster, so the keys are built from the registry in cluster and sharded mode
+func (s *redis) findStreams(ctx context.Context) (map[string][]string, error) {
+	if !s.keys.hashTag && !s.shards.IsSharded() {
+		keys, err := useRedisScan(ctx, s.shards.Primary(), gredis.ScanOption{Match: s.keys.Pattern(), Type: "stream"})
 		if err != nil {
-			g.Log().Errorf(ctx, "xtrim error: %v", err)
+			return nil, err
+		}
+		// streams of events are kept as long as device indexes, they are trimmed when written
+		keys = slices.DeleteFunc(keys, func(key string) bool {
+			return strings.HasSuffix(key, ":"+redisKeyDeviceEvents)
+		})
+		return map[string][]string{s.shards.primary: keys}, nil
+	}
+	streamKeys := make(map[string][]string)
+	if s.seriesLayout == redisSeriesLayoutZSet {
+		// sorted sets always have TTL
+		return streamKeys, nil
+	}
+	modelsRes, err := s.shards.Primary().Do(ctx, "SMEMBERS", s.keys.Models())
+	if err != nil {
+		return nil, err
+	}
+	for _, deviceModelName := range modelsRes.Strings() {
+		pointsRes, innErr := s.shards.Primary().Do(ctx, "SMEMBERS", s.keys.Points(deviceModelName))
+		if innErr != nil {
+			return nil, innErr
+		}
+		deviceIds, innErr := s.zRangeSeenSince(ctx, s.keys.DeviceIndex(deviceModelName, ""), 0)
+		if innErr != nil {
+			return nil, innErr
+		}
+		devices, innErr := s.findDevices(ctx, deviceModelName, "", deviceIds, s.deviceIndexes(deviceModelName))
+		if innErr != nil {
+			return nil, innErr
+		}
+		for _, device := range devices {
+			group := s.shards.DeviceGroup(deviceModelName, device.DeviceId)
+			if s.seriesLayout == redisSeriesLayoutDevice {
+				streamKeys[group] = append(streamKeys[group], s.keys.DeviceSeries(deviceModelName, device.ProjectId, device.DeviceId))
+				continue
+			}
+			for _, pointCode := range pointsRes.Strings() {
+				streamKeys[group] = append(streamKeys[group], s.keys.Series(deviceModelName, device.ProjectId, device.DeviceId, pointCode))
+			}
 		}
 	}
+	return streamKeys, nil
 }
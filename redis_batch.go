@@ -0,0 +1,239 @@
+package tsdb
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gogf/gf/v2/database/gredis"
+)
+
+/*
+	redisBatchScript runs all commands of a batch in one round trip,
+	a lua script is atomic, so the data of a device are never partially visible to readers.
+
+	every command has exactly one key, ARGV format: [keyIndex, argsCount, command, args..., keyIndex, ...]
+	it returns an error message for each command, empty if succeeded
+
+	besides redis commands, it runs HSETGT key field value [field value ...],
+	which sets the hash only if the stored value of the first field is not greater than the given one
+*/
+
+const redisBatchScript = `
+local function hsetGt(key, ...)
+	local args = { ... }
+	local current = redis.call("HGET", key, args[1])
+	if current and tonumber(current) > tonumber(args[2]) then
+		return 0
+	end
+	return redis.call("HSET", key, ...)
+end
+
+local results = {}
+local i = 1
+while i <= #ARGV do
+	local argsCount = tonumber(ARGV[i + 1])
+	local command = { ARGV[i + 2], KEYS[tonumber(ARGV[i])] }
+	for j = 1, argsCount do
+		command[j + 2] = ARGV[i + 2 + j]
+	end
+	local res
+	if command[1] == "HSETGT" then
+		local ok, innRes = pcall(hsetGt, unpack(command, 2))
+		res = innRes
+		if not ok then
+			res = { err = type(innRes) == "table" and innRes.err or tostring(innRes) }
+		end
+	else
+		res = redis.pcall(unpack(command))
+	end
+	if type(res) == "table" and res.err then
+		results[#results + 1] = res.err
+	else
+		results[#results + 1] = ""
+	end
+	i = i + 3 + argsCount
+end
+return results
+`
+
+var redisBatchScriptSha = func() string {
+	sum := sha1.Sum([]byte(redisBatchScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+type RedisCommandError struct {
+	Command string
+	Key     string
+	Message string
+	points  int
+}
+
+// RedisBatchError is returned when some commands of a batch failed, other commands are still applied
+type RedisBatchError struct {
+	Errors []RedisCommandError
+}
+
+func (e *RedisBatchError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, commandErr := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s %s: %s", commandErr.Command, commandErr.Key, commandErr.Message))
+	}
+	return fmt.Sprintf("%d redis commands failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// RedisGroupError is returned when the scripts of some goframe redis groups failed, keyed by group name,
+// the scripts of other groups are still applied
+type RedisGroupError struct {
+	Errors map[string]error
+}
+
+func (e *RedisGroupError) Error() string {
+	groups := make([]string, 0, len(e.Errors))
+	for group := range e.Errors {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	messages := make([]string, 0, len(groups))
+	for _, group := range groups {
+		messages = append(messages, fmt.Sprintf("%q: %v", group, e.Errors[group]))
+	}
+	return fmt.Sprintf("redis scripts of %d groups failed: %s", len(groups), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of all failed groups, so that errors.Is matches any of them
+func (e *RedisGroupError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+type redisBatchCommand struct {
+	command string
+	key     string
+	args    []any
+	points  int // data points written by the command
+}
+
+type redisBatch struct {
+	keys     []string
+	keyIndex map[string]int
+	args     []any
+	commands []redisBatchCommand
+}
+
+func newRedisBatch() *redisBatch {
+	return &redisBatch{
+		keys:     make([]string, 0),
+		keyIndex: make(map[string]int),
+		args:     make([]any, 0),
+		commands: make([]redisBatchCommand, 0),
+	}
+}
+
+func (b *redisBatch) Add(command string, key string, args ...any) {
+	b.AddWrite(0, command, key, args...)
+}
+
+// AddWrite adds a command that writes the given number of data points
+func (b *redisBatch) AddWrite(points int, command string, key string, args ...any) {
+	idx, ok := b.keyIndex[key]
+	if !ok {
+		b.keys = append(b.keys, key)
+		idx = len(b.keys) // lua index starts from 1
+		b.keyIndex[key] = idx
+	}
+	b.args = append(b.args, idx, len(args), command)
+	b.args = append(b.args, args...)
+	b.commands = append(b.commands, redisBatchCommand{command: command, key: key, args: args, points: points})
+}
+
+// SplitBySlot splits the batch into batches of the same hash tag, so that each script of a redis cluster runs in one slot,
+// a key without hash tag is a batch by itself, commands keep their order in each batch
+func (b *redisBatch) SplitBySlot() []*redisBatch {
+	out := make([]*redisBatch, 0)
+	batchIndex := make(map[string]int)
+	for _, command := range b.commands {
+		slotKey := redisHashTag(command.key)
+		if slotKey == "" {
+			slotKey = command.key
+		}
+		idx, ok := batchIndex[slotKey]
+		if !ok {
+			out = append(out, newRedisBatch())
+			idx = len(out) - 1
+			batchIndex[slotKey] = idx
+		}
+		out[idx].AddWrite(command.points, command.command, command.key, command.args...)
+	}
+	return out
+}
+
+func (b *redisBatch) Len() int {
+	return len(b.commands)
+}
+
+func (b *redisBatch) Points() int {
+	points := 0
+	for _, command := range b.commands {
+		points += command.points
+	}
+	return points
+}
+
+// redisGroupBatches are the batches of goframe redis groups, keyed by group name
+type redisGroupBatches map[string]*redisBatch
+
+func (b redisGroupBatches) For(group string) *redisBatch {
+	if _, ok := b[group]; !ok {
+		b[group] = newRedisBatch()
+	}
+	return b[group]
+}
+
+func (b redisGroupBatches) Len() int {
+	count := 0
+	for _, batch := range b {
+		count += batch.Len()
+	}
+	return count
+}
+
+// execRedisBatch runs the batch in one round trip and returns the failed commands
+func execRedisBatch(ctx context.Context, client *gredis.Redis, batch *redisBatch) ([]RedisCommandError, error) {
+	if batch.Len() == 0 {
+		return nil, nil
+	}
+	script := client.GroupScript()
+	res, err := script.EvalSha(ctx, redisBatchScriptSha, int64(len(batch.keys)), batch.keys, batch.args)
+	if err != nil && strings.Contains(err.Error(), "NOSCRIPT") {
+		// script cache is flushed or it is the first time, EVAL also caches the script
+		res, err = script.Eval(ctx, redisBatchScript, int64(len(batch.keys)), batch.keys, batch.args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return batch.commandErrors(res.Strings()), nil
+}
+
+// commandErrors returns the failed commands of the messages returned by redisBatchScript, one for each command
+func (b *redisBatch) commandErrors(messages []string) []RedisCommandError {
+	commandErrors := make([]RedisCommandError, 0)
+	for i, message := range messages {
+		if message == "" || i >= len(b.commands) {
+			continue
+		}
+		commandErrors = append(commandErrors, RedisCommandError{
+			Command: b.commands[i].command,
+			Key:     b.commands[i].key,
+			Message: message,
+			points:  b.commands[i].points,
+		})
+	}
+	return commandErrors
+}
@@ -0,0 +1,138 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedisBatchArgs(t *testing.T) {
+	batch := newRedisBatch()
+	batch.AddWrite(1, "XADD", "s1", "*", "value", "f1")
+	batch.Add("EXPIRE", "s1", 60)
+	batch.Add(redisCommandHSetGT, "l1", redisKeyTimestamp, 1)
+
+	wantKeys := []string{"s1", "l1"}
+	if !reflect.DeepEqual(batch.keys, wantKeys) {
+		t.Fatalf("got keys %v, want %v", batch.keys, wantKeys)
+	}
+	// [keyIndex, argsCount, command, args...] of each command, lua index starts from 1
+	wantArgs := []any{
+		1, 3, "XADD", "*", "value", "f1",
+		1, 1, "EXPIRE", 60,
+		2, 2, redisCommandHSetGT, redisKeyTimestamp, 1,
+	}
+	if !reflect.DeepEqual(batch.args, wantArgs) {
+		t.Fatalf("got args %v, want %v", batch.args, wantArgs)
+	}
+	if batch.Len() != 3 || batch.Points() != 1 {
+		t.Fatalf("got %d commands of %d points, want 3 commands of 1 point", batch.Len(), batch.Points())
+	}
+}
+
+func TestRedisBatchCommandErrors(t *testing.T) {
+	batch := newRedisBatch()
+	batch.AddWrite(1, "XADD", "s1", "1-*", "value", "f1")
+	batch.Add("EXPIRE", "s1", 60)
+	batch.AddWrite(1, "XADD", "s2", "1-*", "value", "f2")
+	batch.Add("EXPIRE", "s2", 60)
+
+	cases := []struct {
+		name     string
+		messages []string
+		want     []RedisCommandError
+	}{
+		{
+			name:     "all succeeded",
+			messages: []string{"", "", "", ""},
+			want:     []RedisCommandError{},
+		},
+		{
+			name: "an out of order write",
+			messages: []string{
+				"",
+				"",
+				"ERR The ID specified in XADD is equal or smaller than the target stream top item",
+				"",
+			},
+			want: []RedisCommandError{{
+				Command: "XADD",
+				Key:     "s2",
+				Message: "ERR The ID specified in XADD is equal or smaller than the target stream top item",
+				points:  1,
+			}},
+		},
+		{
+			name:     "each command has its error",
+			messages: []string{"ERR a", "ERR b", "", "ERR d"},
+			want: []RedisCommandError{
+				{Command: "XADD", Key: "s1", Message: "ERR a", points: 1},
+				{Command: "EXPIRE", Key: "s1", Message: "ERR b"},
+				{Command: "EXPIRE", Key: "s2", Message: "ERR d"},
+			},
+		},
+		{
+			name:     "messages more than commands are ignored",
+			messages: []string{"", "", "", "", "ERR e"},
+			want:     []RedisCommandError{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := batch.commandErrors(c.messages)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRedisBatchSplitBySlot(t *testing.T) {
+	batch := newRedisBatch()
+	batch.AddWrite(1, "XADD", "ts:{m1:d1}:p1", "*", "value", "f1")
+	batch.Add("SADD", "ts:_models", "m1")
+	batch.AddWrite(1, "XADD", "ts:{m1:d2}:p1", "*", "value", "f2")
+	batch.Add("EXPIRE", "ts:{m1:d1}:p1", 60)
+
+	slotBatches := batch.SplitBySlot()
+	if len(slotBatches) != 3 {
+		t.Fatalf("got %d batches, want 3", len(slotBatches))
+	}
+	wantKeys := [][]string{{"ts:{m1:d1}:p1"}, {"ts:_models"}, {"ts:{m1:d2}:p1"}}
+	wantLens := []int{2, 1, 1}
+	for i, slotBatch := range slotBatches {
+		if !reflect.DeepEqual(slotBatch.keys, wantKeys[i]) || slotBatch.Len() != wantLens[i] {
+			t.Fatalf("got batch %d of keys %v and %d commands, want keys %v and %d commands",
+				i, slotBatch.keys, slotBatch.Len(), wantKeys[i], wantLens[i])
+		}
+	}
+	if slotBatches[0].commands[1].command != "EXPIRE" {
+		t.Fatalf("commands lose their order in a batch: %+v", slotBatches[0].commands)
+	}
+}
+
+func TestRedisBatchError(t *testing.T) {
+	err := &RedisBatchError{Errors: []RedisCommandError{
+		{Command: "XADD", Key: "s1", Message: "ERR a"},
+		{Command: "EXPIRE", Key: "s2", Message: "ERR b"},
+	}}
+	if got := err.Error(); !strings.HasPrefix(got, "2 redis commands failed") || !strings.Contains(got, "XADD s1: ERR a") {
+		t.Fatalf("unexpected message: %s", got)
+	}
+}
+
+func TestRedisGroupError(t *testing.T) {
+	err := &RedisGroupError{Errors: map[string]error{
+		"shard2": errors.New("connection refused"),
+		"":       context.DeadlineExceeded,
+	}}
+	want := `redis scripts of 2 groups failed: "": context deadline exceeded; "shard2": connection refused`
+	if got := err.Error(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("errors of groups are not matched by errors.Is")
+	}
+}
@@ -0,0 +1,126 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	events of a device are in a stream keyed by their time, as long as the device index,
+	devices having events are in an index scored by their last event time, so that reading a range skips quiet devices
+*/
+
+func (s *redis) WriteEvents(ctx context.Context, events []*Event) (err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedis, operationWriteEvents, "", len(events))
+	defer func() { observer.End(ctx, err) }()
+
+	keep := max(s.dataKeep, s.latestKeep)
+	keepSeconds := gconv.Int64(keep.Seconds())
+	minId := gtime.Now().Add(-1 * keep).UnixMilli()
+	batches := make(redisGroupBatches)
+	for _, event := range events {
+		if err = validateEvent(event); err != nil {
+			return err
+		}
+		eventsKey := s.keys.DeviceEvents(event.DeviceModelName, event.ProjectId, event.DeviceId)
+		batch := batches.For(s.shards.DeviceGroup(event.DeviceModelName, event.DeviceId))
+		sharedBatch := batches.For(s.shards.primary)
+
+		args := make([]any, 0, 16)
+		if s.latestKeep > 0 {
+			args = append(args, "MINID", "~", minId)
+		}
+		args = append(args,
+			fmt.Sprintf("%d-*", event.Time),
+			eventFieldSeverity, event.Severity,
+			eventFieldCode, event.Code,
+			eventFieldMessage, event.Message,
+			eventFieldState, event.State,
+			eventFieldAcked, gconv.String(event.Acked),
+			eventFieldProject, event.ProjectId,
+		)
+		batch.Add("XADD", eventsKey, args...)
+		indexKeys := []string{s.keys.EventIndex(event.DeviceModelName, "")}
+		indexMembers := []string{event.DeviceId}
+		if event.ProjectId != "" {
+			// the device is found in its project by the event index of the project
+			indexKeys = append(indexKeys, s.keys.EventIndex(event.DeviceModelName, event.ProjectId), s.keys.Projects(event.DeviceModelName))
+			indexMembers = append(indexMembers, event.DeviceId, event.ProjectId)
+		}
+		if s.latestKeep > 0 {
+			batch.Add("EXPIRE", eventsKey, keepSeconds)
+		} else {
+			batch.Add("PERSIST", eventsKey)
+		}
+		for i, indexKey := range indexKeys {
+			sharedBatch.Add("ZADD", indexKey, "GT", event.Time, indexMembers[i])
+			if s.latestKeep > 0 {
+				sharedBatch.Add("EXPIRE", indexKey, keepSeconds)
+			} else {
+				sharedBatch.Add("PERSIST", indexKey)
+			}
+		}
+	}
+	commandErrors, _, err := s.execBatches(ctx, batches)
+	if err != nil {
+		return err
+	}
+	if len(commandErrors) > 0 {
+		return &RedisBatchError{Errors: commandErrors}
+	}
+	return nil
+}
+
+func (s *redis) ReadEvents(ctx context.Context, in ReadEventsInput) (events []*Event, total int, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedis, operationReadEvents, in.DeviceModelName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	targetDeviceIds := in.DeviceIds
+	if len(targetDeviceIds) == 0 {
+		// devices whose last event is before the range have no events in it
+		targetDeviceIds, err = s.zRangeSeenSince(ctx, s.keys.EventIndex(in.DeviceModelName, in.ProjectId), in.StartTime)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	devices, err := s.findDevices(ctx, in.DeviceModelName, in.ProjectId, targetDeviceIds, s.eventIndexes(in.DeviceModelName))
+	if err != nil {
+		return nil, 0, err
+	}
+	// filters are applied in memory, since a stream has no index other than time
+	events = make([]*Event, 0)
+	for _, device := range devices {
+		deviceId := device.DeviceId
+		eventsKey := s.keys.DeviceEvents(in.DeviceModelName, device.ProjectId, deviceId)
+		reply, innErr := s.xRange(ctx, s.shards.Device(in.DeviceModelName, deviceId), eventsKey, in.StartTime, in.EndTime)
+		if innErr != nil {
+			return nil, 0, innErr
+		}
+		entries, malformed := parseStreamReply(reply)
+		s.reportMalformed(ctx, eventsKey, malformed)
+		for _, entry := range entries {
+			fields := make(map[string]string, len(entry.Fields)/2)
+			for j := 0; j+1 < len(entry.Fields); j += 2 {
+				fields[entry.Fields[j]] = entry.Fields[j+1]
+			}
+			event := &Event{
+				DeviceModelName: in.DeviceModelName,
+				DeviceId:        deviceId,
+				ProjectId:       fields[eventFieldProject],
+				Time:            entry.Timestamp.UnixMilli(),
+				Severity:        fields[eventFieldSeverity],
+				Code:            fields[eventFieldCode],
+				Message:         fields[eventFieldMessage],
+				State:           fields[eventFieldState],
+				Acked:           gconv.Bool(fields[eventFieldAcked]),
+			}
+			if in.match(event) {
+				events = append(events, event)
+			}
+		}
+	}
+	return pageEvents(events, in.Offset, in.Limit), len(events), nil
+}
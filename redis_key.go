@@ -0,0 +1,234 @@
+package tsdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	key schema of version 1, redis is shared by all projects:
+		latest data:     <model>:<device>_latest
+		series data:     <device>:<point>
+		device series:   <model>:<device>:_series
+		device index:    <model>:_devices, <model>:_devices:<project>
+		projects:        <model>:_projects
+		device status:   <model>:<device>:_status, <model>:_status:last
+		events:          <model>:<device>:_events, <model>:_events:devices, <model>:_events:devices:<project>
+		rollups:         <device>:<point>:_rollup:<level>
+		rollup registry: _models, <model>:_points, _rollup:watermarks
+		maintenance:     _maintenance:leader, _maintenance:jobs
+		timeseries:      ts:<project>:<model>:<device>:<point>
+
+	key schema of version 2, prefix is optional,
+	in cluster mode, <project>:<model>:<device> of device keys is wrapped in a hash tag, e.g. {<project>:<model>:<device>}:<point>:
+		latest data:     <prefix>:v2:<project>:<model>:<device>:_latest
+		series data:     <prefix>:v2:<project>:<model>:<device>:<point>
+		device series:   <prefix>:v2:<project>:<model>:<device>:_series
+		device index:    <prefix>:v2:<model>:_devices, <prefix>:v2:<project>:<model>:_devices
+		projects:        <prefix>:v2:<model>:_projects
+		device status:   <prefix>:v2:<project>:<model>:<device>:_status,
+		                 <prefix>:v2:<model>:_status:last, <prefix>:v2:<project>:<model>:_status:last
+		events:          <prefix>:v2:<project>:<model>:<device>:_events,
+		                 <prefix>:v2:<model>:_events:devices, <prefix>:v2:<project>:<model>:_events:devices
+		rollups:         <prefix>:v2:<project>:<model>:<device>:<point>:_rollup:<level>
+		rollup registry: <prefix>:v2:_models, <prefix>:v2:<model>:_points, <prefix>:v2:_rollup:watermarks
+		maintenance:     <prefix>:v2:_maintenance:leader, <prefix>:v2:_maintenance:jobs
+		timeseries:      <prefix>:v2:<project>:<model>:<device>:ts:<point>
+*/
+
+type redisKeyBuilder struct {
+	prefix  string
+	version int
+	hashTag bool
+}
+
+func newRedisKeyBuilder(config Config) (redisKeyBuilder, error) {
+	version := config.RedisKeyVersion
+	if version == 0 {
+		version = redisKeyVersion1
+	}
+	switch version {
+	case redisKeyVersion1:
+		if config.RedisKeyPrefix != "" {
+			return redisKeyBuilder{}, fmt.Errorf("redis key prefix requires key version %d", redisKeyVersion2)
+		}
+		if config.RedisClusterMode {
+			return redisKeyBuilder{}, fmt.Errorf("redis cluster mode requires key version %d", redisKeyVersion2)
+		}
+	case redisKeyVersion2:
+	default:
+		return redisKeyBuilder{}, fmt.Errorf("unsupported redis key version: %d", version)
+	}
+	return redisKeyBuilder{prefix: config.RedisKeyPrefix, version: version, hashTag: config.RedisClusterMode}, nil
+}
+
+func (k redisKeyBuilder) Latest(deviceModelName string, projectId string, deviceId string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s_%s", deviceModelName, deviceId, redisKeyLatest)
+	}
+	// "_" avoids conflicts with a point code named latest
+	return k.device(projectId, deviceModelName, deviceId, "_"+redisKeyLatest)
+}
+
+func (k redisKeyBuilder) Series(deviceModelName string, projectId string, deviceId string, pointCode string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s", deviceId, pointCode)
+	}
+	return k.device(projectId, deviceModelName, deviceId, pointCode)
+}
+
+// DeviceSeries returns the stream of all points of a device, used by the device series layout
+func (k redisKeyBuilder) DeviceSeries(deviceModelName string, projectId string, deviceId string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s:%s", deviceModelName, deviceId, redisKeyDeviceSeries)
+	}
+	return k.device(projectId, deviceModelName, deviceId, redisKeyDeviceSeries)
+}
+
+// DeviceIndex returns the device index of a model, or of a model in a project if projectId is not empty
+func (k redisKeyBuilder) DeviceIndex(deviceModelName string, projectId string) string {
+	if k.version == redisKeyVersion1 {
+		if projectId == "" {
+			return fmt.Sprintf("%s:%s", deviceModelName, redisKeyDeviceIndex)
+		}
+		return fmt.Sprintf("%s:%s:%s", deviceModelName, redisKeyDeviceIndex, projectId)
+	}
+	if projectId == "" {
+		return k.join(deviceModelName, redisKeyDeviceIndex)
+	}
+	return k.join(projectId, deviceModelName, redisKeyDeviceIndex)
+}
+
+// Projects returns the sorted set of projects of a model scored by their last seen time,
+// devices of a project are found in the device index of the project
+func (k redisKeyBuilder) Projects(deviceModelName string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s", deviceModelName, redisKeyProjects)
+	}
+	return k.join(deviceModelName, redisKeyProjects)
+}
+
+// DeviceStatus returns the sorted set of status events of a device
+func (k redisKeyBuilder) DeviceStatus(deviceModelName string, projectId string, deviceId string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s:%s", deviceModelName, deviceId, redisKeyDeviceStatus)
+	}
+	return k.device(projectId, deviceModelName, deviceId, redisKeyDeviceStatus)
+}
+
+// DeviceStatusLast returns the hash of deviceId -> the last status event of a model in a project,
+// keys of version 1 do not contain the project, as status events of a device are shared by its projects
+func (k redisKeyBuilder) DeviceStatusLast(deviceModelName string, projectId string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s", deviceModelName, redisKeyDeviceStatusLast)
+	}
+	if projectId == "" {
+		return k.join(deviceModelName, redisKeyDeviceStatusLast)
+	}
+	return k.join(projectId, deviceModelName, redisKeyDeviceStatusLast)
+}
+
+// DeviceEvents returns the stream of events of a device
+func (k redisKeyBuilder) DeviceEvents(deviceModelName string, projectId string, deviceId string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s:%s", deviceModelName, deviceId, redisKeyDeviceEvents)
+	}
+	return k.device(projectId, deviceModelName, deviceId, redisKeyDeviceEvents)
+}
+
+// EventIndex returns the sorted set of devices having events scored by their last event time,
+// of a model, or of a model in a project if projectId is not empty
+func (k redisKeyBuilder) EventIndex(deviceModelName string, projectId string) string {
+	if k.version == redisKeyVersion1 {
+		if projectId == "" {
+			return fmt.Sprintf("%s:%s", deviceModelName, redisKeyEventIndex)
+		}
+		return fmt.Sprintf("%s:%s:%s", deviceModelName, redisKeyEventIndex, projectId)
+	}
+	if projectId == "" {
+		return k.join(deviceModelName, redisKeyEventIndex)
+	}
+	return k.join(projectId, deviceModelName, redisKeyEventIndex)
+}
+
+// Rollup returns the sorted set of a rollup level of a device point
+func (k redisKeyBuilder) Rollup(deviceModelName string, projectId string, deviceId string, pointCode string, level string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s:%s:%s", deviceId, pointCode, redisKeyRollup, level)
+	}
+	return k.device(projectId, deviceModelName, deviceId, pointCode, redisKeyRollup, level)
+}
+
+// Models returns the set of models to roll up
+func (k redisKeyBuilder) Models() string {
+	if k.version == redisKeyVersion1 {
+		return redisKeyModels
+	}
+	return k.join(redisKeyModels)
+}
+
+// Points returns the set of point codes of a model to roll up
+func (k redisKeyBuilder) Points(deviceModelName string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s", deviceModelName, redisKeyPoints)
+	}
+	return k.join(deviceModelName, redisKeyPoints)
+}
+
+// RollupWatermarks returns the hash of <model>:<level> -> end of the last rolled up window
+func (k redisKeyBuilder) RollupWatermarks() string {
+	if k.version == redisKeyVersion1 {
+		return redisKeyRollupWatermarks
+	}
+	return k.join(redisKeyRollupWatermarks)
+}
+
+// MaintenanceLeader returns the string of the node id holding the maintenance lease
+func (k redisKeyBuilder) MaintenanceLeader() string {
+	if k.version == redisKeyVersion1 {
+		return redisKeyMaintenanceLeader
+	}
+	return k.join(redisKeyMaintenanceLeader)
+}
+
+// MaintenanceJobs returns the hash of job name -> job status
+func (k redisKeyBuilder) MaintenanceJobs() string {
+	if k.version == redisKeyVersion1 {
+		return redisKeyMaintenanceJobs
+	}
+	return k.join(redisKeyMaintenanceJobs)
+}
+
+// Pattern matches all keys of this schema, it is empty for version 1 since its keys have no common prefix
+func (k redisKeyBuilder) Pattern() string {
+	if k.version == redisKeyVersion1 {
+		return ""
+	}
+	return redisGlob(k.join(redisGlobWildcard))
+}
+
+// TimeSeries returns the RedisTimeSeries key of a device point
+func (k redisKeyBuilder) TimeSeries(deviceModelName string, projectId string, deviceId string, pointCode string) string {
+	if k.version == redisKeyVersion1 {
+		return fmt.Sprintf("%s:%s:%s:%s:%s", redisTimeSeriesKey, projectId, deviceModelName, deviceId, pointCode)
+	}
+	return k.device(projectId, deviceModelName, deviceId, redisTimeSeriesKey, pointCode)
+}
+
+// device joins a key of a device, all keys of a device have the same hash tag in cluster mode
+func (k redisKeyBuilder) device(projectId string, deviceModelName string, deviceId string, parts ...string) string {
+	if k.hashTag {
+		return k.join(append([]string{fmt.Sprintf("{%s:%s:%s}", projectId, deviceModelName, deviceId)}, parts...)...)
+	}
+	return k.join(append([]string{projectId, deviceModelName, deviceId}, parts...)...)
+}
+
+func (k redisKeyBuilder) join(parts ...string) string {
+	segments := make([]string, 0, len(parts)+2)
+	if k.prefix != "" {
+		segments = append(segments, k.prefix)
+	}
+	segments = append(segments, fmt.Sprintf("v%d", k.version))
+	segments = append(segments, parts...)
+	return strings.Join(segments, ":")
+}
@@ -0,0 +1,99 @@
+package tsdb
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// acquires the lease if no one holds it, or renews it if this node holds it
+const redisTryLeadScript = `
+local holder = redis.call("GET", KEYS[1])
+if not holder then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if holder == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`
+
+// releases the lease if this node holds it
+const redisReleaseLeaseScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// redisMaintenanceBackend keeps the maintenance lease and job statuses in redis
+type redisMaintenanceBackend struct {
+	keys  redisKeyBuilder
+	group string // goframe redis group
+}
+
+func (s *redisMaintenanceBackend) TryLead(ctx context.Context, nodeId string, ttl time.Duration) (bool, error) {
+	res, err := g.Redis(s.group).GroupScript().Eval(
+		ctx,
+		redisTryLeadScript,
+		1,
+		[]string{s.keys.MaintenanceLeader()},
+		[]any{nodeId, ttl.Milliseconds()},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.Int() == 1, nil
+}
+
+func (s *redisMaintenanceBackend) Release(ctx context.Context, nodeId string) error {
+	_, err := g.Redis(s.group).GroupScript().Eval(
+		ctx,
+		redisReleaseLeaseScript,
+		1,
+		[]string{s.keys.MaintenanceLeader()},
+		[]any{nodeId},
+	)
+	return err
+}
+
+func (s *redisMaintenanceBackend) Leader(ctx context.Context) (string, error) {
+	res, err := g.Redis(s.group).Do(ctx, "GET", s.keys.MaintenanceLeader())
+	if err != nil {
+		return "", err
+	}
+	return res.String(), nil
+}
+
+func (s *redisMaintenanceBackend) SaveJobStatus(ctx context.Context, status *JobStatus) error {
+	encoded, err := gjson.Encode(status)
+	if err != nil {
+		return err
+	}
+	_, err = g.Redis(s.group).HSet(ctx, s.keys.MaintenanceJobs(), map[string]any{status.Name: string(encoded)})
+	return err
+}
+
+func (s *redisMaintenanceBackend) JobStatuses(ctx context.Context) ([]*JobStatus, error) {
+	res, err := g.Redis(s.group).HGetAll(ctx, s.keys.MaintenanceJobs())
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*JobStatus, 0)
+	for _, encoded := range res.MapStrStr() {
+		status := &JobStatus{}
+		if innErr := gjson.DecodeTo(encoded, status); innErr != nil {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
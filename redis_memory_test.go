@@ -0,0 +1,435 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gogf/gf/v2/container/gvar"
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	memoryRedis is an in-memory redis of the commands used by the readers, it is registered as a goframe redis group,
+	so that clients are tested through g.Redis as in production
+*/
+
+var (
+	memoryRedisMu     sync.Mutex
+	memoryRedisGroups = make(map[string]*memoryRedis)
+	memoryRedisOnce   sync.Once
+)
+
+type memoryStreamEntry struct {
+	id     string
+	fields []any
+}
+
+type memoryRedis struct {
+	gredis.Adapter // commands of the groups that are not implemented panic
+	mu             sync.Mutex
+	strings        map[string]string
+	hashes         map[string]map[string]string
+	zsets          map[string]map[string]float64
+	sets           map[string]map[string]bool
+	streams        map[string][]memoryStreamEntry
+	ttls           map[string]int64
+}
+
+// newMemoryRedis registers a memory redis as the goframe redis group named after the test
+func newMemoryRedis(t *testing.T) (*memoryRedis, string) {
+	t.Helper()
+	memoryRedisOnce.Do(func() {
+		gredis.RegisterAdapterFunc(func(config *gredis.Config) gredis.Adapter {
+			memoryRedisMu.Lock()
+			defer memoryRedisMu.Unlock()
+			return memoryRedisGroups[config.Address]
+		})
+	})
+	group := "memory:" + t.Name()
+	m := &memoryRedis{
+		strings: make(map[string]string),
+		hashes:  make(map[string]map[string]string),
+		zsets:   make(map[string]map[string]float64),
+		sets:    make(map[string]map[string]bool),
+		streams: make(map[string][]memoryStreamEntry),
+		ttls:    make(map[string]int64),
+	}
+	memoryRedisMu.Lock()
+	memoryRedisGroups[group] = m
+	memoryRedisMu.Unlock()
+	gredis.SetConfig(&gredis.Config{Address: group}, group)
+	return m, group
+}
+
+func (m *memoryRedis) GroupGeneric() gredis.IGroupGeneric     { return memoryRedisGeneric{m: m} }
+func (m *memoryRedis) GroupHash() gredis.IGroupHash           { return memoryRedisHash{m: m} }
+func (m *memoryRedis) GroupList() gredis.IGroupList           { return nil }
+func (m *memoryRedis) GroupPubSub() gredis.IGroupPubSub       { return nil }
+func (m *memoryRedis) GroupScript() gredis.IGroupScript       { return nil }
+func (m *memoryRedis) GroupSet() gredis.IGroupSet             { return nil }
+func (m *memoryRedis) GroupSortedSet() gredis.IGroupSortedSet { return nil }
+func (m *memoryRedis) GroupString() gredis.IGroupString       { return nil }
+
+func (m *memoryRedis) Close(ctx context.Context) error { return nil }
+
+type memoryRedisGeneric struct {
+	gredis.IGroupGeneric
+	m *memoryRedis
+}
+
+func (g memoryRedisGeneric) Scan(ctx context.Context, cursor uint64, option ...gredis.ScanOption) (uint64, []string, error) {
+	args := []any{cursor}
+	if len(option) > 0 {
+		if option[0].Match != "" {
+			args = append(args, "MATCH", option[0].Match)
+		}
+		if option[0].Type != "" {
+			args = append(args, "TYPE", option[0].Type)
+		}
+	}
+	res, err := g.m.Do(ctx, "SCAN", args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	reply := res.Array()
+	return 0, gconv.Strings(reply[1]), nil
+}
+
+func (g memoryRedisGeneric) Expire(ctx context.Context, key string, seconds int64, option ...gredis.ExpireOption) (int64, error) {
+	res, err := g.m.Do(ctx, "EXPIRE", key, seconds)
+	return res.Int64(), err
+}
+
+type memoryRedisHash struct {
+	gredis.IGroupHash
+	m *memoryRedis
+}
+
+func (h memoryRedisHash) HSet(ctx context.Context, key string, fields map[string]any) (int64, error) {
+	args := []any{key}
+	for field, value := range fields {
+		args = append(args, field, value)
+	}
+	res, err := h.m.Do(ctx, "HSET", args...)
+	return res.Int64(), err
+}
+
+func (h memoryRedisHash) HGet(ctx context.Context, key string, field string) (*gvar.Var, error) {
+	return h.m.Do(ctx, "HGET", key, field)
+}
+
+func (h memoryRedisHash) HGetAll(ctx context.Context, key string) (*gvar.Var, error) {
+	return h.m.Do(ctx, "HGETALL", key)
+}
+
+// keyType returns the type of a key as TYPE does
+func (m *memoryRedis) keyType(key string) string {
+	switch {
+	case m.strings[key] != "":
+		return "string"
+	case m.hashes[key] != nil:
+		return "hash"
+	case m.zsets[key] != nil:
+		return "zset"
+	case m.sets[key] != nil:
+		return "set"
+	case m.streams[key] != nil:
+		return "stream"
+	}
+	return "none"
+}
+
+func (m *memoryRedis) keys() []string {
+	keys := make([]string, 0)
+	for key := range m.strings {
+		keys = append(keys, key)
+	}
+	for key := range m.hashes {
+		keys = append(keys, key)
+	}
+	for key := range m.zsets {
+		keys = append(keys, key)
+	}
+	for key := range m.sets {
+		keys = append(keys, key)
+	}
+	for key := range m.streams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *memoryRedis) del(key string) bool {
+	if m.keyType(key) == "none" {
+		return false
+	}
+	delete(m.strings, key)
+	delete(m.hashes, key)
+	delete(m.zsets, key)
+	delete(m.sets, key)
+	delete(m.streams, key)
+	delete(m.ttls, key)
+	return true
+}
+
+func memoryRedisScore(arg any) float64 {
+	switch s := gconv.String(arg); s {
+	case "+inf":
+		return math.Inf(1)
+	case "-inf":
+		return math.Inf(-1)
+	default:
+		return gconv.Float64(s)
+	}
+}
+
+// memoryStreamId parses a stream id or a range bound of XRANGE into milliseconds and sequence
+func memoryStreamId(id string, seqDefault int64) (int64, int64) {
+	ms, seq, found := strings.Cut(id, "-")
+	if !found {
+		return gconv.Int64(ms), seqDefault
+	}
+	return gconv.Int64(ms), gconv.Int64(seq)
+}
+
+func (m *memoryRedis) Do(ctx context.Context, command string, args ...any) (*gvar.Var, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := ""
+	if len(args) > 0 {
+		key = gconv.String(args[0])
+	}
+	switch strings.ToUpper(command) {
+	case "PING":
+		return gvar.New("PONG"), nil
+	case "TYPE":
+		return gvar.New(m.keyType(key)), nil
+	case "EXISTS":
+		if m.keyType(key) == "none" {
+			return gvar.New(0), nil
+		}
+		return gvar.New(1), nil
+	case "DEL":
+		deleted := 0
+		for _, arg := range args {
+			if m.del(gconv.String(arg)) {
+				deleted++
+			}
+		}
+		return gvar.New(deleted), nil
+	case "EXPIRE":
+		if m.keyType(key) == "none" {
+			return gvar.New(0), nil
+		}
+		m.ttls[key] = gconv.Int64(args[1])
+		return gvar.New(1), nil
+	case "TTL":
+		if m.keyType(key) == "none" {
+			return gvar.New(-2), nil
+		}
+		if ttl, ok := m.ttls[key]; ok {
+			return gvar.New(ttl), nil
+		}
+		return gvar.New(-1), nil
+	case "RENAME", "RENAMENX":
+		newKey := gconv.String(args[1])
+		if m.keyType(key) == "none" {
+			return nil, fmt.Errorf("ERR no such key")
+		}
+		if strings.ToUpper(command) == "RENAMENX" && m.keyType(newKey) != "none" {
+			return gvar.New(0), nil
+		}
+		m.del(newKey)
+		if v, ok := m.strings[key]; ok {
+			m.strings[newKey] = v
+		}
+		if v, ok := m.hashes[key]; ok {
+			m.hashes[newKey] = v
+		}
+		if v, ok := m.zsets[key]; ok {
+			m.zsets[newKey] = v
+		}
+		if v, ok := m.sets[key]; ok {
+			m.sets[newKey] = v
+		}
+		if v, ok := m.streams[key]; ok {
+			m.streams[newKey] = v
+		}
+		if ttl, ok := m.ttls[key]; ok {
+			m.ttls[newKey] = ttl
+		}
+		m.del(key)
+		if strings.ToUpper(command) == "RENAMENX" {
+			return gvar.New(1), nil
+		}
+		return gvar.New("OK"), nil
+	case "SCAN":
+		// the whole keyspace is returned in one call: SCAN 0 MATCH pattern COUNT n TYPE type
+		pattern, keyType := "*", ""
+		for i := 1; i+1 < len(args); i += 2 {
+			switch strings.ToUpper(gconv.String(args[i])) {
+			case "MATCH":
+				pattern = gconv.String(args[i+1])
+			case "TYPE":
+				keyType = gconv.String(args[i+1])
+			}
+		}
+		matched := make([]any, 0)
+		for _, k := range m.keys() {
+			if ok, _ := path.Match(pattern, k); ok && (keyType == "" || m.keyType(k) == keyType) {
+				matched = append(matched, k)
+			}
+		}
+		return gvar.New([]any{"0", matched}), nil
+	case "SET":
+		m.strings[key] = gconv.String(args[1])
+		return gvar.New("OK"), nil
+	case "GET":
+		if v, ok := m.strings[key]; ok {
+			return gvar.New(v), nil
+		}
+		return gvar.New(nil), nil
+	case "HSET":
+		if m.hashes[key] == nil {
+			m.hashes[key] = make(map[string]string)
+		}
+		for i := 1; i+1 < len(args); i += 2 {
+			m.hashes[key][gconv.String(args[i])] = gconv.String(args[i+1])
+		}
+		return gvar.New((len(args) - 1) / 2), nil
+	case "HSETNX":
+		if _, ok := m.hashes[key][gconv.String(args[1])]; ok {
+			return gvar.New(0), nil
+		}
+		if m.hashes[key] == nil {
+			m.hashes[key] = make(map[string]string)
+		}
+		m.hashes[key][gconv.String(args[1])] = gconv.String(args[2])
+		return gvar.New(1), nil
+	case "HGET":
+		if value, ok := m.hashes[key][gconv.String(args[1])]; ok {
+			return gvar.New(value), nil
+		}
+		return gvar.New(nil), nil
+	case "HGETALL":
+		out := make(map[string]any)
+		for field, value := range m.hashes[key] {
+			out[field] = value
+		}
+		return gvar.New(out), nil
+	case "SADD":
+		if m.sets[key] == nil {
+			m.sets[key] = make(map[string]bool)
+		}
+		for _, arg := range args[1:] {
+			m.sets[key][gconv.String(arg)] = true
+		}
+		return gvar.New(len(args) - 1), nil
+	case "SISMEMBER":
+		if m.sets[key][gconv.String(args[1])] {
+			return gvar.New(1), nil
+		}
+		return gvar.New(0), nil
+	case "SMEMBERS":
+		members := make([]string, 0)
+		for member := range m.sets[key] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return gvar.New(members), nil
+	case "ZADD":
+		if m.zsets[key] == nil {
+			m.zsets[key] = make(map[string]float64)
+		}
+		i := 1
+		gt := false
+		for ; i < len(args); i++ {
+			flag := strings.ToUpper(gconv.String(args[i]))
+			if flag != "GT" && flag != "NX" && flag != "XX" && flag != "LT" {
+				break
+			}
+			gt = gt || flag == "GT"
+		}
+		for ; i+1 < len(args); i += 2 {
+			score, member := memoryRedisScore(args[i]), gconv.String(args[i+1])
+			if current, ok := m.zsets[key][member]; ok && gt && current >= score {
+				continue
+			}
+			m.zsets[key][member] = score
+		}
+		return gvar.New(1), nil
+	case "ZRANGE":
+		members := m.zRangeByScore(key, math.Inf(-1), math.Inf(1))
+		if len(args) < 4 || strings.ToUpper(gconv.String(args[3])) != "WITHSCORES" {
+			return gvar.New(members), nil
+		}
+		withScores := make([]string, 0, len(members)*2)
+		for _, member := range members {
+			withScores = append(withScores, member, strconv.FormatFloat(m.zsets[key][member], 'f', -1, 64))
+		}
+		return gvar.New(withScores), nil
+	case "ZRANGEBYSCORE":
+		return gvar.New(m.zRangeByScore(key, memoryRedisScore(args[1]), memoryRedisScore(args[2]))), nil
+	case "ZMSCORE":
+		scores := make([]any, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			if score, ok := m.zsets[key][gconv.String(arg)]; ok {
+				scores = append(scores, strconv.FormatFloat(score, 'f', -1, 64))
+			} else {
+				scores = append(scores, nil)
+			}
+		}
+		return gvar.New(scores), nil
+	case "XADD":
+		id := gconv.String(args[1])
+		if strings.HasSuffix(id, "-*") || id == "*" {
+			id = strings.TrimSuffix(strings.TrimSuffix(id, "*"), "-") + "-" + strconv.Itoa(len(m.streams[key]))
+		}
+		m.streams[key] = append(m.streams[key], memoryStreamEntry{id: id, fields: slices.Clone(args[2:])})
+		return gvar.New(id), nil
+	case "XRANGE":
+		startMs, startSeq := memoryStreamId(gconv.String(args[1]), 0)
+		endMs, endSeq := memoryStreamId(gconv.String(args[2]), math.MaxInt64)
+		entries := make([]any, 0)
+		for _, entry := range m.streams[key] {
+			ms, seq := memoryStreamId(entry.id, 0)
+			if ms < startMs || (ms == startMs && seq < startSeq) || ms > endMs || (ms == endMs && seq > endSeq) {
+				continue
+			}
+			fields := make([]any, 0, len(entry.fields))
+			for _, field := range entry.fields {
+				fields = append(fields, gconv.String(field))
+			}
+			entries = append(entries, []any{entry.id, fields})
+		}
+		return gvar.New(entries), nil
+	}
+	return nil, fmt.Errorf("ERR unknown command '%s' of the memory redis", command)
+}
+
+func (m *memoryRedis) zRangeByScore(key string, min float64, max float64) []string {
+	members := make([]string, 0)
+	for member, score := range m.zsets[key] {
+		if score >= min && score <= max {
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := m.zsets[key][members[i]], m.zsets[key][members[j]]
+		if a != b {
+			return a < b
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
@@ -0,0 +1,356 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// RedisKeyMigrator is implemented by the redis client, use it by type assertion on GetClient()
+type RedisKeyMigrator interface {
+	// MigrateKeys moves the data of the given models from key version 1 to the configured key version 2,
+	// devices without a known project are moved to defaultProjectId
+	MigrateKeys(ctx context.Context, deviceModelNames []string, defaultProjectId string) (movedKeys int, err error)
+}
+
+func (s *redis) MigrateKeys(ctx context.Context, deviceModelNames []string, defaultProjectId string) (movedKeys int, err error) {
+	/*
+		caution: series and rollup keys of version 1 do not contain the model,
+		so if several models have the same device id, its series will be moved to the first given model.
+		keys are moved with RENAMENX, keys that already exist in version 2 are skipped and left in version 1,
+		it is safe to run it again after new data are written in version 1.
+		keys of devices are moved, see legacyDeviceKeys, and so are the shared keys of each model:
+		the last status events, the event index and the rollup registry,
+		indexes of version 1 are copied and left as they are, maintenance keys are not moved since they are rebuilt
+	*/
+	if s.keys.version != redisKeyVersion2 {
+		return 0, fmt.Errorf("redis key version must be %d to migrate keys", redisKeyVersion2)
+	}
+	if s.keys.hashTag || s.shards.IsSharded() {
+		// RENAMENX cannot move keys across slots or groups
+		return 0, fmt.Errorf("redis keys cannot be migrated in cluster or sharded mode")
+	}
+	legacyKeys := redisKeyBuilder{version: redisKeyVersion1}
+	for _, deviceModelName := range deviceModelNames {
+		lastSeenMap, innErr := s.findLegacyDevices(ctx, legacyKeys, deviceModelName)
+		if innErr != nil {
+			return movedKeys, innErr
+		}
+		// devices may only have events
+		lastEventMap, innErr := s.zRangeWithScores(ctx, legacyKeys.EventIndex(deviceModelName, ""))
+		if innErr != nil {
+			return movedKeys, innErr
+		}
+		deviceIds := make([]string, 0, len(lastSeenMap)+len(lastEventMap))
+		for deviceId := range lastSeenMap {
+			deviceIds = append(deviceIds, deviceId)
+		}
+		for deviceId := range lastEventMap {
+			if _, ok := lastSeenMap[deviceId]; !ok {
+				deviceIds = append(deviceIds, deviceId)
+			}
+		}
+		slices.Sort(deviceIds)
+		projectIds, innErr := s.findLegacyProjects(ctx, legacyKeys, deviceModelName, deviceIds, defaultProjectId)
+		if innErr != nil {
+			return movedKeys, innErr
+		}
+
+		for i, deviceId := range deviceIds {
+			projectId := projectIds[i]
+			moves, loopErr := s.legacyDeviceKeys(ctx, legacyKeys, deviceModelName, projectId, deviceId)
+			if loopErr != nil {
+				return movedKeys, loopErr
+			}
+			for _, move := range moves {
+				moved, renameErr := s.renameIfExists(ctx, move.from, move.to)
+				if renameErr != nil {
+					return movedKeys, renameErr
+				}
+				movedKeys += moved
+			}
+			// device index, event index and projects
+			indexKeys, indexMembers, indexScores := make([]string, 0), make([]string, 0), make([]int64, 0)
+			if lastSeen, ok := lastSeenMap[deviceId]; ok {
+				indexKeys = append(indexKeys, s.keys.DeviceIndex(deviceModelName, ""))
+				indexMembers = append(indexMembers, deviceId)
+				indexScores = append(indexScores, lastSeen)
+				if projectId != "" {
+					indexKeys = append(indexKeys, s.keys.DeviceIndex(deviceModelName, projectId), s.keys.Projects(deviceModelName))
+					indexMembers = append(indexMembers, deviceId, projectId)
+					indexScores = append(indexScores, lastSeen, lastSeen)
+				}
+			}
+			if lastEvent, ok := lastEventMap[deviceId]; ok {
+				indexKeys = append(indexKeys, s.keys.EventIndex(deviceModelName, ""))
+				indexMembers = append(indexMembers, deviceId)
+				indexScores = append(indexScores, lastEvent)
+				if projectId != "" {
+					indexKeys = append(indexKeys, s.keys.EventIndex(deviceModelName, projectId), s.keys.Projects(deviceModelName))
+					indexMembers = append(indexMembers, deviceId, projectId)
+					indexScores = append(indexScores, lastEvent, lastEvent)
+				}
+			}
+			for j, indexKey := range indexKeys {
+				if loopErr = s.zAddLastSeen(ctx, indexKey, indexScores[j], indexMembers[j]); loopErr != nil {
+					return movedKeys, loopErr
+				}
+			}
+		}
+		moved, innErr := s.migrateLegacyStatusLast(ctx, legacyKeys, deviceModelName, deviceIds, projectIds)
+		if innErr != nil {
+			return movedKeys, innErr
+		}
+		movedKeys += moved
+		if innErr = s.migrateLegacyRollupRegistry(ctx, legacyKeys, deviceModelName); innErr != nil {
+			return movedKeys, innErr
+		}
+		g.Log().Infof(ctx, "redis keys of model [ %s ] have been migrated, %d devices", deviceModelName, len(deviceIds))
+	}
+	return movedKeys, nil
+}
+
+type redisKeyMove struct {
+	from string
+	to   string
+}
+
+// legacyDeviceKeys returns the keys of version 1 of a device and their keys of version 2, keys of all kinds are listed:
+// latest data, device series, status events and events by their names,
+// series of both layouts and rollups of all levels of each point by SCAN, their types tell series from rollups
+func (s *redis) legacyDeviceKeys(
+	ctx context.Context,
+	legacyKeys redisKeyBuilder,
+	deviceModelName string,
+	projectId string,
+	deviceId string,
+) ([]redisKeyMove, error) {
+	moves := []redisKeyMove{
+		{from: legacyKeys.Latest(deviceModelName, "", deviceId), to: s.keys.Latest(deviceModelName, projectId, deviceId)},
+		{from: legacyKeys.DeviceSeries(deviceModelName, "", deviceId), to: s.keys.DeviceSeries(deviceModelName, projectId, deviceId)},
+		{from: legacyKeys.DeviceStatus(deviceModelName, "", deviceId), to: s.keys.DeviceStatus(deviceModelName, projectId, deviceId)},
+		{from: legacyKeys.DeviceEvents(deviceModelName, "", deviceId), to: s.keys.DeviceEvents(deviceModelName, projectId, deviceId)},
+	}
+	type pointKeyKind struct {
+		prefix  string
+		suffix  string
+		keyType string
+		to      func(pointCode string) string
+	}
+	seriesPrefix := legacyKeys.Series(deviceModelName, "", deviceId, "")
+	kinds := []pointKeyKind{
+		// series of the stream layout
+		{prefix: seriesPrefix, keyType: "stream", to: func(pointCode string) string {
+			return s.keys.Series(deviceModelName, projectId, deviceId, pointCode)
+		}},
+		// series of the zset layout
+		{prefix: seriesPrefix, keyType: "zset", to: func(pointCode string) string {
+			return s.keys.Series(deviceModelName, projectId, deviceId, pointCode)
+		}},
+	}
+	for _, level := range []string{redisRollupMinute, redisRollupHour} {
+		kinds = append(kinds, pointKeyKind{
+			prefix:  seriesPrefix,
+			suffix:  strings.TrimPrefix(legacyKeys.Rollup(deviceModelName, "", deviceId, "", level), seriesPrefix),
+			keyType: "zset",
+			to: func(pointCode string) string {
+				return s.keys.Rollup(deviceModelName, projectId, deviceId, pointCode, level)
+			},
+		})
+	}
+	rollupInfix := fmt.Sprintf(":%s:", redisKeyRollup)
+	for _, kind := range kinds {
+		keys, err := useRedisScan(ctx, s.shards.Primary(), gredis.ScanOption{
+			Match: redisGlob(kind.prefix + redisGlobWildcard + kind.suffix),
+			Type:  kind.keyType,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			pointCode := strings.TrimSuffix(strings.TrimPrefix(key, kind.prefix), kind.suffix)
+			// rollups are zsets of series keys with a suffix
+			if kind.suffix == "" && strings.Contains(pointCode, rollupInfix) {
+				continue
+			}
+			moves = append(moves, redisKeyMove{from: key, to: kind.to(pointCode)})
+		}
+	}
+	return moves, nil
+}
+
+// findLegacyProjects returns the project of each device of deviceIds, data of version 1 are shared by all projects of a device,
+// they are moved to its last seen project, or the project of its last event, or defaultProjectId
+func (s *redis) findLegacyProjects(
+	ctx context.Context,
+	legacyKeys redisKeyBuilder,
+	deviceModelName string,
+	deviceIds []string,
+	defaultProjectId string,
+) ([]string, error) {
+	projectIds := make([]string, len(deviceIds))
+	for _, indexKey := range []redisIndexFunc{
+		func(projectId string) string { return legacyKeys.DeviceIndex(deviceModelName, projectId) },
+		func(projectId string) string { return legacyKeys.EventIndex(deviceModelName, projectId) },
+	} {
+		deviceProjects, err := s.findProjects(ctx, legacyKeys.Projects(deviceModelName), indexKey, deviceIds)
+		if err != nil {
+			return nil, err
+		}
+		for i, projects := range deviceProjects {
+			if projectIds[i] == "" {
+				projectIds[i] = projects[0].ProjectId
+			}
+		}
+	}
+	for i := range projectIds {
+		if projectIds[i] == "" {
+			projectIds[i] = defaultProjectId
+		}
+	}
+	return projectIds, nil
+}
+
+// migrateLegacyStatusLast moves the last status events of a model to the hashes of their projects,
+// events already tracked in version 2 are kept, it returns 1 if the hash of version 1 is moved
+func (s *redis) migrateLegacyStatusLast(
+	ctx context.Context,
+	legacyKeys redisKeyBuilder,
+	deviceModelName string,
+	deviceIds []string,
+	projectIds []string, // aligned with deviceIds
+) (int, error) {
+	legacyKey := legacyKeys.DeviceStatusLast(deviceModelName, "")
+	res, err := s.shards.Primary().HGetAll(ctx, legacyKey)
+	if err != nil {
+		return 0, err
+	}
+	lastEvents := res.MapStrStr()
+	if len(lastEvents) == 0 {
+		return 0, nil
+	}
+	deviceProjects := make(map[string]string, len(deviceIds))
+	for i, deviceId := range deviceIds {
+		deviceProjects[deviceId] = projectIds[i]
+	}
+	for deviceId, member := range lastEvents {
+		projectId, ok := deviceProjects[deviceId]
+		if !ok {
+			// a device not seen anymore, the project of its last event is kept in the member
+			if event := parseStatusMember(member); event != nil {
+				projectId = event.ProjectId
+			}
+		}
+		if _, err = s.shards.Primary().Do(ctx, "HSETNX", s.keys.DeviceStatusLast(deviceModelName, projectId), deviceId, member); err != nil {
+			return 0, err
+		}
+	}
+	if _, err = s.shards.Primary().Do(ctx, "DEL", legacyKey); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// migrateLegacyRollupRegistry copies the model, its points and its watermarks to the rollup registry of version 2,
+// watermarks already in version 2 are kept
+func (s *redis) migrateLegacyRollupRegistry(ctx context.Context, legacyKeys redisKeyBuilder, deviceModelName string) error {
+	isMemberRes, err := s.shards.Primary().Do(ctx, "SISMEMBER", legacyKeys.Models(), deviceModelName)
+	if err != nil || isMemberRes.Int() == 0 {
+		return err
+	}
+	if _, err = s.shards.Primary().Do(ctx, "SADD", s.keys.Models(), deviceModelName); err != nil {
+		return err
+	}
+	pointsRes, err := s.shards.Primary().Do(ctx, "SMEMBERS", legacyKeys.Points(deviceModelName))
+	if err != nil {
+		return err
+	}
+	if pointCodes := pointsRes.Strings(); len(pointCodes) > 0 {
+		args := []any{s.keys.Points(deviceModelName)}
+		for _, pointCode := range pointCodes {
+			args = append(args, pointCode)
+		}
+		if _, err = s.shards.Primary().Do(ctx, "SADD", args...); err != nil {
+			return err
+		}
+	}
+	for _, level := range []string{redisRollupMinute, redisRollupHour} {
+		field := fmt.Sprintf("%s:%s", deviceModelName, level)
+		watermarkRes, innErr := s.shards.Primary().Do(ctx, "HGET", legacyKeys.RollupWatermarks(), field)
+		if innErr != nil {
+			return innErr
+		}
+		if watermarkRes.IsEmpty() {
+			continue
+		}
+		if _, innErr = s.shards.Primary().Do(ctx, "HSETNX", s.keys.RollupWatermarks(), field, watermarkRes.String()); innErr != nil {
+			return innErr
+		}
+	}
+	return nil
+}
+
+// zRangeWithScores returns member -> score of a sorted set
+func (s *redis) zRangeWithScores(ctx context.Context, key string) (map[string]int64, error) {
+	res, err := s.shards.Primary().Do(ctx, "ZRANGE", key, 0, -1, "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int64)
+	items := res.Strings()
+	for i := 0; i+1 < len(items); i += 2 {
+		out[items[i]] = gconv.Int64(items[i+1])
+	}
+	return out, nil
+}
+
+// findLegacyDevices returns deviceId -> last seen time from the device index and the latest data of version 1
+func (s *redis) findLegacyDevices(ctx context.Context, legacyKeys redisKeyBuilder, deviceModelName string) (map[string]int64, error) {
+	lastSeenMap, err := s.zRangeWithScores(ctx, legacyKeys.DeviceIndex(deviceModelName, ""))
+	if err != nil {
+		return nil, err
+	}
+	// devices written before the device index existed only have the latest data
+	latestPrefix := fmt.Sprintf("%s:", deviceModelName)
+	latestSuffix := fmt.Sprintf("_%s", redisKeyLatest)
+	latestKeys, err := useRedisScan(ctx, s.shards.Primary(), gredis.ScanOption{
+		Match: redisGlob(legacyKeys.Latest(deviceModelName, "", redisGlobWildcard)),
+		Type:  "hash",
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, latestKey := range latestKeys {
+		deviceId := strings.TrimSuffix(strings.TrimPrefix(latestKey, latestPrefix), latestSuffix)
+		if _, ok := lastSeenMap[deviceId]; ok || deviceId == "" {
+			continue
+		}
+		timestampRes, innErr := s.shards.Primary().HGet(ctx, latestKey, redisKeyTimestamp)
+		if innErr != nil {
+			return nil, innErr
+		}
+		lastSeenMap[deviceId] = timestampRes.Int64()
+	}
+	return lastSeenMap, nil
+}
+
+// renameIfExists returns 1 if the key is moved
+func (s *redis) renameIfExists(ctx context.Context, from string, to string) (int, error) {
+	existsRes, err := s.shards.Primary().Do(ctx, "EXISTS", from)
+	if err != nil || existsRes.Int() == 0 {
+		return 0, err
+	}
+	res, err := s.shards.Primary().Do(ctx, "RENAMENX", from, to)
+	if err != nil {
+		return 0, err
+	}
+	if res.Int() == 0 {
+		g.Log().Warningf(ctx, "redis key [ %s ] is not migrated because [ %s ] exists", from, to)
+	}
+	return res.Int(), nil
+}
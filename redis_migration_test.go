@@ -0,0 +1,113 @@
+package tsdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMigrateKeys(t *testing.T) {
+	memory, group := newMemoryRedis(t)
+	s := &redis{
+		keys:     redisKeyBuilder{version: redisKeyVersion2},
+		shards:   newRedisShards(group, nil),
+		dataKeep: time.Hour,
+	}
+	legacy := redisKeyBuilder{version: redisKeyVersion1}
+	v2 := s.keys
+	ctx := context.Background()
+	do := func(command string, args ...any) {
+		t.Helper()
+		if _, err := memory.Do(ctx, command, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// d1 has data in p1, d2 only has events in p2
+	do("ZADD", legacy.DeviceIndex("m1", ""), 100, "d1")
+	do("ZADD", legacy.DeviceIndex("m1", "p1"), 100, "d1")
+	do("ZADD", legacy.Projects("m1"), 100, "p1", 200, "p2")
+	do("ZADD", legacy.EventIndex("m1", ""), 150, "d1", 200, "d2")
+	do("ZADD", legacy.EventIndex("m1", "p2"), 200, "d2")
+
+	cases := []struct {
+		kind    string
+		legacy  string
+		want    string
+		keyType string
+		write   []any
+	}{
+		{kind: "latest data", legacy: legacy.Latest("m1", "", "d1"), want: v2.Latest("m1", "p1", "d1"), keyType: "hash",
+			write: []any{"HSET", redisKeyTimestamp, 100}},
+		{kind: "series of the stream layout", legacy: legacy.Series("m1", "", "d1", "t"), want: v2.Series("m1", "p1", "d1", "t"),
+			keyType: "stream", write: []any{"XADD", "100-*", "value", "f1"}},
+		{kind: "series of the zset layout", legacy: legacy.Series("m1", "", "d1", "h"), want: v2.Series("m1", "p1", "d1", "h"),
+			keyType: "zset", write: []any{"ZADD", 100, "100:f1"}},
+		{kind: "device series", legacy: legacy.DeviceSeries("m1", "", "d1"), want: v2.DeviceSeries("m1", "p1", "d1"),
+			keyType: "stream", write: []any{"XADD", "100-*", "t", "f1"}},
+		{kind: "minute rollups", legacy: legacy.Rollup("m1", "", "d1", "t", redisRollupMinute),
+			want: v2.Rollup("m1", "p1", "d1", "t", redisRollupMinute), keyType: "zset", write: []any{"ZADD", 60000, "60000:{}"}},
+		{kind: "hour rollups", legacy: legacy.Rollup("m1", "", "d1", "t", redisRollupHour),
+			want: v2.Rollup("m1", "p1", "d1", "t", redisRollupHour), keyType: "zset", write: []any{"ZADD", 0, "0:{}"}},
+		{kind: "device status", legacy: legacy.DeviceStatus("m1", "", "d1"), want: v2.DeviceStatus("m1", "p1", "d1"),
+			keyType: "zset", write: []any{"ZADD", 100, "100:true"}},
+		{kind: "last device status", legacy: legacy.DeviceStatusLast("m1", ""), want: v2.DeviceStatusLast("m1", "p1"),
+			keyType: "hash", write: []any{"HSET", "d1", "100:true:p1"}},
+		{kind: "events", legacy: legacy.DeviceEvents("m1", "", "d1"), want: v2.DeviceEvents("m1", "p1", "d1"),
+			keyType: "stream", write: []any{"XADD", "150-*", "code", "c1"}},
+		{kind: "events of a device without data", legacy: legacy.DeviceEvents("m1", "", "d2"), want: v2.DeviceEvents("m1", "p2", "d2"),
+			keyType: "stream", write: []any{"XADD", "200-*", "code", "c2"}},
+	}
+	for _, c := range cases {
+		do(c.write[0].(string), append([]any{c.legacy}, c.write[1:]...)...)
+	}
+	do("SADD", legacy.Models(), "m1")
+	do("SADD", legacy.Points("m1"), "t")
+	do("HSET", legacy.RollupWatermarks(), "m1:"+redisRollupMinute, 120000)
+
+	movedKeys, err := s.MigrateKeys(ctx, []string{"m1"}, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movedKeys != len(cases) {
+		t.Fatalf("got %d moved keys, want %d", movedKeys, len(cases))
+	}
+	for _, c := range cases {
+		t.Run(c.kind, func(t *testing.T) {
+			if got := memory.keyType(c.legacy); got != "none" {
+				t.Fatalf("key %s of version 1 is left as a %s", c.legacy, got)
+			}
+			if got := memory.keyType(c.want); got != c.keyType {
+				t.Fatalf("got key %s of type %s, want %s", c.want, got, c.keyType)
+			}
+		})
+	}
+
+	indexes := []struct {
+		key     string
+		members []string
+	}{
+		{key: v2.DeviceIndex("m1", ""), members: []string{"d1"}},
+		{key: v2.DeviceIndex("m1", "p1"), members: []string{"d1"}},
+		{key: v2.EventIndex("m1", ""), members: []string{"d1", "d2"}},
+		{key: v2.EventIndex("m1", "p1"), members: []string{"d1"}},
+		{key: v2.EventIndex("m1", "p2"), members: []string{"d2"}},
+		{key: v2.Projects("m1"), members: []string{"p1", "p2"}},
+	}
+	for _, index := range indexes {
+		if got := memory.zRangeByScore(index.key, 0, 1e18); !reflect.DeepEqual(got, index.members) {
+			t.Fatalf("got members %v of %s, want %v", got, index.key, index.members)
+		}
+	}
+	if !memory.sets[v2.Models()]["m1"] || !memory.sets[v2.Points("m1")]["t"] {
+		t.Fatalf("the rollup registry is not migrated: %v", memory.sets)
+	}
+	if got := memory.hashes[v2.RollupWatermarks()]["m1:"+redisRollupMinute]; got != "120000" {
+		t.Fatalf("got watermark %q, want 120000", got)
+	}
+
+	// running it again moves nothing
+	if movedKeys, err = s.MigrateKeys(ctx, []string{"m1"}, "default"); err != nil || movedKeys != 0 {
+		t.Fatalf("got %d moved keys and error %v again, want none", movedKeys, err)
+	}
+}
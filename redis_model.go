@@ -2,15 +2,7 @@ package tsdb
 
 import "github.com/gogf/gf/v2/os/gtime"
 
-type RedisZSetPointData struct {
-	PointCode  string
-	PointValue int64
-	Timestamp  int64
-}
-
 type RedisDataPoint struct {
-	Value     int64
+	Value     any // int64, float64, bool or string, the same type as it is written
 	Timestamp *gtime.Time
-	idx       int // for sliding window
-	IsFilled  bool
 }
@@ -0,0 +1,142 @@
+package tsdb
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	the same device id may be in several projects, so devices are resolved to (device, project) pairs:
+	the projects of a model are in a sorted set scored by their last seen time,
+	and a device is in a project if it is in the device index of the project,
+	keys of version 1 do not contain the project, so a device is only read once, in its last seen project
+*/
+
+// redisDevice is a device in a project, the project is empty if the device has no project
+type redisDevice struct {
+	DeviceId  string
+	ProjectId string
+	LastSeen  int64 // unix time in milliseconds, 0 if it is not looked up
+}
+
+// redisIndexFunc returns the index of devices in a project, or of all devices if projectId is empty
+type redisIndexFunc func(projectId string) string
+
+func (s *redis) deviceIndexes(deviceModelName string) redisIndexFunc {
+	return func(projectId string) string {
+		return s.keys.DeviceIndex(deviceModelName, projectId)
+	}
+}
+
+func (s *redis) eventIndexes(deviceModelName string) redisIndexFunc {
+	return func(projectId string) string {
+		return s.keys.EventIndex(deviceModelName, projectId)
+	}
+}
+
+// findDevices returns the devices of deviceIds in projectId, or in all of their projects if projectId is empty,
+// devices are ordered by deviceIds and then by their last seen time in descending order,
+// in key version 1, only the last seen project of a device is returned
+func (s *redis) findDevices(
+	ctx context.Context,
+	deviceModelName string,
+	projectId string,
+	deviceIds []string,
+	indexKey redisIndexFunc,
+) ([]*redisDevice, error) {
+	devices := make([]*redisDevice, 0, len(deviceIds))
+	if projectId != "" {
+		for _, deviceId := range deviceIds {
+			devices = append(devices, &redisDevice{DeviceId: deviceId, ProjectId: projectId})
+		}
+		return devices, nil
+	}
+	deviceProjects, err := s.findProjects(ctx, s.keys.Projects(deviceModelName), indexKey, deviceIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, projects := range deviceProjects {
+		if s.keys.version == redisKeyVersion1 {
+			projects = projects[:1]
+		}
+		devices = append(devices, projects...)
+	}
+	return devices, nil
+}
+
+// findProjectIds returns one project of each device of deviceIds, projectId if it is not empty, or the last seen project
+func (s *redis) findProjectIds(
+	ctx context.Context,
+	deviceModelName string,
+	projectId string,
+	deviceIds []string,
+	indexKey redisIndexFunc,
+) ([]string, error) {
+	projectIds := make([]string, len(deviceIds))
+	if projectId != "" {
+		for i := range projectIds {
+			projectIds[i] = projectId
+		}
+		return projectIds, nil
+	}
+	deviceProjects, err := s.findProjects(ctx, s.keys.Projects(deviceModelName), indexKey, deviceIds)
+	if err != nil {
+		return nil, err
+	}
+	for i, projects := range deviceProjects {
+		projectIds[i] = projects[0].ProjectId
+	}
+	return projectIds, nil
+}
+
+// findProjects returns the projects of each device of deviceIds, the last seen first,
+// a device in no project has one project, which is empty
+func (s *redis) findProjects(
+	ctx context.Context,
+	projectsKey string,
+	indexKey redisIndexFunc,
+	deviceIds []string,
+) ([][]*redisDevice, error) {
+	deviceProjects := make([][]*redisDevice, len(deviceIds))
+	if len(deviceIds) > 0 {
+		// there are a few projects, all of them are checked
+		projectsRes, err := s.shards.Primary().Do(ctx, "ZRANGE", projectsKey, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		for _, projectId := range projectsRes.Strings() {
+			args := make([]any, 0, len(deviceIds)+1)
+			args = append(args, indexKey(projectId))
+			for _, deviceId := range deviceIds {
+				args = append(args, deviceId)
+			}
+			res, innErr := s.shards.Primary().Do(ctx, "ZMSCORE", args...)
+			if innErr != nil {
+				return nil, innErr
+			}
+			for i, score := range res.Strings() {
+				// devices not in the project have no score
+				if i < len(deviceIds) && score != "" {
+					deviceProjects[i] = append(deviceProjects[i], &redisDevice{
+						DeviceId:  deviceIds[i],
+						ProjectId: projectId,
+						LastSeen:  gconv.Int64(score),
+					})
+				}
+			}
+		}
+	}
+	for i, projects := range deviceProjects {
+		if len(projects) == 0 {
+			deviceProjects[i] = []*redisDevice{{DeviceId: deviceIds[i]}}
+			continue
+		}
+		slices.SortStableFunc(projects, func(a, b *redisDevice) int {
+			return cmp.Compare(b.LastSeen, a.LastSeen)
+		})
+	}
+	return deviceProjects, nil
+}
@@ -0,0 +1,448 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	rollups aggregate series into windows of 1m and 1h, each level with its own retention longer than dataKeep,
+	1m rollups are computed from raw data, and 1h rollups from 1m rollups.
+
+	a rollup level of a device point is a sorted set scored by the window start, member: <window start>:<record>,
+	so that a window computed again, e.g. after late data, replaces the old one.
+	models and point codes are recorded when writing, and each level keeps the end of its last window as a watermark,
+	each run computes again the windows of the late window before the watermark, at least the last one,
+	caution: data later than that, e.g. written to the zset layout with older timestamps, are never rolled up.
+
+	reads combine a level before its watermark with the finer level or raw data after it,
+	so the latest windows are not missing before they are rolled up.
+*/
+
+type redisRollupLevel struct {
+	Name     string
+	Interval time.Duration
+	Keep     time.Duration
+	Late     time.Duration // how late data are still rolled up
+}
+
+// rollupRecord is the partial aggregate of a window, records of small windows are merged into large windows
+type rollupRecord struct {
+	WindowStart  int64   `json:"-"`
+	Count        int64   `json:"c"`
+	NumericCount int64   `json:"n"` // min, max and sum are only for numeric values
+	Min          float64 `json:"min"`
+	Max          float64 `json:"max"`
+	Sum          float64 `json:"sum"`
+	Last         string  `json:"last"` // encoded by EncodeRedisValue
+}
+
+func newRollupLevels(config Config, dataKeep time.Duration) []redisRollupLevel {
+	if !config.RedisRollups {
+		return nil
+	}
+	keepMinute := redisRollupKeepMinuteDefault
+	if config.RedisRollupKeepMinute != "" {
+		if keep, err := gtime.ParseDuration(config.RedisRollupKeepMinute); err == nil && keep > 0 {
+			keepMinute = keep
+		}
+	}
+	keepMinute = max(keepMinute, dataKeep)
+	keepHour := redisRollupKeepHourDefault
+	if config.RedisRollupKeepHour != "" {
+		if keep, err := gtime.ParseDuration(config.RedisRollupKeepHour); err == nil && keep > 0 {
+			keepHour = keep
+		}
+	}
+	keepHour = max(keepHour, keepMinute)
+	var late time.Duration
+	if config.RedisRollupLateWindow != "" {
+		if window, err := gtime.ParseDuration(config.RedisRollupLateWindow); err == nil && window > 0 {
+			late = window
+		}
+	}
+	// from fine to coarse, a level is computed from the previous one
+	return []redisRollupLevel{
+		{Name: redisRollupMinute, Interval: time.Minute, Keep: keepMinute, Late: late},
+		{Name: redisRollupHour, Interval: time.Hour, Keep: keepHour, Late: late},
+	}
+}
+
+// windowRange returns the range [from, until) of windows to compute since the watermark,
+// until is the end of the last complete window, and from is before the watermark by the late window, at least a window
+func (l redisRollupLevel) windowRange(watermark int64, now int64, dataKeep time.Duration) (from int64, until int64) {
+	intervalMs := l.Interval.Milliseconds()
+	until = now - now%intervalMs
+	if watermark == 0 {
+		// the first run computes the windows of raw data
+		from = until - max(dataKeep, l.Interval).Milliseconds()
+	} else {
+		from = watermark - max(l.Late, l.Interval).Milliseconds()
+	}
+	from -= from % intervalMs
+	return from, until
+}
+
+func (r *rollupRecord) addValue(value any) {
+	r.Count++
+	r.Last = EncodeRedisValue(value)
+	if !isNumericValue(value) {
+		return
+	}
+	r.addNumeric(1, gconv.Float64(value), gconv.Float64(value), gconv.Float64(value))
+}
+
+// merge adds a later record into this one
+func (r *rollupRecord) merge(other *rollupRecord) {
+	r.Count += other.Count
+	r.Last = other.Last
+	if other.NumericCount == 0 {
+		return
+	}
+	r.addNumeric(other.NumericCount, other.Min, other.Max, other.Sum)
+}
+
+func (r *rollupRecord) addNumeric(count int64, minValue float64, maxValue float64, sum float64) {
+	if r.NumericCount == 0 {
+		r.Min, r.Max = minValue, maxValue
+	} else {
+		r.Min, r.Max = min(r.Min, minValue), max(r.Max, maxValue)
+	}
+	r.NumericCount += count
+	r.Sum += sum
+}
+
+func (r *rollupRecord) value(aggregation Aggregation) any {
+	switch aggregation.Function {
+	case aggregationCount:
+		return r.Count
+	case aggregationLast:
+		return DecodeRedisValue(r.Last)
+	}
+	if r.NumericCount == 0 {
+		return nil
+	}
+	switch aggregation.Function {
+	case aggregationAvg:
+		return r.Sum / float64(r.NumericCount)
+	case aggregationMin:
+		return r.Min
+	case aggregationMax:
+		return r.Max
+	case aggregationSum:
+		return r.Sum
+	case aggregationSpread:
+		return r.Max - r.Min
+	}
+	return nil
+}
+
+// rollupSupports returns whether the aggregation can be computed from rollup records
+func rollupSupports(aggregation Aggregation) bool {
+	switch aggregation.Function {
+	case aggregationAvg, aggregationMin, aggregationMax, aggregationSum, aggregationCount, aggregationLast, aggregationSpread:
+		return true
+	}
+	return false
+}
+
+// mergeIntoWindows merges records in ascending order of time into windows of the interval starting at offset plus its multiples
+func mergeIntoWindows(records []*rollupRecord, intervalMs int64, offsetMs int64) []*rollupRecord {
+	out := make([]*rollupRecord, 0)
+	for _, record := range records {
+		windowStart := record.WindowStart - ((record.WindowStart-offsetMs)%intervalMs+intervalMs)%intervalMs
+		if len(out) == 0 || out[len(out)-1].WindowStart != windowStart {
+			out = append(out, &rollupRecord{WindowStart: windowStart})
+		}
+		out[len(out)-1].merge(record)
+	}
+	return out
+}
+
+func encodeRollupMember(record *rollupRecord) (string, error) {
+	encoded, err := gjson.Encode(record)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%s", record.WindowStart, encoded), nil
+}
+
+func parseRollupMember(member string) *rollupRecord {
+	windowStart, encoded, found := strings.Cut(member, ":")
+	if !found {
+		return nil
+	}
+	record := &rollupRecord{}
+	if err := gjson.DecodeTo(encoded, record); err != nil {
+		return nil
+	}
+	record.WindowStart = gconv.Int64(windowStart)
+	return record
+}
+
+// chooseRollupLevel returns the coarsest level that fits the interval and aggregations, -1 if none fits
+func (s *redis) chooseRollupLevel(interval string, aggregations []Aggregation) int {
+	duration, err := gtime.ParseDuration(interval)
+	if err != nil || duration <= 0 {
+		return -1
+	}
+	for _, aggregation := range aggregations {
+		if !rollupSupports(aggregation) {
+			return -1
+		}
+	}
+	for i := len(s.rollupLevels) - 1; i >= 0; i-- {
+		if duration%s.rollupLevels[i].Interval == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *redis) rollupWatermarks(ctx context.Context) (map[string]int64, error) {
+	res, err := s.shards.Primary().HGetAll(ctx, s.keys.RollupWatermarks())
+	if err != nil {
+		return nil, err
+	}
+	watermarks := make(map[string]int64)
+	for field, watermark := range res.MapStrStr() {
+		watermarks[field] = gconv.Int64(watermark)
+	}
+	return watermarks, nil
+}
+
+// readRollupRecords returns records of a device in [startTime, endTime] from a level, and from finer levels after its watermark,
+// a level of -1 means raw data
+func (s *redis) readRollupRecords(
+	ctx context.Context,
+	levelIdx int,
+	watermarks map[string]int64,
+	deviceModelName string,
+	projectId string,
+	deviceId string,
+	pointCodes []string,
+	startTime int64,
+	endTime int64,
+	malformed redisMalformedCounts, // counts of skipped entries are added to it, it may be nil
+) (map[string][]*rollupRecord, error) {
+	out := make(map[string][]*rollupRecord)
+	if startTime > endTime {
+		return out, nil
+	}
+	if levelIdx < 0 {
+		allDeviceData, err := s.batchQueryDeviceData(
+			ctx, deviceModelName, []string{deviceId}, []string{projectId}, pointCodes, startTime, endTime, malformed,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for pointCode, dataPoints := range allDeviceData[deviceId] {
+			for _, dataPoint := range dataPoints {
+				record := &rollupRecord{WindowStart: dataPoint.Timestamp.UnixMilli()}
+				record.addValue(dataPoint.Value)
+				out[pointCode] = append(out[pointCode], record)
+			}
+		}
+		return out, nil
+	}
+
+	level := s.rollupLevels[levelIdx]
+	watermark := watermarks[fmt.Sprintf("%s:%s", deviceModelName, level.Name)]
+	if rollupEnd := min(endTime, watermark-1); startTime <= rollupEnd {
+		for _, pointCode := range pointCodes {
+			rollupKey := s.keys.Rollup(deviceModelName, projectId, deviceId, pointCode, level.Name)
+			members, err := s.zRangeByScore(ctx, s.shards.Device(deviceModelName, deviceId), rollupKey, startTime, rollupEnd)
+			if err != nil {
+				return nil, err
+			}
+			malformedMembers := 0
+			for _, member := range members {
+				record := parseRollupMember(member)
+				if record == nil {
+					malformedMembers++
+					continue
+				}
+				out[pointCode] = append(out[pointCode], record)
+			}
+			s.reportMalformed(ctx, rollupKey, malformedMembers)
+			malformed.add(deviceId, pointCode, malformedMembers)
+		}
+	}
+	// data after the watermark are not rolled up yet
+	tail, err := s.readRollupRecords(
+		ctx, levelIdx-1, watermarks, deviceModelName, projectId, deviceId, pointCodes, max(startTime, watermark), endTime, malformed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for pointCode, records := range tail {
+		out[pointCode] = append(out[pointCode], records...)
+	}
+	return out, nil
+}
+
+// readSeriesFromRollups reads windows from the records of a level,
+// windows are aligned to the multiples of interval, or start at StartTime if alignWindows is false,
+// then a record is in the window of its start, since records are not split
+func (s *redis) readSeriesFromRollups(
+	ctx context.Context,
+	in ReadDeviceSeriesDataInput,
+	levelIdx int,
+	projectIds []string, // aligned with in.DeviceIds
+	aggregations []Aggregation,
+	alignWindows bool,
+	malformed redisMalformedCounts,
+) ([]*SeriesResult, error) {
+	watermarks, err := s.rollupWatermarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	startTime := gtime.NewFromTimeStamp(in.StartTime).UnixMilli()
+	endTime := gtime.NewFromTimeStamp(in.EndTime).UnixMilli()
+	// records of the whole first window are needed, since windows are aligned to the multiples of interval
+	duration, _ := gtime.ParseDuration(in.Interval)
+	windowStart, offsetMs := startTime-startTime%duration.Milliseconds(), int64(0)
+	if !alignWindows {
+		windowStart, offsetMs = startTime, startTime
+	}
+	allDeviceRecords := make(map[string]map[string][]*rollupRecord)
+	for i, deviceId := range in.DeviceIds {
+		deviceRecords, innErr := s.readRollupRecords(
+			ctx, levelIdx, watermarks, in.DeviceModelName, projectIds[i], deviceId, in.PointCodes, windowStart, endTime, malformed,
+		)
+		if innErr != nil {
+			return nil, innErr
+		}
+		allDeviceRecords[deviceId] = deviceRecords
+	}
+	return applyWindowsAndFill(
+		in.DeviceModelName,
+		in.StartTime,
+		in.EndTime,
+		in.Interval,
+		in.FillOption,
+		in.DeviceIds,
+		in.PointCodes,
+		alignWindows,
+		func(deviceId string, pointIdx int, windowStarts []int64, durationMs int64) []any {
+			windows := mergeIntoWindows(allDeviceRecords[deviceId][in.PointCodes[pointIdx]], durationMs, offsetMs)
+			values := make([]any, len(windowStarts))
+			windowIdx := 0
+			for i, windowStart := range windowStarts {
+				for windowIdx < len(windows) && windows[windowIdx].WindowStart < windowStart {
+					windowIdx++
+				}
+				if windowIdx < len(windows) && windows[windowIdx].WindowStart == windowStart {
+					values[i] = windows[windowIdx].value(aggregations[pointIdx])
+				}
+			}
+			return values
+		},
+	)
+}
+
+// rollup is the maintenance job that computes the complete windows of all levels since their watermarks
+func (s *redis) rollup(ctx context.Context) error {
+	modelsRes, err := s.shards.Primary().Do(ctx, "SMEMBERS", s.keys.Models())
+	if err != nil {
+		return err
+	}
+	for _, deviceModelName := range modelsRes.Strings() {
+		if err = s.rollupModel(ctx, deviceModelName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redis) rollupModel(ctx context.Context, deviceModelName string) error {
+	pointsRes, err := s.shards.Primary().Do(ctx, "SMEMBERS", s.keys.Points(deviceModelName))
+	if err != nil {
+		return err
+	}
+	pointCodes := pointsRes.Strings()
+	sort.Strings(pointCodes)
+	if len(pointCodes) == 0 {
+		return nil
+	}
+	for levelIdx, level := range s.rollupLevels {
+		watermarks, innErr := s.rollupWatermarks(ctx)
+		if innErr != nil {
+			return innErr
+		}
+		watermarkField := fmt.Sprintf("%s:%s", deviceModelName, level.Name)
+		intervalMs := level.Interval.Milliseconds()
+		now := gtime.Now().UnixMilli()
+		from, until := level.windowRange(watermarks[watermarkField], now, s.dataKeep)
+		if from >= until {
+			continue
+		}
+		deviceIds, innErr := s.zRangeSeenSince(ctx, s.keys.DeviceIndex(deviceModelName, ""), from)
+		if innErr != nil {
+			return innErr
+		}
+		devices, innErr := s.findDevices(ctx, deviceModelName, "", deviceIds, s.deviceIndexes(deviceModelName))
+		if innErr != nil {
+			return innErr
+		}
+
+		minWindowStart := now - level.Keep.Milliseconds()
+		keepSeconds := gconv.Int64(level.Keep.Seconds())
+		batches := make(redisGroupBatches)
+		for _, device := range devices {
+			deviceId, projectId := device.DeviceId, device.ProjectId
+			batch := batches.For(s.shards.DeviceGroup(deviceModelName, deviceId))
+			// the level is computed from the finer level, or raw data for the finest level
+			deviceRecords, loopErr := s.readRollupRecords(
+				ctx, levelIdx-1, watermarks, deviceModelName, projectId, deviceId, pointCodes, from, until-1, nil,
+			)
+			if loopErr != nil {
+				return loopErr
+			}
+			for pointCode, records := range deviceRecords {
+				rollupKey := s.keys.Rollup(deviceModelName, projectId, deviceId, pointCode, level.Name)
+				for _, window := range mergeIntoWindows(records, intervalMs, 0) {
+					member, encodeErr := encodeRollupMember(window)
+					if encodeErr != nil {
+						return encodeErr
+					}
+					batch.Add("ZREMRANGEBYSCORE", rollupKey, window.WindowStart, window.WindowStart)
+					batch.Add("ZADD", rollupKey, window.WindowStart, member)
+				}
+				batch.Add("ZREMRANGEBYSCORE", rollupKey, "-inf", fmt.Sprintf("(%d", minWindowStart))
+				batch.Add("EXPIRE", rollupKey, keepSeconds)
+			}
+			if batches.Len() >= redisBatchMaxCommands {
+				if loopErr = s.execRollupBatches(ctx, batches); loopErr != nil {
+					return loopErr
+				}
+				batches = make(redisGroupBatches)
+			}
+		}
+		if innErr = s.execRollupBatches(ctx, batches); innErr != nil {
+			return innErr
+		}
+		if _, innErr = s.shards.Primary().HSet(ctx, s.keys.RollupWatermarks(), map[string]any{watermarkField: until}); innErr != nil {
+			return innErr
+		}
+	}
+	return nil
+}
+
+func (s *redis) execRollupBatches(ctx context.Context, batches redisGroupBatches) error {
+	commandErrors, _, err := s.execBatches(ctx, batches)
+	if err != nil {
+		return err
+	}
+	if len(commandErrors) > 0 {
+		return &RedisBatchError{Errors: commandErrors}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+func TestChooseRollupLevel(t *testing.T) {
+	s := &redis{rollupLevels: newRollupLevels(Config{RedisRollups: true}, time.Hour)}
+	avg := []Aggregation{{Function: aggregationAvg}}
+	cases := []struct {
+		name         string
+		interval     string
+		aggregations []Aggregation
+		want         int
+	}{
+		{name: "seconds are read from raw data", interval: "30s", aggregations: avg, want: -1},
+		{name: "minutes", interval: "5m", aggregations: avg, want: 0},
+		{name: "not a multiple of a minute", interval: "90s", aggregations: avg, want: -1},
+		{name: "hours", interval: "2h", aggregations: avg, want: 1},
+		{name: "a multiple of minutes only", interval: "90m", aggregations: avg, want: 0},
+		{name: "all aggregations must be supported", interval: "1h", aggregations: []Aggregation{
+			{Function: aggregationMax},
+			{Function: aggregationTwa},
+		}, want: -1},
+		{name: "percentile", interval: "1h", aggregations: []Aggregation{{Function: aggregationPercentile, Percentile: 90}}, want: -1},
+		{name: "invalid interval", interval: "x", aggregations: avg, want: -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := s.chooseRollupLevel(c.interval, c.aggregations); got != c.want {
+				t.Fatalf("got level %d, want %d", got, c.want)
+			}
+		})
+	}
+	if got := (&redis{}).chooseRollupLevel("1h", avg); got != -1 {
+		t.Fatalf("got level %d without rollups, want -1", got)
+	}
+}
+
+func TestRollupRecordMerge(t *testing.T) {
+	// raw data of two minutes, with a non numeric value
+	raw := make([]*rollupRecord, 0)
+	for _, dataPoint := range []struct {
+		time  int64
+		value any
+	}{
+		{time: 0, value: int64(3)},
+		{time: 20_000, value: 1.5},
+		{time: 40_000, value: "on"},
+		{time: 60_000, value: int64(-2)},
+		{time: 119_999, value: int64(4)},
+	} {
+		record := &rollupRecord{WindowStart: dataPoint.time}
+		record.addValue(dataPoint.value)
+		raw = append(raw, record)
+	}
+	minutes := mergeIntoWindows(raw, time.Minute.Milliseconds(), 0)
+	if len(minutes) != 2 {
+		t.Fatalf("got %d windows, want 2", len(minutes))
+	}
+	wantFirst := &rollupRecord{WindowStart: 0, Count: 3, NumericCount: 2, Min: 1.5, Max: 3, Sum: 4.5, Last: EncodeRedisValue("on")}
+	if !reflect.DeepEqual(minutes[0], wantFirst) {
+		t.Fatalf("got %+v, want %+v", minutes[0], wantFirst)
+	}
+
+	// records of minutes are merged into hours as raw data into minutes
+	hours := mergeIntoWindows(minutes, time.Hour.Milliseconds(), 0)
+	if len(hours) != 1 {
+		t.Fatalf("got %d windows, want 1", len(hours))
+	}
+	hour := hours[0]
+	cases := []struct {
+		function string
+		want     any
+	}{
+		{function: aggregationCount, want: int64(5)},
+		{function: aggregationLast, want: int64(4)},
+		{function: aggregationMin, want: -2.0},
+		{function: aggregationMax, want: 4.0},
+		{function: aggregationSum, want: 6.5},
+		{function: aggregationAvg, want: 6.5 / 4},
+		{function: aggregationSpread, want: 6.0},
+	}
+	for _, c := range cases {
+		t.Run(c.function, func(t *testing.T) {
+			if got := hour.value(Aggregation{Function: c.function}); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	// a window without numeric values has only count and last
+	text := &rollupRecord{}
+	text.addValue("off")
+	if got := text.value(Aggregation{Function: aggregationAvg}); got != nil {
+		t.Fatalf("got avg %v of text values, want nil", got)
+	}
+}
+
+func TestRollupMember(t *testing.T) {
+	record := &rollupRecord{WindowStart: 1_700_000_040_000, Count: 2, NumericCount: 1, Min: 1, Max: 1, Sum: 1, Last: EncodeRedisValue("on")}
+	member, err := encodeRollupMember(record)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := parseRollupMember(member); !reflect.DeepEqual(got, record) {
+		t.Fatalf("got %+v, want %+v", got, record)
+	}
+	if got := parseRollupMember("1700000040000:{"); got != nil {
+		t.Fatalf("got %+v of a malformed member, want nil", got)
+	}
+}
+
+func TestRollupWindowRange(t *testing.T) {
+	const minute = int64(60_000)
+	const now = 1_700_000_040_000 + 30_000 // 30s in a window
+	cases := []struct {
+		name      string
+		late      time.Duration
+		watermark int64
+		wantFrom  int64
+		wantUntil int64
+	}{
+		{
+			name:      "the first run computes windows of raw data",
+			watermark: 0,
+			wantFrom:  now - 30_000 - 60*minute,
+			wantUntil: now - 30_000,
+		},
+		{
+			name:      "the last window is computed again",
+			watermark: now - 30_000 - 2*minute,
+			wantFrom:  now - 30_000 - 3*minute,
+			wantUntil: now - 30_000,
+		},
+		{
+			name:      "windows of the late window are computed again",
+			late:      10 * time.Minute,
+			watermark: now - 30_000 - 2*minute,
+			wantFrom:  now - 30_000 - 12*minute,
+			wantUntil: now - 30_000,
+		},
+		{
+			name:      "the late window is aligned to windows",
+			late:      90 * time.Second,
+			watermark: now - 30_000,
+			wantFrom:  now - 30_000 - 2*minute,
+			wantUntil: now - 30_000,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			level := redisRollupLevel{Name: redisRollupMinute, Interval: time.Minute, Late: c.late}
+			from, until := level.windowRange(c.watermark, now, time.Hour)
+			if from != c.wantFrom || until != c.wantUntil {
+				t.Fatalf("got [%d, %d), want [%d, %d)", from, until, c.wantFrom, c.wantUntil)
+			}
+		})
+	}
+}
+
+func TestNewRollupLevels(t *testing.T) {
+	levels := newRollupLevels(Config{
+		RedisRollups:          true,
+		RedisRollupKeepMinute: "1h", // shorter than dataKeep
+		RedisRollupKeepHour:   "x",
+		RedisRollupLateWindow: "15m",
+	}, 2*time.Hour)
+	want := []redisRollupLevel{
+		{Name: redisRollupMinute, Interval: time.Minute, Keep: 2 * time.Hour, Late: 15 * time.Minute},
+		{Name: redisRollupHour, Interval: time.Hour, Keep: redisRollupKeepHourDefault, Late: 15 * time.Minute},
+	}
+	if !reflect.DeepEqual(levels, want) {
+		t.Fatalf("got %+v, want %+v", levels, want)
+	}
+	if levels = newRollupLevels(Config{}, time.Hour); levels != nil {
+		t.Fatalf("got %+v without rollups, want nil", levels)
+	}
+}
+
+func TestReadToSeriesFromRollups(t *testing.T) {
+	memory, group := newMemoryRedis(t)
+	const (
+		day   = int64(24 * time.Hour / time.Millisecond)
+		hour  = int64(time.Hour / time.Millisecond)
+		base  = 19675 * day // a multiple of a day
+		model = "m1"
+	)
+	// raw data only cover the last hour, older data are in the hour rollups
+	s := &redis{
+		keys:         redisKeyBuilder{version: redisKeyVersion2},
+		shards:       newRedisShards(group, nil),
+		rollupLevels: newRollupLevels(Config{RedisRollups: true}, time.Hour),
+		dataKeep:     time.Hour,
+	}
+	ctx := context.Background()
+	watermark := base + 3*day
+	for _, level := range s.rollupLevels {
+		memory.Do(ctx, "HSET", s.keys.RollupWatermarks(), fmt.Sprintf("%s:%s", model, level.Name), watermark)
+	}
+	for i, windowStart := range []int64{base + hour, base + day + 2*hour} {
+		record := &rollupRecord{WindowStart: windowStart}
+		record.addValue(float64(10 * (i + 1)))
+		member, err := encodeRollupMember(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		memory.Do(ctx, "ZADD", s.keys.Rollup(model, "p1", "d1", "t", redisRollupHour), windowStart, member)
+	}
+	memory.Do(ctx, "XADD", s.keys.Series(model, "p1", "d1", "t"), fmt.Sprintf("%d-*", watermark+5*time.Minute.Milliseconds()),
+		"value", EncodeRedisValue(30.0))
+
+	// windows start at StartTime, which is not a multiple of the interval
+	seriesData, timestamps, err := s.ReadToSeries(ctx, ReadDeviceSeriesDataInput{
+		DeviceIds:       []string{"d1"},
+		DeviceModelName: model,
+		ProjectId:       "p1",
+		PointCodes:      []string{"t"},
+		StartTime:       base + 30*time.Minute.Milliseconds(),
+		EndTime:         watermark + 10*time.Minute.Milliseconds(),
+		Interval:        "1d",
+		Aggregation:     "max",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTimestamps := []int64{base + day + hour/2, base + 2*day + hour/2, base + 3*day + hour/2}
+	if !reflect.DeepEqual(timestamps, wantTimestamps) {
+		t.Fatalf("got timestamps %v, want %v", timestamps, wantTimestamps)
+	}
+	if len(seriesData) != 1 || len(seriesData[0]) != 3 {
+		t.Fatalf("got series %v, want 1 series of 3 windows", seriesData)
+	}
+	for i, want := range []float64{10, 20, 30} {
+		if got := gconv.Float64(seriesData[0][i]); got != want {
+			t.Fatalf("got %v in window %d, want %v", seriesData[0][i], i, want)
+		}
+	}
+}
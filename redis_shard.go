@@ -0,0 +1,94 @@
+package tsdb
+
+import (
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+/*
+	data of devices are sharded across goframe redis groups by consistent hashing on model and device,
+	each group has virtual nodes on the ring, so adding a group only moves a part of devices.
+	the project is not a part of the shard key, since a device may be read before its project is known.
+	keys shared by devices, like device indexes, the rollup registry and maintenance, stay in the primary group.
+*/
+
+type redisShardNode struct {
+	hash  uint32
+	group string
+}
+
+type redisShards struct {
+	primary string
+	groups  []string // primary and shard groups without duplicates
+	ring    []redisShardNode
+}
+
+func newRedisShards(primary string, shardGroups []string) *redisShards {
+	shards := &redisShards{primary: primary, groups: []string{primary}}
+	for _, group := range shardGroups {
+		if !slices.Contains(shards.groups, group) {
+			shards.groups = append(shards.groups, group)
+		}
+		for i := 0; i < redisShardVirtualNodes; i++ {
+			shards.ring = append(shards.ring, redisShardNode{
+				hash:  crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", group, i))),
+				group: group,
+			})
+		}
+	}
+	sort.Slice(shards.ring, func(i, j int) bool {
+		return shards.ring[i].hash < shards.ring[j].hash
+	})
+	return shards
+}
+
+func (r *redisShards) IsSharded() bool {
+	return len(r.ring) > 0
+}
+
+func (r *redisShards) Primary() *gredis.Redis {
+	return g.Redis(r.primary)
+}
+
+// DeviceGroup returns the group holding the data of a device
+func (r *redisShards) DeviceGroup(deviceModelName string, deviceId string) string {
+	if !r.IsSharded() {
+		return r.primary
+	}
+	hash := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s:%s", deviceModelName, deviceId)))
+	idx := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i].hash >= hash
+	})
+	if idx == len(r.ring) {
+		idx = 0
+	}
+	return r.ring[idx].group
+}
+
+func (r *redisShards) Device(deviceModelName string, deviceId string) *gredis.Redis {
+	return g.Redis(r.DeviceGroup(deviceModelName, deviceId))
+}
+
+// Groups returns the primary group and all shard groups
+func (r *redisShards) Groups() []string {
+	return r.groups
+}
+
+// redisHashTag returns the hash tag of a key as redis cluster does, it is empty if the key has no hash tag
+func redisHashTag(key string) string {
+	start := strings.Index(key, "{")
+	if start < 0 {
+		return ""
+	}
+	end := strings.Index(key[start+1:], "}")
+	if end <= 0 {
+		return ""
+	}
+	return key[start+1 : start+1+end]
+}
@@ -0,0 +1,214 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	last seen times are read from the device index,
+	status events of a device are kept in a sorted set scored by time, as long as the device index,
+	the last event of each device of a model is also kept in a hash, so that tracking does not read every device
+*/
+
+func (s *redis) TrackDeviceStatus(ctx context.Context, deviceModelName string) (events []*DeviceStatusEvent, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedis, operationTrackStatus, deviceModelName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	return trackDeviceStatus(ctx, s, deviceModelName, s.realTimeWindow)
+}
+
+func (s *redis) ReadDeviceStatus(ctx context.Context, in ReadDeviceStatusInput) (devices []*DeviceStatus, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedis, operationReadStatus, in.DeviceModelName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	return readDeviceStatus(ctx, s, in, s.realTimeWindow)
+}
+
+func (s *redis) ReadDeviceUptime(ctx context.Context, in ReadDeviceUptimeInput) (uptime *DeviceUptime, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedis, operationReadUptime, in.DeviceModelName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	return readDeviceUptime(ctx, s, in)
+}
+
+func (s *redis) lastSeen(ctx context.Context, deviceModelName string, projectId string, deviceIds []string) ([]*DeviceStatus, error) {
+	targetDeviceIds := deviceIds
+	if len(deviceIds) == 0 {
+		res, err := s.shards.Primary().Do(ctx, "ZRANGE", s.keys.DeviceIndex(deviceModelName, projectId), 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		targetDeviceIds = res.Strings()
+	}
+	devices, err := s.findDevices(ctx, deviceModelName, projectId, targetDeviceIds, s.deviceIndexes(deviceModelName))
+	if err != nil {
+		return nil, err
+	}
+	// devices of the given project and devices without a project are looked up in the index of their project
+	unresolved := make(map[string][]*redisDevice)
+	for _, device := range devices {
+		if device.LastSeen == 0 {
+			unresolved[device.ProjectId] = append(unresolved[device.ProjectId], device)
+		}
+	}
+	for deviceProjectId, projectDevices := range unresolved {
+		args := make([]any, 0, len(projectDevices)+1)
+		args = append(args, s.keys.DeviceIndex(deviceModelName, deviceProjectId))
+		for _, device := range projectDevices {
+			args = append(args, device.DeviceId)
+		}
+		res, innErr := s.shards.Primary().Do(ctx, "ZMSCORE", args...)
+		if innErr != nil {
+			return nil, innErr
+		}
+		for i, score := range res.Strings() {
+			// devices never seen have no score
+			if i < len(projectDevices) && score != "" {
+				projectDevices[i].LastSeen = gconv.Int64(score)
+			}
+		}
+	}
+	statuses := make([]*DeviceStatus, 0, len(devices))
+	for _, device := range devices {
+		if device.LastSeen == 0 {
+			continue
+		}
+		statuses = append(statuses, &DeviceStatus{
+			DeviceModelName: deviceModelName,
+			DeviceId:        device.DeviceId,
+			ProjectId:       device.ProjectId,
+			LastSeen:        device.LastSeen,
+		})
+	}
+	return statuses, nil
+}
+
+func (s *redis) lastStatusEvents(ctx context.Context, deviceModelName string) (map[deviceStatusKey]*DeviceStatusEvent, error) {
+	// devices without a project, and devices of each project
+	projectIds := []string{""}
+	if s.keys.version != redisKeyVersion1 {
+		projectsRes, err := s.shards.Primary().Do(ctx, "ZRANGE", s.keys.Projects(deviceModelName), 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		projectIds = append(projectIds, projectsRes.Strings()...)
+	}
+	events := make(map[deviceStatusKey]*DeviceStatusEvent)
+	for _, projectId := range projectIds {
+		res, err := s.shards.Primary().HGetAll(ctx, s.keys.DeviceStatusLast(deviceModelName, projectId))
+		if err != nil {
+			return nil, err
+		}
+		for deviceId, member := range res.MapStrStr() {
+			event := parseStatusMember(member)
+			if event == nil {
+				continue
+			}
+			event.DeviceModelName = deviceModelName
+			event.DeviceId = deviceId
+			if s.keys.version != redisKeyVersion1 {
+				event.ProjectId = projectId
+			}
+			events[deviceStatusKey{deviceId: deviceId, projectId: event.ProjectId}] = event
+		}
+	}
+	return events, nil
+}
+
+func (s *redis) writeStatusEvents(ctx context.Context, events []*DeviceStatusEvent) error {
+	keep := max(s.dataKeep, s.latestKeep)
+	keepSeconds := gconv.Int64(keep.Seconds())
+	minTime := gtime.Now().Add(-1 * keep).UnixMilli()
+	batches := make(redisGroupBatches)
+	for _, event := range events {
+		member := encodeStatusMember(event)
+		statusKey := s.keys.DeviceStatus(event.DeviceModelName, event.ProjectId, event.DeviceId)
+		batch := batches.For(s.shards.DeviceGroup(event.DeviceModelName, event.DeviceId))
+		batch.Add("ZADD", statusKey, event.Time, member)
+		if s.latestKeep > 0 {
+			batch.Add("ZREMRANGEBYSCORE", statusKey, "-inf", fmt.Sprintf("(%d", minTime))
+			batch.Add("EXPIRE", statusKey, keepSeconds)
+		} else {
+			batch.Add("PERSIST", statusKey)
+		}
+		// the project is kept in the last event, since the hash of version 1 is shared by projects
+		batches.For(s.shards.primary).Add(
+			"HSET", s.keys.DeviceStatusLast(event.DeviceModelName, event.ProjectId), event.DeviceId, encodeLastStatusMember(event),
+		)
+	}
+	commandErrors, _, err := s.execBatches(ctx, batches)
+	if err != nil {
+		return err
+	}
+	if len(commandErrors) > 0 {
+		return &RedisBatchError{Errors: commandErrors}
+	}
+	return nil
+}
+
+func (s *redis) statusEvents(
+	ctx context.Context,
+	deviceModelName string,
+	projectId string,
+	deviceId string,
+	startTime int64,
+	endTime int64,
+) ([]*DeviceStatusEvent, error) {
+	projectIds, err := s.findProjectIds(ctx, deviceModelName, projectId, []string{deviceId}, s.deviceIndexes(deviceModelName))
+	if err != nil {
+		return nil, err
+	}
+	statusKey := s.keys.DeviceStatus(deviceModelName, projectIds[0], deviceId)
+	client := s.shards.Device(deviceModelName, deviceId)
+	// the last event before the range tells the status at the start
+	previousRes, err := client.Do(ctx, "ZREVRANGEBYSCORE", statusKey, fmt.Sprintf("(%d", startTime), "-inf", "LIMIT", 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(ctx, "ZRANGEBYSCORE", statusKey, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*DeviceStatusEvent, 0)
+	for _, member := range append(previousRes.Strings(), res.Strings()...) {
+		event := parseStatusMember(member)
+		if event == nil {
+			continue
+		}
+		event.DeviceModelName = deviceModelName
+		event.DeviceId = deviceId
+		event.ProjectId = projectIds[0]
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// encodeStatusMember encodes an event as <time>:<online>, so that events of the same time are not merged
+func encodeStatusMember(event *DeviceStatusEvent) string {
+	return fmt.Sprintf("%d:%t", event.Time, event.Online)
+}
+
+// encodeLastStatusMember encodes the last event of a device as <time>:<online>:<project>
+func encodeLastStatusMember(event *DeviceStatusEvent) string {
+	return fmt.Sprintf("%s:%s", encodeStatusMember(event), event.ProjectId)
+}
+
+// parseStatusMember parses members of both encodeStatusMember and encodeLastStatusMember
+func parseStatusMember(member string) *DeviceStatusEvent {
+	eventTime, rest, found := strings.Cut(member, ":")
+	if !found {
+		return nil
+	}
+	online, projectId, _ := strings.Cut(rest, ":")
+	isOnline, err := strconv.ParseBool(online)
+	if err != nil {
+		return nil
+	}
+	return &DeviceStatusEvent{Time: gconv.Int64(eventTime), Online: isOnline, ProjectId: projectId}
+}
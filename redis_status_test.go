@@ -0,0 +1,59 @@
+package tsdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatusMember(t *testing.T) {
+	cases := []struct {
+		name   string
+		member string
+		want   *DeviceStatusEvent
+	}{
+		{
+			name:   "last event",
+			member: encodeLastStatusMember(&DeviceStatusEvent{ProjectId: "p1", Online: true, Time: 10}),
+			want:   &DeviceStatusEvent{ProjectId: "p1", Online: true, Time: 10},
+		},
+		{
+			name:   "last event without a project",
+			member: encodeLastStatusMember(&DeviceStatusEvent{Online: false, Time: 10}),
+			want:   &DeviceStatusEvent{Online: false, Time: 10},
+		},
+		{
+			name:   "event",
+			member: encodeStatusMember(&DeviceStatusEvent{ProjectId: "p1", Online: true, Time: 10}),
+			want:   &DeviceStatusEvent{Online: true, Time: 10},
+		},
+		{name: "malformed", member: "10", want: nil},
+		{name: "malformed online", member: "10:yes", want: nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := parseStatusMember(c.member); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRedisKeyBuilderDeviceStatusLast(t *testing.T) {
+	cases := []struct {
+		name      string
+		keys      redisKeyBuilder
+		projectId string
+		want      string
+	}{
+		{name: "version 1", keys: redisKeyBuilder{version: redisKeyVersion1}, projectId: "p1", want: "m1:" + redisKeyDeviceStatusLast},
+		{name: "version 2", keys: redisKeyBuilder{version: redisKeyVersion2}, projectId: "p1", want: "v2:p1:m1:" + redisKeyDeviceStatusLast},
+		{name: "version 2 without a project", keys: redisKeyBuilder{version: redisKeyVersion2}, want: "v2:m1:" + redisKeyDeviceStatusLast},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.keys.DeviceStatusLast("m1", c.projectId); got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
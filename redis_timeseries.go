@@ -0,0 +1,515 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	it requires the RedisTimeSeries module, e.g. redis-stack
+
+	create 1 time series for each device point, keys are built by redisKeyBuilder, see redis_key.go,
+	labeled by model, device, project and point, so that queries select series by label filters,
+	retention is DataKeep, and the last write wins for the same timestamp.
+	values are stored as double, bool is stored as 1 or 0, and string values are rejected.
+
+	latest data are the last samples not older than RealTimeWindow,
+	series data are aggregated by redis in buckets aligned to the multiples of interval.
+*/
+
+type redisTimeSeries struct {
+	group          string // goframe redis group
+	keys           redisKeyBuilder
+	dataKeep       time.Duration
+	realTimeWindow time.Duration
+	sync.Mutex
+}
+
+func NewRedisTimeSeriesClient() Client {
+	return &redisTimeSeries{}
+}
+
+func (s *redisTimeSeries) Init(ctx context.Context, config Config) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.group = config.RedisGroup
+	if g.Redis(s.group) == nil {
+		return fmt.Errorf("redis is not initialized because of no configs")
+	}
+	if !s.IsHealthy(ctx) {
+		return fmt.Errorf("we cannot connect to the redis server now")
+	}
+	// it fails with an unknown command error if the module is not loaded
+	if _, err := g.Redis(s.group).Do(ctx, "TS.QUERYINDEX", fmt.Sprintf("%s=%s", redisTimeSeriesLabelModel, "_")); err != nil {
+		return fmt.Errorf("redis timeseries module is not available: %w", err)
+	}
+	keys, err := newRedisKeyBuilder(config)
+	if err != nil {
+		return err
+	}
+	if keys.hashTag {
+		// TS.MRANGE and TS.MGET only query one node of a cluster
+		return fmt.Errorf("redis cluster mode is not supported by redis timeseries")
+	}
+	s.keys = keys
+	_, s.dataKeep = mustGetDataKeepFromConfig(config, ClientTypeRedisTimeSeries)
+	_, s.realTimeWindow = mustGetRealTimeWindowFromConfig(config)
+	return nil
+}
+
+func (s *redisTimeSeries) IsHealthy(ctx context.Context) bool {
+	res, err := g.Redis(s.group).Do(ctx, "PING")
+	if err != nil {
+		return false
+	}
+	return !res.IsEmpty()
+}
+
+func (s *redisTimeSeries) Write(ctx context.Context, metrics []*Metric) (err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedisTimeSeries, operationWrite, "", countMetricPoints(metrics))
+	defer func() { observer.End(ctx, err) }()
+
+	var writtenPoints, writtenBytes int
+	retention := s.dataKeep.Milliseconds()
+	commandErrors := make([]RedisCommandError, 0)
+	batch := newRedisBatch()
+	flush := func() error {
+		failed, innErr := execRedisBatch(ctx, g.Redis(s.group), batch)
+		if innErr != nil {
+			return innErr
+		}
+		writtenPoints += batch.Points()
+		for _, commandErr := range failed {
+			writtenPoints -= commandErr.points
+		}
+		commandErrors = append(commandErrors, failed...)
+		batch = newRedisBatch()
+		return nil
+	}
+	for _, metric := range metrics {
+		// tags and fields of a valid metric should not be empty
+		if len(metric.TagList) == 0 || len(metric.FieldList) == 0 {
+			continue
+		}
+		deviceId, _ := metric.GetTag(tdengineColumnDevice)
+		if deviceId == "" {
+			// deviceId is a must
+			continue
+		}
+		projectId, _ := metric.GetTag(tdengineColumnProject)
+		timestamp := metric.Time.UnixMilli()
+		for _, field := range metric.FieldList {
+			key := s.keys.TimeSeries(metric.Name, projectId, deviceId, field.Key)
+			value, ok := timeSeriesValue(field.Value)
+			if !ok {
+				commandErrors = append(commandErrors, RedisCommandError{
+					Command: "TS.ADD",
+					Key:     key,
+					Message: fmt.Sprintf("value of type %T is not supported", field.Value),
+				})
+				continue
+			}
+			// labels and retention are only applied when the series is created
+			args := []any{timestamp, value, "RETENTION", retention, "ON_DUPLICATE", "LAST", "LABELS"}
+			args = append(args, s.labels(metric.Name, deviceId, projectId, field.Key)...)
+			batch.AddWrite(1, "TS.ADD", key, args...)
+			writtenBytes += len(key) + 8
+		}
+		if batch.Len() >= redisBatchMaxCommands {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+	observer.RecordWrite(ctx, writtenPoints, writtenBytes)
+	if len(commandErrors) > 0 {
+		return &RedisBatchError{Errors: commandErrors}
+	}
+	return nil
+}
+
+func (s *redisTimeSeries) ReadToMap(
+	ctx context.Context,
+	in ReadDeviceLatestDataInput,
+	dataFilterMap map[string]float64,
+) (pointCodeValueMaps []map[string]any, pointCodes [][]string, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedisTimeSeries, operationReadToMap, in.DeviceModelName, len(in.PointCodes))
+	defer func() { observer.End(ctx, err) }()
+
+	filters, err := s.filters(in.DeviceModelName, in.ProjectId, in.DeviceIds, in.PointCodes)
+	if err != nil {
+		return nil, nil, err
+	}
+	args := []any{"SELECTED_LABELS", redisTimeSeriesLabelDevice, redisTimeSeriesLabelProject, redisTimeSeriesLabelPoint, "FILTER"}
+	args = append(args, filters...)
+	res, err := g.Redis(s.group).Do(ctx, "TS.MGET", args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	series, err := parseTimeSeriesReply(res.Val())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// (device, project) -> pointCode -> value, the same device id may be in several projects,
+	// values older than realTimeWindow are not latest data unless last known values are asked for
+	since := gtime.Now().Add(-1 * s.realTimeWindow).UnixMilli()
+	deviceValues := make(map[timeSeriesDevice]map[string]any)
+	deviceProjects := make(map[string][]string)
+	deviceTimestamps := make(map[timeSeriesDevice]int64) // the newest sample of a device
+	for _, one := range series {
+		if len(one.Samples) == 0 || (one.Samples[0].Timestamp < since && !in.LastKnownValue) {
+			continue
+		}
+		device := one.device()
+		deviceTimestamps[device] = max(deviceTimestamps[device], one.Samples[0].Timestamp)
+		if deviceValues[device] == nil {
+			deviceValues[device] = make(map[string]any)
+			deviceProjects[device.deviceId] = append(deviceProjects[device.deviceId], device.projectId)
+		}
+		deviceValues[device][one.Labels[redisTimeSeriesLabelPoint]] = one.Samples[0].Value
+	}
+	targetDeviceIds := in.DeviceIds
+	if len(targetDeviceIds) == 0 {
+		for deviceId := range deviceProjects {
+			targetDeviceIds = append(targetDeviceIds, deviceId)
+		}
+		sort.Strings(targetDeviceIds)
+	}
+	targetDevices := make([]timeSeriesDevice, 0, len(targetDeviceIds))
+	for _, deviceId := range targetDeviceIds {
+		projectIds := deviceProjects[deviceId]
+		sort.Strings(projectIds)
+		for _, projectId := range projectIds {
+			targetDevices = append(targetDevices, timeSeriesDevice{deviceId: deviceId, projectId: projectId})
+		}
+	}
+
+	pointCodeValueMaps = make([]map[string]any, 0)
+	pointCodes = make([][]string, 0)
+	for _, device := range targetDevices {
+		deviceId := device.deviceId
+		values := deviceValues[device]
+		newMap := make(map[string]any)
+		pointCodesInOneTimestamp := make([]string, 0)
+		isPassedFilter := true // whether equals the value given by the filter data map
+		for _, pointCode := range in.PointCodes {
+			valueNow, ok := values[pointCode]
+			if filterValue, isFiltered := dataFilterMap[pointCode]; isFiltered && gconv.Float64(valueNow) != filterValue {
+				isPassedFilter = false
+				break
+			}
+			if ok {
+				newMap[pointCode] = valueNow
+				pointCodesInOneTimestamp = append(pointCodesInOneTimestamp, pointCode)
+			}
+		}
+		if isPassedFilter && len(newMap) > 0 {
+			newMap[tdengineColumnAliasDevice] = deviceId
+			if in.HaveProjectIdInResult {
+				newMap[tdengineColumnAliasProject] = device.projectId
+			}
+			if in.HaveDeviceModelNameInResult {
+				newMap[tdengineTableNameKey] = in.DeviceModelName
+			}
+			if in.LastKnownValue {
+				newMap[tdengineColumnTimestamp] = deviceTimestamps[device]
+				newMap[resultKeyIsStale] = isStale(deviceTimestamps[device], s.realTimeWindow)
+			}
+			pointCodeValueMaps = append(pointCodeValueMaps, newMap)
+			pointCodes = append(pointCodes, pointCodesInOneTimestamp)
+		}
+	}
+	return pointCodeValueMaps, pointCodes, nil
+}
+
+func (s *redisTimeSeries) ReadToSeries(
+	ctx context.Context,
+	in ReadDeviceSeriesDataInput,
+) (seriesData [][]any, timestamps []int64, err error) {
+	results, err := s.ReadSeries(ctx, in)
+	if err != nil {
+		return nil, nil, err
+	}
+	seriesData, timestamps = SeriesResultsToLegacy(results)
+	return
+}
+
+func (s *redisTimeSeries) ReadSeries(ctx context.Context, in ReadDeviceSeriesDataInput) (results []*SeriesResult, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeRedisTimeSeries, operationReadSeries, in.DeviceModelName, len(in.PointCodes))
+	defer func() { observer.End(ctx, err) }()
+
+	if err = in.validateDeviceIds(); err != nil {
+		return nil, err
+	}
+	aggregations, err := in.aggregations()
+	if err != nil {
+		return nil, err
+	}
+	duration, err := gtime.ParseDuration(in.Interval)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid interval: %s", in.Interval)
+	}
+	bucketMs := duration.Milliseconds()
+	startTime := gtime.NewFromTimeStamp(in.StartTime).UnixMilli()
+	endTime := gtime.NewFromTimeStamp(in.EndTime).UnixMilli()
+
+	// one range query per aggregation, since a query has only one aggregation
+	pointsByAggregation := make(map[string][]string)
+	aggregationNames := make([]string, 0)
+	for i, pointCode := range in.PointCodes {
+		name, innErr := timeSeriesAggregation(aggregations[i])
+		if innErr != nil {
+			return nil, innErr
+		}
+		if _, ok := pointsByAggregation[name]; !ok {
+			aggregationNames = append(aggregationNames, name)
+		}
+		pointsByAggregation[name] = append(pointsByAggregation[name], pointCode)
+	}
+
+	allSeries := make([]*timeSeriesReply, 0)
+	for _, name := range aggregationNames {
+		filters, innErr := s.filters(in.DeviceModelName, in.ProjectId, in.DeviceIds, pointsByAggregation[name])
+		if innErr != nil {
+			return nil, innErr
+		}
+		// buckets are aligned to the multiples of interval as tdengine does
+		args := []any{
+			startTime - startTime%bucketMs, endTime,
+			"SELECTED_LABELS", redisTimeSeriesLabelDevice, redisTimeSeriesLabelProject, redisTimeSeriesLabelPoint,
+			"ALIGN", 0, "AGGREGATION", name, bucketMs,
+			"FILTER",
+		}
+		args = append(args, filters...)
+		res, innErr := g.Redis(s.group).Do(ctx, "TS.MRANGE", args...)
+		if innErr != nil {
+			return nil, innErr
+		}
+		series, innErr := parseTimeSeriesReply(res.Val())
+		if innErr != nil {
+			return nil, innErr
+		}
+		allSeries = append(allSeries, series...)
+	}
+
+	// a device id in several projects is read in ProjectId, or in the project of its newest sample
+	deviceProjects := lastSampleProjects(allSeries)
+	allDeviceData := make(map[string]map[string][]*RedisDataPoint)
+	for _, one := range allSeries {
+		device := one.device()
+		deviceId := device.deviceId
+		if device.projectId != deviceProjects[deviceId] {
+			continue
+		}
+		if allDeviceData[deviceId] == nil {
+			allDeviceData[deviceId] = make(map[string][]*RedisDataPoint)
+		}
+		dataPoints := make([]*RedisDataPoint, 0, len(one.Samples))
+		for _, sample := range one.Samples {
+			dataPoints = append(dataPoints, &RedisDataPoint{
+				Value:     sample.Value,
+				Timestamp: gtime.NewFromTimeStamp(sample.Timestamp),
+			})
+		}
+		allDeviceData[deviceId][one.Labels[redisTimeSeriesLabelPoint]] = dataPoints
+	}
+
+	// each window has one aggregated sample at most
+	lastAggregations := make([]Aggregation, len(in.PointCodes))
+	for i := range lastAggregations {
+		lastAggregations[i] = Aggregation{Function: aggregationLast}
+	}
+	results, err = ApplyTimeWindowAndFill(
+		allDeviceData,
+		in.DeviceModelName,
+		in.StartTime,
+		in.EndTime,
+		in.Interval,
+		in.FillOption,
+		in.DeviceIds,
+		in.PointCodes,
+		lastAggregations,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		result.ProjectId = deviceProjects[result.DeviceId]
+	}
+	return results, nil
+}
+
+func (s *redisTimeSeries) CreateSTable(ctx context.Context, stableName string, columns []TdengineColumn) error {
+	panic("this is only for tdengine, redis timeseries does not have stables")
+}
+
+func (s *redisTimeSeries) labels(deviceModelName string, deviceId string, projectId string, pointCode string) []any {
+	labels := []any{
+		redisTimeSeriesLabelModel, deviceModelName,
+		redisTimeSeriesLabelDevice, deviceId,
+		redisTimeSeriesLabelPoint, pointCode,
+	}
+	// a label cannot be empty
+	if projectId != "" {
+		labels = append(labels, redisTimeSeriesLabelProject, projectId)
+	}
+	if s.keys.prefix != "" {
+		labels = append(labels, redisTimeSeriesLabelPrefix, s.keys.prefix)
+	}
+	return labels
+}
+
+func (s *redisTimeSeries) filters(deviceModelName string, projectId string, deviceIds []string, pointCodes []string) ([]any, error) {
+	filters := []any{fmt.Sprintf("%s=%s", redisTimeSeriesLabelModel, deviceModelName)}
+	if s.keys.prefix != "" {
+		filters = append(filters, fmt.Sprintf("%s=%s", redisTimeSeriesLabelPrefix, s.keys.prefix))
+	}
+	if projectId != "" {
+		filters = append(filters, fmt.Sprintf("%s=%s", redisTimeSeriesLabelProject, projectId))
+	}
+	for _, labelFilter := range []struct {
+		label  string
+		values []string
+	}{
+		{label: redisTimeSeriesLabelDevice, values: deviceIds},
+		{label: redisTimeSeriesLabelPoint, values: pointCodes},
+	} {
+		label, values := labelFilter.label, labelFilter.values
+		if len(values) == 0 {
+			continue
+		}
+		for _, value := range values {
+			if strings.ContainsAny(value, ",()= ") {
+				return nil, fmt.Errorf("invalid %s for redis timeseries filters: %q", label, value)
+			}
+		}
+		filters = append(filters, fmt.Sprintf("%s=(%s)", label, strings.Join(values, ",")))
+	}
+	return filters, nil
+}
+
+// timeSeriesValue converts the value to a double, ok is false if it is not a number or bool
+func timeSeriesValue(value any) (float64, bool) {
+	if v, ok := value.(bool); ok {
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	if !isNumericValue(value) {
+		return 0, false
+	}
+	return gconv.Float64(value), true
+}
+
+func timeSeriesAggregation(aggregation Aggregation) (string, error) {
+	switch aggregation.Function {
+	case aggregationAvg, aggregationMin, aggregationMax, aggregationSum, aggregationCount,
+		aggregationFirst, aggregationLast, aggregationTwa:
+		return aggregation.Function, nil
+	case aggregationSpread:
+		return "range", nil
+	case aggregationStddev:
+		return "std.p", nil
+	}
+	return "", fmt.Errorf("aggregation %s is not supported by redis timeseries", aggregation)
+}
+
+type timeSeriesSample struct {
+	Timestamp int64
+	Value     float64
+}
+
+type timeSeriesReply struct {
+	Key     string
+	Labels  map[string]string
+	Samples []timeSeriesSample
+}
+
+type timeSeriesDevice struct {
+	deviceId  string
+	projectId string
+}
+
+func (r *timeSeriesReply) device() timeSeriesDevice {
+	return timeSeriesDevice{deviceId: r.Labels[redisTimeSeriesLabelDevice], projectId: r.Labels[redisTimeSeriesLabelProject]}
+}
+
+// lastSampleProjects returns deviceId -> the project of its newest sample, samples are in ascending order of time
+func lastSampleProjects(series []*timeSeriesReply) map[string]string {
+	deviceProjects := make(map[string]string)
+	lastTimestamps := make(map[string]int64)
+	for _, one := range series {
+		device := one.device()
+		lastTimestamp := int64(-1)
+		if len(one.Samples) > 0 {
+			lastTimestamp = one.Samples[len(one.Samples)-1].Timestamp
+		}
+		current, ok := lastTimestamps[device.deviceId]
+		if !ok || lastTimestamp > current || (lastTimestamp == current && device.projectId < deviceProjects[device.deviceId]) {
+			lastTimestamps[device.deviceId] = lastTimestamp
+			deviceProjects[device.deviceId] = device.projectId
+		}
+	}
+	return deviceProjects
+}
+
+// parseTimeSeriesReply decodes the reply of TS.MGET and TS.MRANGE: [[key, [[label, value]...], samples]...],
+// samples is [timestamp, value] for TS.MGET, and [[timestamp, value]...] for TS.MRANGE
+func parseTimeSeriesReply(reply any) ([]*timeSeriesReply, error) {
+	out := make([]*timeSeriesReply, 0)
+	if reply == nil {
+		return out, nil
+	}
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected redis timeseries reply: %T", reply)
+	}
+	for _, item := range items {
+		parts, ok := item.([]any)
+		if !ok || len(parts) != 3 {
+			return nil, fmt.Errorf("unexpected redis timeseries reply entry: %v", item)
+		}
+		one := &timeSeriesReply{Key: gconv.String(parts[0]), Labels: make(map[string]string)}
+		labels, _ := parts[1].([]any)
+		for _, label := range labels {
+			pair, ok := label.([]any)
+			if !ok || len(pair) != 2 {
+				continue
+			}
+			one.Labels[gconv.String(pair[0])] = gconv.String(pair[1])
+		}
+		samples, _ := parts[2].([]any)
+		if len(samples) == 2 {
+			if _, isSample := samples[0].([]any); !isSample {
+				// a single sample of TS.MGET
+				samples = []any{samples}
+			}
+		}
+		for _, sample := range samples {
+			pair, ok := sample.([]any)
+			if !ok || len(pair) != 2 {
+				return nil, fmt.Errorf("unexpected redis timeseries sample of %s: %v", one.Key, sample)
+			}
+			one.Samples = append(one.Samples, timeSeriesSample{
+				Timestamp: gconv.Int64(pair[0]),
+				Value:     gconv.Float64(pair[1]),
+			})
+		}
+		out = append(out, one)
+	}
+	return out, nil
+}
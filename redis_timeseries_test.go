@@ -0,0 +1,58 @@
+package tsdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedisKeyBuilderTimeSeries(t *testing.T) {
+	cases := []struct {
+		name string
+		keys redisKeyBuilder
+		want string
+	}{
+		{name: "version 1", keys: redisKeyBuilder{version: redisKeyVersion1}, want: "ts:p1:m1:d1:t"},
+		{name: "version 2", keys: redisKeyBuilder{version: redisKeyVersion2}, want: "v2:p1:m1:d1:ts:t"},
+		{name: "prefix", keys: redisKeyBuilder{prefix: "app", version: redisKeyVersion2}, want: "app:v2:p1:m1:d1:ts:t"},
+		{name: "hash tag", keys: redisKeyBuilder{version: redisKeyVersion2, hashTag: true}, want: "v2:{p1:m1:d1}:ts:t"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.keys.TimeSeries("m1", "p1", "d1", "t"); got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+	keys := redisKeyBuilder{version: redisKeyVersion2}
+	if keys.TimeSeries("m1", "p1", "d1", "t") == keys.TimeSeries("m1", "p2", "d1", "t") {
+		t.Fatal("a device id in two projects has the same key")
+	}
+}
+
+func TestLastSampleProjects(t *testing.T) {
+	newReply := func(deviceId string, projectId string, timestamps ...int64) *timeSeriesReply {
+		samples := make([]timeSeriesSample, 0, len(timestamps))
+		for _, timestamp := range timestamps {
+			samples = append(samples, timeSeriesSample{Timestamp: timestamp, Value: 1.0})
+		}
+		return &timeSeriesReply{
+			Labels: map[string]string{
+				redisTimeSeriesLabelDevice:  deviceId,
+				redisTimeSeriesLabelProject: projectId,
+			},
+			Samples: samples,
+		}
+	}
+	series := []*timeSeriesReply{
+		newReply("d1", "p1", 10, 20),
+		newReply("d1", "p2", 10, 30),
+		newReply("d2", "p2", 10),
+		newReply("d2", "p1", 10), // the same time, ordered by project
+		newReply("d3", "p1"),
+		newReply("d4", ""),
+	}
+	want := map[string]string{"d1": "p2", "d2": "p1", "d3": "p1", "d4": ""}
+	if got := lastSampleProjects(series); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
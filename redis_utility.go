@@ -3,200 +3,371 @@ package tsdb
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
-	"github.com/gogf/gf/v2/container/garray"
 	"github.com/gogf/gf/v2/database/gredis"
-	"github.com/gogf/gf/v2/encoding/gjson"
-	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 )
 
-func ParseStreamResult(input string) *RedisDataPoint {
+// redisStreamEntry is an entry of a stream reply, fields are field, value, field, value...
+type redisStreamEntry struct {
+	Timestamp *gtime.Time
+	Fields    []string
+}
+
+// parseStreamReply decodes the reply of XRANGE from its RESP structure: [[id, [field, value, ...]], ...],
+// malformed entries are skipped and counted
+func parseStreamReply(reply any) (entries []redisStreamEntry, malformed int) {
+	if reply == nil {
+		return nil, 0
+	}
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, 1
+	}
+	entries = make([]redisStreamEntry, 0, len(items))
+	for _, item := range items {
+		entry, ok := parseStreamReplyEntry(item)
+		if !ok {
+			malformed++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, malformed
+}
+
+func parseStreamReplyEntry(item any) (redisStreamEntry, bool) {
+	parts, ok := item.([]any)
+	if !ok || len(parts) != 2 {
+		return redisStreamEntry{}, false
+	}
+	id, ok := redisReplyString(parts[0])
+	if !ok {
+		return redisStreamEntry{}, false
+	}
+	timestamp, _, _ := strings.Cut(id, "-")
+	timestampMilli, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return redisStreamEntry{}, false
+	}
+	rawFields, ok := parts[1].([]any)
+	if !ok || len(rawFields)%2 != 0 {
+		return redisStreamEntry{}, false
+	}
+	fields := make([]string, 0, len(rawFields))
+	for _, rawField := range rawFields {
+		field, ok := redisReplyString(rawField)
+		if !ok {
+			return redisStreamEntry{}, false
+		}
+		fields = append(fields, field)
+	}
+	return redisStreamEntry{Timestamp: gtime.NewFromTimeStamp(timestampMilli), Fields: fields}, true
+}
+
+func redisReplyString(v any) (string, bool) {
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case []byte:
+		return string(value), true
+	}
+	return "", false
+}
+
+func ParseZSetMember(input string) *RedisDataPoint {
 	/*
-		data format: ["1762828300498-0",["value","20"]]
+		data format: 1762828300498:f:20
 	*/
-	var decoded []any
-	var valuePart []string
-	jsonData, err := gjson.DecodeToJson(input)
-	if err != nil {
+	timestamp, encodedValue, found := strings.Cut(input, ":")
+	if !found {
 		return nil
 	}
-	err = jsonData.Scan(&decoded)
+	timestampMilli, err := strconv.ParseInt(timestamp, 10, 64)
 	if err != nil {
 		return nil
 	}
-	if len(decoded) != 2 {
-		return nil
+	return &RedisDataPoint{
+		Value:     DecodeRedisValue(encodedValue),
+		Timestamp: gtime.NewFromTimeStamp(timestampMilli),
 	}
-	err = gconv.Scan(decoded[1], &valuePart)
-	if err != nil {
-		return nil
+}
+
+// EncodeRedisValue prefixes the value with its type, so that it can be decoded to the same type
+func EncodeRedisValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return redisValueTypeNil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return redisValueTypeInt + gconv.String(v)
+	case float32:
+		return redisValueTypeFloat + strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return redisValueTypeFloat + strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return redisValueTypeBool + strconv.FormatBool(v)
+	default:
+		return redisValueTypeString + gconv.String(v)
 	}
-	if len(valuePart) != 2 {
+}
+
+// DecodeRedisValue decodes a value of EncodeRedisValue, integers beyond int64 are decoded to uint64,
+// a malformed value of a type is returned as it is, like untyped strings
+func DecodeRedisValue(encoded string) any {
+	switch {
+	case encoded == redisValueTypeNil:
 		return nil
+	case strings.HasPrefix(encoded, redisValueTypeInt):
+		if v, err := strconv.ParseInt(encoded[len(redisValueTypeInt):], 10, 64); err == nil {
+			return v
+		}
+		if v, err := strconv.ParseUint(encoded[len(redisValueTypeInt):], 10, 64); err == nil {
+			return v
+		}
+		return encoded
+	case strings.HasPrefix(encoded, redisValueTypeFloat):
+		if v, err := strconv.ParseFloat(encoded[len(redisValueTypeFloat):], 64); err == nil {
+			return v
+		}
+		return encoded
+	case strings.HasPrefix(encoded, redisValueTypeBool):
+		if v, err := strconv.ParseBool(encoded[len(redisValueTypeBool):]); err == nil {
+			return v
+		}
+		return encoded
+	case strings.HasPrefix(encoded, redisValueTypeString):
+		return encoded[len(redisValueTypeString):]
 	}
-	timestamp := strings.Split(gconv.String(decoded[0]), "-")
-	if len(timestamp) != 2 {
-		return nil
+	// data written before values were typed
+	if v, err := strconv.ParseInt(encoded, 10, 64); err == nil {
+		return v
 	}
-	return &RedisDataPoint{
-		Value:     gconv.Int64(valuePart[1]),
-		Timestamp: gtime.NewFromTimeStamp(gconv.Int64(timestamp[0])),
-		IsFilled:  false,
+	if v, err := strconv.ParseFloat(encoded, 64); err == nil {
+		return v
 	}
+	return encoded
 }
 
 func ApplyTimeWindowAndFill(
 	allDeviceData map[string]map[string][]*RedisDataPoint,
-	totalPointsCount int,
 	deviceModelName string,
-	start int64, // unix time, seconds
-	end int64,   // unix time, seconds
+	start int64, // unix time
+	end int64, // unix time
 	interval string,
 	fillType string,
-) (seriesData [][]any, timestamps []int64, err error) {
-	// seriesData [][]any, timestamps []int64
-	timestampsAny := garray.NewArray()
-	seriesData = make([][]any, 0)
-	seriesDataMap := make(map[string]*garray.Array)
-	// used internally for accelerating looping
-	searchIndexMap := make(map[string]map[string]int)
-	// used for fill NONE
-	noValueCountsArray := garray.NewIntArray()
-
-	duration, err := gtime.ParseDuration(interval)
-	if err != nil {
-		return nil, nil, fmt.Errorf("invalid interval: %s", interval)
-	}
-
-	startTime := gtime.NewFromTimeStamp(start)
-	endTime := gtime.NewFromTimeStamp(end)
-	currentWindowStart := startTime
-	for currentWindowStart.Before(endTime) || currentWindowStart.Equal(endTime) {
-		currentWindowEnd := currentWindowStart.Add(duration)
-
-		timestampsAny.Append(currentWindowEnd.UnixMilli())
-		noValueCounts := 0
+	deviceIds []string,
+	pointCodes []string,
+	aggregations []Aggregation, // aligned with pointCodes
+) ([]*SeriesResult, error) {
+	return applyTimeWindowAndFill(allDeviceData, deviceModelName, start, end, interval, fillType, deviceIds, pointCodes, aggregations, true)
+}
 
-		// handle device data
-		for deviceId, deviceData := range allDeviceData {
-			if searchIndexMap[deviceId] == nil {
-				searchIndexMap[deviceId] = make(map[string]int)
+// applyTimeWindowAndFill windows raw data, windows start at start if alignWindows is false, as ReadToSeries of redis did
+func applyTimeWindowAndFill(
+	allDeviceData map[string]map[string][]*RedisDataPoint,
+	deviceModelName string,
+	start int64, // unix time
+	end int64, // unix time
+	interval string,
+	fillType string,
+	deviceIds []string,
+	pointCodes []string,
+	aggregations []Aggregation, // aligned with pointCodes
+	alignWindows bool,
+) ([]*SeriesResult, error) {
+	return applyWindowsAndFill(
+		deviceModelName,
+		start,
+		end,
+		interval,
+		fillType,
+		deviceIds,
+		pointCodes,
+		alignWindows,
+		func(deviceId string, pointIdx int, windowStarts []int64, durationMs int64) []any {
+			aggregation := Aggregation{Function: aggregationDefault}
+			if pointIdx < len(aggregations) {
+				aggregation = aggregations[pointIdx]
 			}
-			for pointCode, pointValues := range deviceData {
-				mapKey := fmt.Sprintf("%s:%s_%s", deviceModelName, deviceId, pointCode)
-				if _, ok := seriesDataMap[mapKey]; !ok {
-					seriesDataMap[mapKey] = garray.NewArray()
-				}
-				currentIdx := searchIndexMap[deviceId][pointCode] // if pointCode not in indexMap[deviceId], we got 0
-				// to find a proper value in this window
-				windowValue, newIdx := findValueWithIndex(pointValues, currentIdx, currentWindowStart, currentWindowEnd)
-				// By default, fill null if not find a proper value
-				// to fill none, fill null first and then delete the all null time
-				switch fillType {
-				case fillNull:
-					// we cannot directly use seriesDataMap[mapKey].Append(windowValue)
-					// since windowValue is nil(*int64) which is different from nil during json marshal
-					if windowValue == nil {
-						seriesDataMap[mapKey].Append(nil)
-					} else {
-						seriesDataMap[mapKey].Append(windowValue)
-					}
-				default:
-					seriesDataMap[mapKey].Append(windowValue)
-				}
-				if windowValue == nil {
-					noValueCounts++
-				}
+			pointValues := allDeviceData[deviceId][pointCodes[pointIdx]]
+			values := make([]any, 0, len(windowStarts))
+			searchIdx := 0 // used internally for accelerating looping
+			for _, windowStart := range windowStarts {
+				// nil if not find a proper value, empty windows will be filled later
+				windowValue, newIdx := findValueWithIndex(
+					pointValues,
+					searchIdx,
+					gtime.NewFromTimeStamp(windowStart),
+					gtime.NewFromTimeStamp(windowStart+durationMs),
+					aggregation,
+				)
+				values = append(values, windowValue)
 				// next window, we will search from the newIdx
-				searchIndexMap[deviceId][pointCode] = newIdx
+				searchIdx = newIdx
 			}
-		}
-		noValueCountsArray.Append(noValueCounts)
+			return values
+		},
+	)
+}
 
-		if !currentWindowEnd.Before(endTime) {
-			break
-		} else {
-			currentWindowStart = currentWindowEnd // for next loop
+// windowValuesFunc returns the value of each window of a device point, nil for empty windows
+type windowValuesFunc func(deviceId string, pointIdx int, windowStarts []int64, durationMs int64) []any
+
+func applyWindowsAndFill(
+	deviceModelName string,
+	start int64, // unix time
+	end int64, // unix time
+	interval string,
+	fillType string,
+	deviceIds []string,
+	pointCodes []string,
+	alignWindows bool,
+	windowValues windowValuesFunc,
+) ([]*SeriesResult, error) {
+	duration, err := gtime.ParseDuration(interval)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid interval: %s", interval)
+	}
+	durationMs := duration.Milliseconds()
+
+	startMs := gtime.NewFromTimeStamp(start).UnixMilli()
+	endMs := gtime.NewFromTimeStamp(end).UnixMilli()
+	windowStarts := make([]int64, 0)
+	if alignWindows {
+		// windows are aligned to the multiples of interval as tdengine does
+		for windowStart := startMs - startMs%durationMs; windowStart <= endMs; windowStart += durationMs {
+			windowStarts = append(windowStarts, windowStart)
+		}
+	} else {
+		// windows start at start, the last one is the first ending at or after end
+		for windowStart := startMs; windowStart <= endMs; windowStart += durationMs {
+			windowStarts = append(windowStarts, windowStart)
+			if windowStart+durationMs >= endMs {
+				break
+			}
 		}
 	}
-	// find all timestamps that null count == totalPointsCount
-	allNullIndex := findAllNullIndex(noValueCountsArray, totalPointsCount)
-	// remove timestamps that all data are null
-	if fillType == fillNone {
-		removeItemByIndex(timestampsAny, allNullIndex)
-	}
-	// format series data
-	for _, mapItem := range seriesDataMap {
-		// remove timestamps that all data are null
-		if fillType == fillNone {
-			removeItemByIndex(mapItem, allNullIndex)
+
+	// series are ordered by deviceIds first and then by point codes
+	seriesData := make([][]any, 0, len(deviceIds)*len(pointCodes))
+	pointIndexes := make([]int, 0, len(deviceIds)*len(pointCodes))
+	for _, deviceId := range deviceIds {
+		for pointIdx := range pointCodes {
+			seriesData = append(seriesData, windowValues(deviceId, pointIdx, windowStarts, durationMs))
+			pointIndexes = append(pointIndexes, pointIdx)
 		}
-		seriesData = append(seriesData, mapItem.Slice())
 	}
 
-	return seriesData, gconv.Int64s(timestampsAny), nil
+	seriesData, windowStarts, err = ApplyFill(seriesData, windowStarts, fillType, len(pointCodes))
+	if err != nil {
+		return nil, err
+	}
+	windowEnds := make([]int64, 0, len(windowStarts))
+	for _, windowStart := range windowStarts {
+		windowEnds = append(windowEnds, windowStart+durationMs)
+	}
+	results := make([]*SeriesResult, 0, len(seriesData))
+	for i, values := range seriesData {
+		results = append(results, &SeriesResult{
+			DeviceModelName: deviceModelName,
+			DeviceId:        deviceIds[i/len(pointCodes)],
+			PointCode:       pointCodes[pointIndexes[i]],
+			WindowStarts:    windowStarts,
+			WindowEnds:      windowEnds,
+			Values:          values,
+		})
+	}
+	return results, nil
 }
 
-func findValueWithIndex(pointValues []*RedisDataPoint, startIdx int, start *gtime.Time, end *gtime.Time) (*int64, int) {
+func findValueWithIndex(
+	pointValues []*RedisDataPoint,
+	startIdx int,
+	start *gtime.Time,
+	end *gtime.Time,
+	aggregation Aggregation,
+) (any, int) {
 	// optimized, will search from the index recorded before to prevent duplicated search
-	var lastValue *int64
-
-	for i := startIdx; i < len(pointValues); i++ {
+	windowStartIdx := startIdx
+	for windowStartIdx < len(pointValues) && pointValues[windowStartIdx].Timestamp.Before(start) {
 		// before start, continue
-		if pointValues[i].Timestamp.Before(start) {
-			continue
-		}
-		// >= end return
-		if !pointValues[i].Timestamp.Before(end) {
-			// "i" here indicates the next index of lastValue
-			// so no duplicated search
-			return lastValue, i
-		}
+		windowStartIdx++
+	}
+	windowEndIdx := windowStartIdx
+	for windowEndIdx < len(pointValues) && pointValues[windowEndIdx].Timestamp.Before(end) {
 		// in window
-		value := pointValues[i].Value
-		lastValue = &value
+		windowEndIdx++
+	}
+	var prev, next *RedisDataPoint
+	if windowStartIdx > 0 {
+		prev = pointValues[windowStartIdx-1]
+	}
+	if windowEndIdx < len(pointValues) {
+		next = pointValues[windowEndIdx]
 	}
-	// when looping the array, find nothing
-	// return the length of array
-	// and next time, it will still return nil(*int64) for lastValue
-	// caution: nil(*int64) is different from nil during json marshal
-	return lastValue, len(pointValues)
+	// "windowEndIdx" here indicates the first index of next window, so no duplicated search
+	// caution: a nil value is returned if find nothing in this window
+	return aggregation.Apply(pointValues[windowStartIdx:windowEndIdx], prev, next, start, end), windowEndIdx
 }
 
-func findAllNullIndex(dataArray *garray.IntArray, count int) *garray.IntArray {
-	allNullIndex := garray.NewIntArray()
-	dataArray.Iterator(func(k int, v int) bool {
-		if v == count {
-			allNullIndex.Append(k)
+// checkRedisVersion returns an error if the redis server is older than the commands used require
+func checkRedisVersion(ctx context.Context, client *gredis.Redis) error {
+	res, err := client.Do(ctx, "INFO", "server")
+	if err != nil {
+		return err
+	}
+	version := parseRedisVersion(res.String())
+	major, minor, ok := splitRedisVersion(version)
+	if !ok {
+		return fmt.Errorf("unknown redis version: %q", version)
+	}
+	if major < redisMinMajorVersion || (major == redisMinMajorVersion && minor < redisMinMinorVersion) {
+		return fmt.Errorf("redis %d.%d or later is required, got %s", redisMinMajorVersion, redisMinMinorVersion, version)
+	}
+	return nil
+}
+
+// parseRedisVersion returns redis_version of the reply of INFO
+func parseRedisVersion(info string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if version, ok := strings.CutPrefix(strings.TrimSpace(line), "redis_version:"); ok {
+			return version
 		}
-		return true
-	})
-	return allNullIndex
+	}
+	return ""
 }
 
-func removeItemByIndex(data *garray.Array, idxToRemove *garray.IntArray) *garray.Array {
-	if idxToRemove == nil || idxToRemove.Len() == 0 {
-		return data
+func splitRedisVersion(version string) (major int, minor int, ok bool) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return 0, 0, false
 	}
-	data.IteratorDesc(func(k int, v any) bool {
-		if idxToRemove.Contains(k) {
-			data.Remove(k)
-		}
-		return true
-	})
-	return data
+	major, majorErr := strconv.Atoi(parts[0])
+	minor, minorErr := strconv.Atoi(parts[1])
+	return major, minor, majorErr == nil && minorErr == nil
+}
+
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// redisGlob returns a SCAN pattern matching key literally, except that each redisGlobWildcard in it matches any string
+func redisGlob(key string) string {
+	parts := strings.Split(key, redisGlobWildcard)
+	for i, part := range parts {
+		parts[i] = redisGlobEscaper.Replace(part)
+	}
+	return strings.Join(parts, "*")
 }
 
-func useRedisScan(ctx context.Context, scanOption gredis.ScanOption) ([]string, error) {
+func useRedisScan(ctx context.Context, client *gredis.Redis, scanOption gredis.ScanOption) ([]string, error) {
 	out := make([]string, 0)
 	var cursor uint64
 	var keys []string
 	var err error
 	for {
-		cursor, keys, err = g.Redis().Scan(ctx, cursor, scanOption)
+		cursor, keys, err = client.Scan(ctx, cursor, scanOption)
 		if err != nil {
 			break
 		}
@@ -0,0 +1,205 @@
+package tsdb
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/gogf/gf/v2/os/gtime"
+)
+
+func TestApplyTimeWindowAndFillWindows(t *testing.T) {
+	const base int64 = 1_700_000_040_000 // a multiple of 1m, milliseconds
+	allDeviceData := map[string]map[string][]*RedisDataPoint{
+		"d1": {"p1": {
+			{Value: int64(1), Timestamp: gtime.NewFromTimeStamp(base + 35_000)},
+			{Value: int64(2), Timestamp: gtime.NewFromTimeStamp(base + 65_000)},
+		}},
+	}
+	lastAggregations := []Aggregation{{Function: aggregationLast}}
+	cases := []struct {
+		name             string
+		alignWindows     bool
+		wantWindowStarts []int64
+		wantWindowEnds   []int64
+		wantValues       []any
+	}{
+		{
+			name:             "aligned to the multiples of interval",
+			alignWindows:     true,
+			wantWindowStarts: []int64{base, base + 60_000},
+			wantWindowEnds:   []int64{base + 60_000, base + 120_000},
+			wantValues:       []any{int64(1), int64(2)},
+		},
+		{
+			name:             "starting at start",
+			alignWindows:     false,
+			wantWindowStarts: []int64{base + 30_000, base + 90_000},
+			wantWindowEnds:   []int64{base + 90_000, base + 150_000},
+			wantValues:       []any{int64(2), nil},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			results, err := applyTimeWindowAndFill(
+				allDeviceData, "m", base+30_000, base+110_000, "1m", fillNull, []string{"d1"}, []string{"p1"}, lastAggregations, c.alignWindows,
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			result := results[0]
+			if !reflect.DeepEqual(result.WindowStarts, c.wantWindowStarts) ||
+				!reflect.DeepEqual(result.WindowEnds, c.wantWindowEnds) ||
+				!reflect.DeepEqual(result.Values, c.wantValues) {
+				t.Fatalf("got %v %v %v, want %v %v %v",
+					result.WindowStarts, result.WindowEnds, result.Values,
+					c.wantWindowStarts, c.wantWindowEnds, c.wantValues)
+			}
+		})
+	}
+}
+
+func TestRedisGlob(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "m1:d1_latest", want: "m1:d1_latest"},
+		{name: "wildcard", in: "m1:" + redisGlobWildcard + "_latest", want: "m1:*_latest"},
+		{name: "glob chars are literal", in: "m*:d?[1]\\" + redisGlobWildcard, want: `m\*:d\?\[1\]\\*`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := redisGlob(c.in); got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseRedisVersion(t *testing.T) {
+	cases := []struct {
+		name      string
+		info      string
+		wantMajor int
+		wantMinor int
+		wantOk    bool
+	}{
+		{name: "7.2", info: "# Server\r\nredis_version:7.2.4\r\nredis_git_sha1:00000000\r\n", wantMajor: 7, wantMinor: 2, wantOk: true},
+		{name: "6.0", info: "redis_version:6.0.16\n", wantMajor: 6, wantMinor: 0, wantOk: true},
+		{name: "missing", info: "# Server\r\n", wantOk: false},
+		{name: "malformed", info: "redis_version:seven\r\n", wantOk: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			major, minor, ok := splitRedisVersion(parseRedisVersion(c.info))
+			if ok != c.wantOk || (ok && (major != c.wantMajor || minor != c.wantMinor)) {
+				t.Fatalf("got %d.%d %v, want %d.%d %v", major, minor, ok, c.wantMajor, c.wantMinor, c.wantOk)
+			}
+		})
+	}
+}
+
+func TestParseStreamReply(t *testing.T) {
+	reply := []any{
+		[]any{"1700000000000-0", []any{"value", "f1.5"}},
+		[]any{[]byte("1700000001000-1"), []any{[]byte("p1"), []byte("i2"), "p2", "b1"}},
+		[]any{"1700000002000-0"},                      // no fields
+		[]any{"x-0", []any{"value", "f1"}},            // invalid id
+		[]any{"1700000003000-0", []any{"value"}},      // odd fields
+		[]any{"1700000004000-0", []any{"value", 1.5}}, // not a string
+	}
+	entries, malformed := parseStreamReply(reply)
+	if malformed != 4 {
+		t.Fatalf("got %d malformed entries, want 4", malformed)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[1].Timestamp.UnixMilli() != 1700000001000 || !reflect.DeepEqual(entries[1].Fields, []string{"p1", "i2", "p2", "b1"}) {
+		t.Fatalf("unexpected entry: %+v", entries[1])
+	}
+	if _, malformed = parseStreamReply("not an array"); malformed != 1 {
+		t.Fatalf("got %d malformed replies, want 1", malformed)
+	}
+}
+
+func TestRedisMalformedCounts(t *testing.T) {
+	malformed := make(redisMalformedCounts)
+	malformed.add("d1", "p1", 2)
+	malformed.add("d1", "p1", 1)
+	malformed.add("d1", "p2", 0)
+	want := redisMalformedCounts{"d1": {"p1": 3}}
+	if !reflect.DeepEqual(malformed, want) {
+		t.Fatalf("got %v, want %v", malformed, want)
+	}
+	// counting is optional
+	var ignored redisMalformedCounts
+	ignored.add("d1", "p1", 1)
+}
+
+func TestRedisValueRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   any
+		encoded string
+		want    any
+	}{
+		{name: "int", value: 42, encoded: "i:42", want: int64(42)},
+		{name: "int8", value: int8(-8), encoded: "i:-8", want: int64(-8)},
+		{name: "uint16", value: uint16(16), encoded: "i:16", want: int64(16)},
+		{name: "max int64", value: int64(math.MaxInt64), encoded: "i:9223372036854775807", want: int64(math.MaxInt64)},
+		{name: "max uint64", value: uint64(math.MaxUint64), encoded: "i:18446744073709551615", want: uint64(math.MaxUint64)},
+		{name: "float32", value: float32(1.5), encoded: "f:1.5", want: 1.5},
+		{name: "float64", value: 0.1, encoded: "f:0.1", want: 0.1},
+		{name: "integral float64", value: 2.0, encoded: "f:2", want: 2.0},
+		{name: "infinity", value: math.Inf(-1), encoded: "f:-Inf", want: math.Inf(-1)},
+		{name: "true", value: true, encoded: "b:true", want: true},
+		{name: "false", value: false, encoded: "b:false", want: false},
+		{name: "string", value: "on", encoded: "s:on", want: "on"},
+		{name: "numeric string", value: "42", encoded: "s:42", want: "42"},
+		{name: "empty string", value: "", encoded: "s:", want: ""},
+		{name: "string of a prefix", value: "i:1", encoded: "s:i:1", want: "i:1"},
+		{name: "nil", value: nil, encoded: "n:", want: nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			encoded := EncodeRedisValue(c.value)
+			if encoded != c.encoded {
+				t.Fatalf("got encoded %q, want %q", encoded, c.encoded)
+			}
+			if got := DecodeRedisValue(encoded); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got decoded %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRedisValue(t *testing.T) {
+	cases := []struct {
+		name    string
+		encoded string
+		want    any
+	}{
+		{name: "untyped int", encoded: "42", want: int64(42)},
+		{name: "untyped float", encoded: "1.5", want: 1.5},
+		{name: "untyped string", encoded: "on", want: "on"},
+		{name: "empty", encoded: "", want: ""},
+		{name: "malformed int", encoded: "i:4x", want: "i:4x"},
+		{name: "int overflowing uint64", encoded: "i:18446744073709551616", want: "i:18446744073709551616"},
+		{name: "malformed float", encoded: "f:", want: "f:"},
+		{name: "malformed bool", encoded: "b:yes", want: "b:yes"},
+		{name: "unknown type", encoded: "x:1", want: "x:1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := DecodeRedisValue(c.encoded); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/gogf/gf/v2/container/garray"
 	"github.com/gogf/gf/v2/encoding/gjson"
@@ -16,16 +17,25 @@ import (
 )
 
 type tdengine struct {
-	uri            string
-	uriNoDb        string
-	writeUri       string
-	client         *gclient.Client
-	host           string
-	port           int
-	username       string
-	password       string
-	database       string
-	realTimeWindow string
+	uri      string
+	uriNoDb  string
+	writeUri string
+	client   *gclient.Client
+	host     string
+	port     int
+	username string
+	password string
+	database string
+	dataKeep string
+	// KEEP of the database is checked only if DataKeep is configured
+	keepConfigured  bool
+	databaseOptions TdengineDatabaseOptions
+	realTimeWindow  string
+	// realTimeWindowDuration is for the stale flags of last known values
+	realTimeWindowDuration time.Duration
+	redactStatement        bool
+	// drifts of the database options found by the last Init
+	drifts []*TdengineDatabaseDrift
 	sync.Mutex
 }
 
@@ -39,6 +49,36 @@ func (s *tdengine) Init(ctx context.Context, config Config) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
+	if err = s.configure(config); err != nil {
+		return err
+	}
+	s.client.SetBasicAuth(s.username, s.password)
+
+	// the user and the database are created by ProvisionTdengine, Init only applies safe drifts of the options, see syncDatabase
+	dbInfo, err := s.operateDb(ctx, fmt.Sprintf("SHOW CREATE DATABASE `%s`", s.database))
+	if errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrDatabaseNotExist) {
+		return fmt.Errorf("%w, tdengine may not be provisioned, see ProvisionTdengine", err)
+	}
+	if err != nil {
+		return err
+	}
+	// the application user may not be allowed to alter the database, drifts are reported then
+	var innErr error
+	if s.drifts, innErr = s.syncDatabase(ctx, dbInfo); innErr != nil {
+		g.Log().Warningf(ctx, "tdengine database options are not applied: %v", innErr)
+	}
+
+	// try to release client after init
+	s.client.CloseIdleConnections()
+	isHealthy := s.IsHealthy(ctx)
+	if !isHealthy {
+		return fmt.Errorf("we cannot connect to the tdengine server or the server is unhealthy")
+	}
+	return
+}
+
+// configure validates the config and sets up the uris
+func (s *tdengine) configure(config Config) error {
 	if config.Host != "" {
 		s.host = config.Host
 	} else {
@@ -64,63 +104,20 @@ func (s *tdengine) Init(ctx context.Context, config Config) (err error) {
 	} else {
 		return errors.New("database is required")
 	}
-	dataKeep, _ := mustGetDataKeepFromConfig(config, ClientTypeTdengine)
-	s.realTimeWindow, _ = mustGetRealTimeWindowFromConfig(config)
+	s.dataKeep, _ = mustGetDataKeepFromConfig(config, ClientTypeTdengine)
+	// an invalid or too short DataKeep falls back to the minimum, it must not change KEEP of the database
+	s.keepConfigured = config.DataKeep != "" && s.dataKeep == config.DataKeep
+	if err := validateDatabaseOptions(config.TdengineDatabase); err != nil {
+		return err
+	}
+	s.databaseOptions = config.TdengineDatabase
+	s.realTimeWindow, s.realTimeWindowDuration = mustGetRealTimeWindowFromConfig(config)
+	s.redactStatement = config.RedactStatement
 
 	s.uri = fmt.Sprintf("http://%s:%d/rest/sql/%s", s.host, s.port, s.database)
 	s.uriNoDb = fmt.Sprintf("http://%s:%d/rest/sql", s.host, s.port)
 	s.writeUri = fmt.Sprintf("http://%s:%d/influxdb/v1/write?db=%s", s.host, s.port, s.database)
-	// originally try to use new password
-	s.client.SetBasicAuth(s.username, s.password)
-
-	// if no database, create one first
-	dbInfo, err := s.operateDb(ctx, fmt.Sprintf("SHOW CREATE DATABASE `%s`", s.database))
-	if err != nil {
-		return err
-	}
-	if dbInfo.Code == 855 {
-		// 855 means: 0x80000357, Authentication failure
-		// auth fail, it must be the first time to use tdengine
-		// so we use default password to connect
-		// we need to:
-		// 1. change default password
-		// 2. create db
-		s.client.SetBasicAuth(s.username, tdengineDefaultPassword)
-		qs := fmt.Sprintf("ALTER USER root PASS '%s'", s.password)
-		changePasswordRes, innerErr := s.operateDb(ctx, qs)
-		if innerErr != nil || changePasswordRes.Code != 0 {
-			return fmt.Errorf("failed to change password")
-		}
-		g.Log().Info(ctx, "tdengine password has been changed!")
-		// password have changed, so use new password
-		s.client.SetBasicAuth(s.username, s.password)
-		qs = fmt.Sprintf("CREATE DATABASE `%s` BUFFER 48 PAGES 128 DURATION 6h KEEP %s", s.database, dataKeep)
-		createDbRes, innerErr := s.operateDb(ctx, qs)
-		g.Log().Info(ctx, "tdengine database has been created!")
-		if innerErr != nil || (createDbRes.Code != 0 && createDbRes.Code != 897) {
-			// code 897 means: Database already exists
-			return fmt.Errorf("failed to create database")
-		}
-	} else if dbInfo.Code == 904 {
-		// 904 means: 0x80000388, Database not exist
-		// in this case, password is right, but db not exits
-		// we only need to create db
-		qs := fmt.Sprintf("CREATE DATABASE `%s` BUFFER 48 PAGES 128 DURATION 6h KEEP %s", s.database, dataKeep)
-		createDbRes, innerErr := s.operateDb(ctx, qs)
-		g.Log().Info(ctx, "tdengine database has been created!")
-		if innerErr != nil || (createDbRes.Code != 0 && createDbRes.Code != 897) {
-			// code 897 means: Database already exists
-			return fmt.Errorf("failed to create database")
-		}
-	}
-
-	// try to release client after init
-	s.client.CloseIdleConnections()
-	isHealthy := s.IsHealthy(ctx)
-	if !isHealthy {
-		return fmt.Errorf("we cannot connect to the tdengine server or the server is unhealthy")
-	}
-	return
+	return nil
 }
 
 func (s *tdengine) IsHealthy(ctx context.Context) bool {
@@ -136,6 +133,13 @@ func (s *tdengine) IsHealthy(ctx context.Context) bool {
 }
 
 func (s *tdengine) Write(ctx context.Context, metrics []*Metric) (err error) {
+	pointCount := countMetricPoints(metrics)
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationWrite, "", pointCount)
+	defer func() { observer.End(ctx, err) }()
+
+	if err = validateLineProtocolNames(metrics); err != nil {
+		return err
+	}
 	buffer := Serialize(metrics)
 	if buffer.Len() == 0 {
 		return
@@ -146,8 +150,20 @@ func (s *tdengine) Write(ctx context.Context, metrics []*Metric) (err error) {
 		return err
 	}
 	if res.StatusCode >= 400 {
-		g.Log().Error(ctx, res.ReadAllString())
+		// the schemaless endpoint responds with a message instead of desc
+		body := res.ReadAllString()
+		out := &TdengineHttpOutput{Desc: body}
+		if jsonData, innErr := gjson.DecodeToJson(body); innErr == nil {
+			out.Code = jsonData.Get("code").Int()
+			if desc := jsonData.Get("desc").String(); desc != "" {
+				out.Desc = desc
+			} else if message := jsonData.Get("message").String(); message != "" {
+				out.Desc = message
+			}
+		}
+		return s.newError(out, "")
 	}
+	observer.RecordWrite(ctx, pointCount, buffer.Len())
 	return err
 }
 
@@ -156,20 +172,42 @@ func (s *tdengine) ReadToMap(
 	in ReadDeviceLatestDataInput,
 	dataFilterMap map[string]float64,
 ) (pointCodeValueMaps []map[string]any, pointCodes [][]string, err error) {
-	var queryString strings.Builder
-	queryString.WriteString("SELECT ")
-	queryString.WriteString(WrapColumnsWithBackQuote(in.PointCodes, "last", true, true, in.HaveProjectIdInResult))
-	queryString.WriteString(fmt.Sprintf(" FROM `%s` WHERE ", in.DeviceModelName))
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationReadToMap, in.DeviceModelName, len(in.PointCodes))
+	defer func() { observer.End(ctx, err) }()
+
+	// select last(`_ts`) as `_ts`, `device` as `deviceId`, last(`p1`) as `p1` from xxx where xxx partition by `device`, `project`
+	qb := newSqlBuilder().Raw("SELECT ").
+		Aggregate("last", tdengineColumnTimestamp, tdengineColumnTimestamp).Raw(", ").
+		Identifier(tdengineColumnDevice).Raw(" as ").Identifier(tdengineColumnAliasDevice).Raw(", ")
+	if in.HaveProjectIdInResult {
+		qb.Identifier(tdengineColumnProject).Raw(" as ").Identifier(tdengineColumnAliasProject).Raw(", ")
+	}
+	qb.Aggregates("last", in.PointCodes).
+		Raw(" FROM ").Identifier(in.DeviceModelName)
+	conditions := 0
+	where := func() *sqlBuilder {
+		if conditions++; conditions == 1 {
+			return qb.Raw(" WHERE ")
+		}
+		return qb.Raw(" AND ")
+	}
 	if in.ProjectId != "" {
-		queryString.WriteString(fmt.Sprintf("`%s`='%s' AND ", tdengineColumnProject, in.ProjectId))
+		where().Identifier(tdengineColumnProject).Raw("=").Literal(in.ProjectId)
 	}
 	if len(in.DeviceIds) > 0 {
-		queryString.WriteString(fmt.Sprintf("`%s` IN (%s) AND ", tdengineColumnDevice, WrapDevicesWithSingleQuote(in.DeviceIds)))
+		where().Identifier(tdengineColumnDevice).Raw(" IN (").Literals(in.DeviceIds).Raw(")")
+	}
+	if !in.LastKnownValue {
+		// realTimeWindow is validated when init
+		where().Identifier(tdengineColumnTimestamp).Raw(">NOW-").Raw(s.realTimeWindow)
+	}
+	qb.Raw(" PARTITION BY ").Identifiers([]string{tdengineColumnDevice, tdengineColumnProject})
+	qs, err := qb.Build()
+	if err != nil {
+		return nil, nil, err
 	}
-	queryString.WriteString(fmt.Sprintf("`%s`>NOW-%s ", tdengineColumnTimestamp, s.realTimeWindow))
-	queryString.WriteString(fmt.Sprintf("PARTITION BY `%s`, `%s`", tdengineColumnDevice, tdengineColumnProject))
 
-	serializedData, err := s.post(ctx, queryString.String())
+	serializedData, err := s.post(ctx, qs)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -215,6 +253,9 @@ func (s *tdengine) ReadToMap(
 		if in.HaveDeviceModelNameInResult == true {
 			m[tdengineTableNameKey] = in.DeviceModelName
 		}
+		if in.LastKnownValue {
+			m[resultKeyIsStale] = isStale(gconv.Int64(m[tdengineColumnTimestamp]), s.realTimeWindowDuration)
+		}
 		if isPassedFilter {
 			pointCodeValueMaps = append(pointCodeValueMaps, m)
 			pointCodes = append(pointCodes, pointCodesInOneTimestamp)
@@ -227,79 +268,107 @@ func (s *tdengine) ReadToSeries(
 	ctx context.Context,
 	in ReadDeviceSeriesDataInput,
 ) (seriesData [][]any, timestamps []int64, err error) {
+	results, err := s.ReadSeries(ctx, in)
+	if err != nil {
+		return nil, nil, err
+	}
+	seriesData, timestamps = SeriesResultsToLegacy(results)
+	return
+}
+
+func (s *tdengine) ReadSeries(ctx context.Context, in ReadDeviceSeriesDataInput) (results []*SeriesResult, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationReadSeries, in.DeviceModelName, len(in.PointCodes))
+	defer func() { observer.End(ctx, err) }()
+
 	if in.FillOption == "" {
 		in.FillOption = fillNone
 	}
-	var deviceId string
-	if len(in.DeviceIds) > 1 {
-		return nil, nil, fmt.Errorf("data series for multiple devices will be supportted in the future")
-	} else {
-		deviceId = in.DeviceIds[0]
-	}
-	// select `p1`,`p2` from xxx where `device`=`xxx` and `_ts`>xxx and `_ts`<xxx interval(xxx) fill(xxx)
-	var queryString strings.Builder
-	queryString.WriteString(fmt.Sprintf(
-		"SELECT %s,%s",
-		tdengineColumnPseudoWindowStart,
-		WrapColumnsWithBackQuote(
-			in.PointCodes,
-			"last",
-			false,
-			false,
-			false,
-		)))
-	queryString.WriteString(fmt.Sprintf(" FROM `%s` WHERE ", in.DeviceModelName))
-	queryString.WriteString(fmt.Sprintf("`%s`='%s' AND ", tdengineTableTagsDevice, deviceId))
-	queryString.WriteString(fmt.Sprintf("`%s`>=%d AND ", tdengineColumnTimestamp, in.StartTime))
-	queryString.WriteString(fmt.Sprintf("`%s`<=%d ", tdengineColumnTimestamp, in.EndTime))
-	queryString.WriteString(fmt.Sprintf("INTERVAL(%s) FILL(%s)", in.Interval, in.FillOption))
+	if len(in.DeviceIds) == 0 {
+		return nil, fmt.Errorf("deviceIds is required")
+	}
+	if err = in.validateDeviceIds(); err != nil {
+		return nil, err
+	}
+	aggregations, err := in.aggregations()
+	if err != nil {
+		return nil, err
+	}
+	/*
+		select _wstart, _wend, `device`, `project`, last(`p1`) as `p1`, avg(`p2`) as `p2` from xxx
+		where `device` in ('d1', 'd2') and `_ts`>=xxx and `_ts`<=xxx
+		partition by `device`, `project` interval(xxx) fill(xxx)
+	*/
+	qb := newSqlBuilder().
+		Raw("SELECT ").Raw(tdengineColumnPseudoWindowStart).Raw(", ").Raw(tdengineColumnPseudoWindowEnd).Raw(", ").
+		Identifiers([]string{tdengineTableTagsDevice, tdengineTableTagsProject})
+	for i, pointCode := range in.PointCodes {
+		qb.Raw(", ").AggregateWith(aggregations[i], pointCode, pointCode)
+	}
+	qb.Raw(" FROM ").Identifier(in.DeviceModelName).
+		Raw(" WHERE ").Identifier(tdengineTableTagsDevice).Raw(" IN (").Literals(in.DeviceIds).Raw(")")
+	if in.ProjectId != "" {
+		qb.Raw(" AND ").Identifier(tdengineTableTagsProject).Raw("=").Literal(in.ProjectId)
+	}
+	qs, err := qb.Raw(" AND ").Identifier(tdengineColumnTimestamp).Raw(">=").Int(in.StartTime).
+		Raw(" AND ").Identifier(tdengineColumnTimestamp).Raw("<=").Int(in.EndTime).
+		Raw(" PARTITION BY ").Identifiers([]string{tdengineTableTagsDevice, tdengineTableTagsProject}).
+		Raw(" ").Interval(in.Interval).Raw(" ").Fill(in.FillOption).
+		Build()
+	if err != nil {
+		return nil, err
+	}
 
-	serializedData, err := s.post(ctx, queryString.String())
+	serializedData, err := s.post(ctx, qs)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	if len(serializedData.Data) == 0 {
-		return
+	projectIds, err := s.seriesProjects(ctx, in, serializedData.Data)
+	if err != nil {
+		return nil, err
 	}
+	return alignPartitionedSeries(serializedData.Data, in.DeviceModelName, in.DeviceIds, projectIds, in.PointCodes), nil
+}
 
-	tsIndex := 0 // ts must be the first column
-	tsColumns := garray.NewStrArrayFrom([]string{tdengineColumnTimestamp, tdengineColumnPseudoWindowStart})
-	//var series []*garray.Array
-	series := make([][]any, 0)
-	for _, dv := range serializedData.Data {
-		for i, cv := range serializedData.ColumnMeta {
-			if len(series) <= i {
-				emptyAnyArray := make([]any, 0)
-				series = append(series, emptyAnyArray)
-			}
-			if tsColumns.Contains(gconv.String(cv[0])) {
-				// transform "2024-03-30T14:20:25.450Z" to unix time 1711808425450
-				series[i] = append(series[i], gtime.New(dv[i]).UnixMilli())
-			} else {
-				series[i] = append(series[i], dv[i])
-			}
+// seriesProjects returns deviceId -> the project to read of each device, as redis does:
+// ProjectId if it is not empty, or the project of the rows, or the last seen project of a device having rows in several projects
+func (s *tdengine) seriesProjects(ctx context.Context, in ReadDeviceSeriesDataInput, rows [][]any) (map[string]string, error) {
+	projectIds := make(map[string]string, len(in.DeviceIds))
+	for _, deviceId := range in.DeviceIds {
+		projectIds[deviceId] = in.ProjectId
+	}
+	if in.ProjectId != "" {
+		return projectIds, nil
+	}
+	ambiguousDeviceIds := make([]string, 0)
+	for deviceId, projects := range partitionProjects(rows) {
+		if len(projects) == 1 {
+			projectIds[deviceId] = projects[0]
+		} else {
+			ambiguousDeviceIds = append(ambiguousDeviceIds, deviceId)
 		}
 	}
-	timestamps = gconv.Int64s(series[tsIndex])
-	seriesData = series[tsIndex+1:]
-	return
+	if len(ambiguousDeviceIds) == 0 {
+		return projectIds, nil
+	}
+	slices.Sort(ambiguousDeviceIds)
+	devices, err := s.lastSeen(ctx, in.DeviceModelName, "", ambiguousDeviceIds)
+	if err != nil {
+		return nil, err
+	}
+	for deviceId, projectId := range lastSeenProjects(devices) {
+		projectIds[deviceId] = projectId
+	}
+	return projectIds, nil
 }
 
 func (s *tdengine) CreateSTable(ctx context.Context, stableName string, columns []TdengineColumn) (err error) {
-	tags := fmt.Sprintf(
-		"`%s` %s, `%s` %s",
-		tdengineTableTagsDevice,
-		tdengineTableTagsType,
-		tdengineTableTagsProject,
-		tdengineTableTagsType,
-	)
-	qs := fmt.Sprintf(
-		"CREATE STABLE `%s` (`%s` TIMESTAMP, %s) TAGS (%s)",
-		stableName,
-		tdengineColumnTimestamp,
-		WrapPointsWithDataType(columns),
-		tags,
-	)
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationCreateSTable, stableName, len(columns))
+	defer func() { observer.End(ctx, err) }()
+
+	qs, err := createSTableStatement(stableName, columns, false)
+	if err != nil {
+		return err
+	}
 	_, err = s.post(ctx, qs)
 	if err != nil {
 		return err
@@ -308,6 +377,7 @@ func (s *tdengine) CreateSTable(ctx context.Context, stableName string, columns
 }
 
 func (s *tdengine) post(ctx context.Context, qs string) (*TdengineHttpOutput, error) {
+	addSpanStatement(ctx, qs, s.redactStatement || tdenginePasswordRegex.MatchString(qs))
 	tdHttpRes, err := s.client.Post(ctx, s.uri, qs)
 	defer tdHttpRes.Close() // res need to be closed to prevent oom
 	if err != nil {
@@ -322,10 +392,14 @@ func (s *tdengine) post(ctx context.Context, qs string) (*TdengineHttpOutput, er
 	if err != nil {
 		return nil, err
 	}
+	if serializedData.Code != 0 {
+		return nil, s.newError(serializedData, qs)
+	}
 	return serializedData, err
 }
 
 func (s *tdengine) operateDb(ctx context.Context, qs string) (out *TdengineHttpOutput, err error) {
+	addSpanStatement(ctx, qs, s.redactStatement || tdenginePasswordRegex.MatchString(qs))
 	tdHttpRes, err := s.client.Post(ctx, s.uriNoDb, qs)
 	defer tdHttpRes.Close() // res need to be closed to prevent oom
 	if err != nil {
@@ -340,5 +414,8 @@ func (s *tdengine) operateDb(ctx context.Context, qs string) (out *TdengineHttpO
 	if err != nil {
 		return nil, err
 	}
+	if out.Code != 0 {
+		return nil, s.newError(out, qs)
+	}
 	return
 }
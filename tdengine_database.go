@@ -0,0 +1,225 @@
+package tsdb
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	options of the database are compared with SHOW CREATE DATABASE when initializing and provisioning,
+	options that tdengine alters online are applied by ALTER DATABASE: BUFFER, PAGES, CACHEMODEL, WAL_LEVEL,
+	and KEEP if it increases, a shorter KEEP deletes data so it is only reported,
+	other options are only reported, since changing them moves data or is not supported:
+	VGROUPS, REPLICA, DURATION and PRECISION
+*/
+
+var (
+	tdengineDurationRegex = regexp.MustCompile(`^[1-9][0-9]*[mhd]$`)
+	// options of SHOW CREATE DATABASE: BUFFER 256 CACHEMODEL 'none' KEEP 5256000m,5256000m,5256000m ...
+	tdengineDatabaseOptionRegex = regexp.MustCompile(`([A-Z][A-Z0-9_]*) ('[^']*'|\S+)`)
+	tdenginePrecisions          = []string{"ms", "us", "ns"}
+	tdengineCacheModels         = []string{"none", "last_row", "last_value", "both"}
+)
+
+// TdengineDatabaseReporter is implemented by the tdengine client, use it by type assertion on GetClient()
+type TdengineDatabaseReporter interface {
+	// DatabaseDrifts returns the options of the database that differed from the config at the last Init
+	DatabaseDrifts() []*TdengineDatabaseDrift
+}
+
+type TdengineDatabaseDrift struct {
+	Option  string `json:"option"`
+	Current string `json:"current"`
+	Desired string `json:"desired"`
+	Safe    bool   `json:"safe"`    // it can be applied by ALTER DATABASE
+	Applied bool   `json:"applied"` // it has been applied, unsafe drifts and failed alters are not
+}
+
+func validateDatabaseOptions(options TdengineDatabaseOptions) error {
+	if options.Vgroups < 0 || options.Replica < 0 || options.Buffer < 0 || options.Pages < 0 {
+		return errors.New("tdengine database options must not be negative")
+	}
+	if options.Duration != "" && !tdengineDurationRegex.MatchString(options.Duration) {
+		return fmt.Errorf("invalid tdengine database duration: %q", options.Duration)
+	}
+	if options.Precision != "" && !slices.Contains(tdenginePrecisions, options.Precision) {
+		return fmt.Errorf("unsupported tdengine database precision: %s", options.Precision)
+	}
+	if options.CacheModel != "" && !slices.Contains(tdengineCacheModels, options.CacheModel) {
+		return fmt.Errorf("unsupported tdengine database cache model: %s", options.CacheModel)
+	}
+	if options.WalLevel < 0 || options.WalLevel > 2 {
+		return fmt.Errorf("unsupported tdengine database wal level: %d", options.WalLevel)
+	}
+	return nil
+}
+
+func (s *tdengine) createDatabase(ctx context.Context) error {
+	options := s.databaseOptions
+	qb := newSqlBuilder().Raw("CREATE DATABASE ").Identifier(s.database)
+	if options.Vgroups > 0 {
+		qb.Raw(" VGROUPS ").Int(int64(options.Vgroups))
+	}
+	if options.Replica > 0 {
+		qb.Raw(" REPLICA ").Int(int64(options.Replica))
+	}
+	qb.Raw(" BUFFER ").Int(int64(cmp.Or(options.Buffer, tdengineDatabaseBufferDefault))).
+		Raw(" PAGES ").Int(int64(cmp.Or(options.Pages, tdengineDatabasePagesDefault))).
+		Raw(" DURATION ").Raw(cmp.Or(options.Duration, tdengineDatabaseDurationDefault))
+	if options.Precision != "" {
+		qb.Raw(" PRECISION ").Literal(options.Precision)
+	}
+	if options.CacheModel != "" {
+		qb.Raw(" CACHEMODEL ").Literal(options.CacheModel)
+	}
+	if options.WalLevel > 0 {
+		qb.Raw(" WAL_LEVEL ").Int(int64(options.WalLevel))
+	}
+	// dataKeep is validated when configured
+	qs, err := qb.Raw(" KEEP ").Raw(s.dataKeep).Build()
+	if err != nil {
+		return err
+	}
+	_, err = s.operateDb(ctx, qs)
+	if errors.Is(err, ErrDatabaseAlreadyExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	g.Log().Info(ctx, "tdengine database has been created!")
+	return nil
+}
+
+// syncDatabase compares the database with the configured options and applies safe drifts,
+// it logs and returns all drifts, and returns the first error of applying them
+func (s *tdengine) syncDatabase(ctx context.Context, dbInfo *TdengineHttpOutput) ([]*TdengineDatabaseDrift, error) {
+	// SHOW CREATE DATABASE returns one row: name, statement
+	if len(dbInfo.Data) == 0 || len(dbInfo.Data[0]) < 2 {
+		return nil, errors.New("tdengine returns no database statement")
+	}
+	drifts := s.databaseDrifts(parseDatabaseOptions(gconv.String(dbInfo.Data[0][1])))
+	var firstErr error
+	for _, drift := range drifts {
+		if !drift.Safe {
+			g.Log().Warningf(
+				ctx, "tdengine database option [ %s ] is %s instead of %s, it cannot be altered safely",
+				drift.Option, drift.Current, drift.Desired,
+			)
+			continue
+		}
+		qb := newSqlBuilder().Raw("ALTER DATABASE ").Identifier(s.database).Raw(" ").Raw(drift.Option).Raw(" ")
+		if drift.Option == "CACHEMODEL" {
+			qb.Literal(drift.Desired)
+		} else {
+			// numbers and durations are validated when configured
+			qb.Raw(drift.Desired)
+		}
+		if err := s.exec(ctx, qb); err != nil {
+			g.Log().Warningf(
+				ctx, "tdengine database option [ %s ] is %s instead of %s, it cannot be altered: %v",
+				drift.Option, drift.Current, drift.Desired, err,
+			)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		drift.Applied = true
+		g.Log().Infof(ctx, "tdengine database option [ %s ] has been altered from %s to %s", drift.Option, drift.Current, drift.Desired)
+	}
+	return drifts, firstErr
+}
+
+func (s *tdengine) databaseDrifts(current map[string]string) []*TdengineDatabaseDrift {
+	options := s.databaseOptions
+	drifts := make([]*TdengineDatabaseDrift, 0)
+	check := func(option string, desired string, safe bool, equal func(current string, desired string) bool) {
+		currentValue, ok := current[option]
+		// unset options and options unknown to this version of tdengine are skipped
+		if desired == "" || desired == "0" || !ok || equal(currentValue, desired) {
+			return
+		}
+		drifts = append(drifts, &TdengineDatabaseDrift{Option: option, Current: currentValue, Desired: desired, Safe: safe})
+	}
+	equalInt := func(current string, desired string) bool {
+		return gconv.Int(current) == gconv.Int(desired)
+	}
+	check("VGROUPS", strconv.Itoa(options.Vgroups), false, equalInt)
+	check("REPLICA", strconv.Itoa(options.Replica), false, equalInt)
+	check("BUFFER", strconv.Itoa(options.Buffer), true, equalInt)
+	check("PAGES", strconv.Itoa(options.Pages), true, equalInt)
+	check("DURATION", options.Duration, false, equalDatabaseDuration)
+	check("PRECISION", options.Precision, false, strings.EqualFold)
+	check("CACHEMODEL", options.CacheModel, true, strings.EqualFold)
+	check("WAL_LEVEL", strconv.Itoa(options.WalLevel), true, equalInt)
+	if s.keepConfigured {
+		check("KEEP", s.dataKeep, increasesDatabaseKeep(current["KEEP"], s.dataKeep), equalDatabaseDuration)
+	}
+	return drifts
+}
+
+// parseDatabaseOptions returns option -> value of a statement of SHOW CREATE DATABASE, quotes of values are removed
+func parseDatabaseOptions(statement string) map[string]string {
+	options := make(map[string]string)
+	for _, matches := range tdengineDatabaseOptionRegex.FindAllStringSubmatch(statement, -1) {
+		options[matches[1]] = strings.Trim(matches[2], "'")
+	}
+	return options
+}
+
+// equalDatabaseDuration compares durations like 14400m and 10d, KEEP of tdengine has 3 values, the last is compared
+func equalDatabaseDuration(current string, desired string) bool {
+	values := strings.Split(current, ",")
+	currentDuration, err := parseDatabaseDuration(values[len(values)-1])
+	if err != nil {
+		return false
+	}
+	desiredDuration, err := parseDatabaseDuration(desired)
+	if err != nil {
+		return false
+	}
+	return currentDuration == desiredDuration
+}
+
+// increasesDatabaseKeep reports whether the desired KEEP is longer than the current one,
+// KEEP of tdengine has 3 values, the last is compared
+func increasesDatabaseKeep(current string, desired string) bool {
+	values := strings.Split(current, ",")
+	currentDuration, err := parseDatabaseDuration(values[len(values)-1])
+	if err != nil {
+		return false
+	}
+	desiredDuration, err := parseDatabaseDuration(desired)
+	if err != nil {
+		return false
+	}
+	return desiredDuration > currentDuration
+}
+
+// parseDatabaseDuration parses durations of tdengine, a number without unit is in days
+func parseDatabaseDuration(in string) (time.Duration, error) {
+	in = strings.TrimSpace(in)
+	if _, err := strconv.Atoi(in); err == nil {
+		in += "d"
+	}
+	return gtime.ParseDuration(in)
+}
+
+func (s *tdengine) DatabaseDrifts() []*TdengineDatabaseDrift {
+	s.Lock()
+	defer s.Unlock()
+
+	return slices.Clone(s.drifts)
+}
@@ -0,0 +1,100 @@
+package tsdb
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+)
+
+func TestDatabaseDriftsOfKeep(t *testing.T) {
+	cases := []struct {
+		name     string
+		current  string
+		dataKeep string
+		want     []*TdengineDatabaseDrift
+	}{
+		{name: "the same keep", current: "5256000m,5256000m,5256000m", dataKeep: "3650d", want: []*TdengineDatabaseDrift{}},
+		{
+			name:     "a longer keep is applied",
+			current:  "14400m,14400m,14400m",
+			dataKeep: "30d",
+			want:     []*TdengineDatabaseDrift{{Option: "KEEP", Current: "14400m,14400m,14400m", Desired: "30d", Safe: true}},
+		},
+		{
+			name:     "a shorter keep is only reported",
+			current:  "43200m,43200m,43200m",
+			dataKeep: "10d",
+			want:     []*TdengineDatabaseDrift{{Option: "KEEP", Current: "43200m,43200m,43200m", Desired: "10d", Safe: false}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &tdengine{dataKeep: c.dataKeep, keepConfigured: true}
+			if got := s.databaseDrifts(map[string]string{"KEEP": c.current}); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTdengineKeepConfigured(t *testing.T) {
+	cases := []struct {
+		name     string
+		dataKeep string
+		want     bool
+	}{
+		{name: "not set", dataKeep: "", want: false},
+		{name: "valid", dataKeep: "30d", want: true},
+		{name: "invalid", dataKeep: "30x", want: false},
+		{name: "shorter than the minimum", dataKeep: "1h", want: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &tdengine{}
+			config := Config{Host: "localhost", Port: 6041, Username: "u", Password: "p", Database: "db", DataKeep: c.dataKeep}
+			if err := s.configure(config); err != nil {
+				t.Fatal(err)
+			}
+			if s.keepConfigured != c.want {
+				t.Fatalf("got keepConfigured %t, want %t", s.keepConfigured, c.want)
+			}
+		})
+	}
+}
+
+func TestSyncDatabase(t *testing.T) {
+	// CACHEMODEL fails to be altered, VGROUPS and a shorter KEEP are not safe
+	s, server := newTdengineTestServer(t, func(statement string) *TdengineHttpOutput {
+		if strings.Contains(statement, "CACHEMODEL") {
+			return &TdengineHttpOutput{Code: 0x0388, Desc: "failed"}
+		}
+		return nil
+	})
+	s.database = "db"
+	s.databaseOptions = TdengineDatabaseOptions{Vgroups: 4, Buffer: 512, CacheModel: "both"}
+	s.dataKeep = "10d"
+	s.keepConfigured = true
+	dbInfo := &TdengineHttpOutput{Data: [][]any{{
+		"db", "CREATE DATABASE `db` BUFFER 256 CACHEMODEL 'none' KEEP 43200m,43200m,43200m VGROUPS 2",
+	}}}
+	drifts, err := s.syncDatabase(context.Background(), dbInfo)
+	if err == nil {
+		t.Fatal("expected the error of altering CACHEMODEL")
+	}
+	want := []*TdengineDatabaseDrift{
+		{Option: "VGROUPS", Current: "2", Desired: "4", Safe: false},
+		{Option: "BUFFER", Current: "256", Desired: "512", Safe: true, Applied: true},
+		{Option: "CACHEMODEL", Current: "none", Desired: "both", Safe: true},
+		{Option: "KEEP", Current: "43200m,43200m,43200m", Desired: "10d", Safe: false},
+	}
+	if !reflect.DeepEqual(drifts, want) {
+		t.Fatalf("got %s, want %s", gjson.MustEncodeString(drifts), gjson.MustEncodeString(want))
+	}
+	wantStatements := []string{"ALTER DATABASE `db` BUFFER 512", "ALTER DATABASE `db` CACHEMODEL 'both'"}
+	if !reflect.DeepEqual(server.statements, wantStatements) {
+		t.Fatalf("got statements %q, want %q", server.statements, wantStatements)
+	}
+}
@@ -0,0 +1,61 @@
+package tsdb
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// sentinels of tdengine errors, match them by errors.Is
+var (
+	ErrAuthFailed             = errors.New("tdengine authentication failure")
+	ErrDatabaseNotExist       = errors.New("tdengine database does not exist")
+	ErrDatabaseAlreadyExist   = errors.New("tdengine database already exists")
+	ErrTableNotExist          = errors.New("tdengine table does not exist")
+	ErrInvalidColumn          = errors.New("tdengine invalid column")
+	ErrUserAlreadyExist       = errors.New("tdengine user already exists")
+	tdenginePasswordRegex     = regexp.MustCompile(`(?i)\bPASS\b`)
+	tdengineSentinelErrorCode = map[error][]int{
+		ErrAuthFailed:           {tdengineCodeAuthFailure},
+		ErrDatabaseNotExist:     {tdengineCodeDatabaseNotExist},
+		ErrDatabaseAlreadyExist: {tdengineCodeDatabaseAlreadyExist},
+		ErrTableNotExist:        {tdengineCodeSTableNotExist, tdengineCodeTableNotExist, tdengineCodeParserTableNotExist},
+		ErrInvalidColumn:        {tdengineCodeColumnNotExist, tdengineCodeParserInvalidColumn},
+		ErrUserAlreadyExist:     {tdengineCodeUserAlreadyExist},
+	}
+)
+
+// TdengineError is a failed response of tdengine, Code is the lower 16 bits of the tdengine error code
+type TdengineError struct {
+	Code      int
+	Desc      string
+	Statement string // redacted if Config.RedactStatement is set
+}
+
+func (e *TdengineError) Error() string {
+	if e.Statement == "" {
+		return fmt.Sprintf("tdengine error [ 0x%04X ]: %s", e.Code, e.Desc)
+	}
+	return fmt.Sprintf("tdengine error [ 0x%04X ]: %s, statement: %s", e.Code, e.Desc, e.Statement)
+}
+
+func (e *TdengineError) Is(target error) bool {
+	codes, ok := tdengineSentinelErrorCode[target]
+	return ok && slices.Contains(codes, e.Code)
+}
+
+func (s *tdengine) newError(out *TdengineHttpOutput, qs string) error {
+	statement := qs
+	// passwords never go into errors
+	if s.redactStatement || tdenginePasswordRegex.MatchString(qs) {
+		statement = RedactStatement(qs)
+	}
+	return &TdengineError{Code: out.Code, Desc: out.Desc, Statement: statement}
+}
+
+// isSchemaNotCreated tells whether a table or its columns are not created yet,
+// e.g. the alarm stable and its columns are created by schemaless writes of the first event
+func isSchemaNotCreated(err error) bool {
+	return errors.Is(err, ErrTableNotExist) || errors.Is(err, ErrInvalidColumn)
+}
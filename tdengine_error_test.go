@@ -0,0 +1,82 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/net/gclient"
+)
+
+var tdengineSentinelErrors = []error{
+	ErrAuthFailed, ErrDatabaseNotExist, ErrDatabaseAlreadyExist, ErrTableNotExist, ErrInvalidColumn, ErrUserAlreadyExist,
+}
+
+func TestTdengineErrorIs(t *testing.T) {
+	cases := []struct {
+		name string
+		code int
+		want error // nil if no sentinel matches
+	}{
+		{name: "authentication failure", code: tdengineCodeAuthFailure, want: ErrAuthFailed},
+		{name: "database not exist", code: tdengineCodeDatabaseNotExist, want: ErrDatabaseNotExist},
+		{name: "database already exist", code: tdengineCodeDatabaseAlreadyExist, want: ErrDatabaseAlreadyExist},
+		{name: "stable not exist", code: tdengineCodeSTableNotExist, want: ErrTableNotExist},
+		{name: "table not exist", code: tdengineCodeTableNotExist, want: ErrTableNotExist},
+		{name: "table not exist of the parser", code: tdengineCodeParserTableNotExist, want: ErrTableNotExist},
+		{name: "column not exist", code: tdengineCodeColumnNotExist, want: ErrInvalidColumn},
+		{name: "invalid column of the parser", code: tdengineCodeParserInvalidColumn, want: ErrInvalidColumn},
+		{name: "user already exist", code: tdengineCodeUserAlreadyExist, want: ErrUserAlreadyExist},
+		{name: "unknown code", code: 0x9999, want: nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tdErr := &TdengineError{Code: c.code, Desc: c.name}
+			for _, err := range []error{tdErr, fmt.Errorf("wrapped: %w", tdErr)} {
+				for _, sentinel := range tdengineSentinelErrors {
+					if got := errors.Is(err, sentinel); got != (sentinel == c.want) {
+						t.Fatalf("errors.Is(%v, %v) = %t", err, sentinel, got)
+					}
+				}
+				var target *TdengineError
+				if !errors.As(err, &target) || target.Code != c.code {
+					t.Fatalf("errors.As(%v) does not get the code 0x%04X", err, c.code)
+				}
+			}
+		})
+	}
+}
+
+func TestTdengineResponseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := tdengineCodeTableNotExist
+		if r.URL.Path == "/rest/sql" {
+			code = tdengineCodeUserAlreadyExist
+		}
+		_, _ = fmt.Fprintf(w, `{"code":%d,"desc":"failed"}`, code)
+	}))
+	defer server.Close()
+	s := &tdengine{uri: server.URL + "/rest/sql/db", uriNoDb: server.URL + "/rest/sql", client: gclient.New()}
+	ctx := context.Background()
+
+	_, err := s.post(ctx, "SELECT * FROM `t1`")
+	var tdErr *TdengineError
+	if !errors.Is(err, ErrTableNotExist) || !errors.As(err, &tdErr) || tdErr.Statement != "SELECT * FROM `t1`" {
+		t.Fatalf("got error %v of post, want ErrTableNotExist with the statement", err)
+	}
+	if !isSchemaNotCreated(fmt.Errorf("read: %w", err)) {
+		t.Fatalf("the wrapped error %v is not a schema not created", err)
+	}
+
+	_, err = s.operateDb(ctx, "CREATE USER `u1` PASS 'secret'")
+	if !errors.Is(err, ErrUserAlreadyExist) || errors.Is(err, ErrTableNotExist) {
+		t.Fatalf("got error %v of operateDb, want ErrUserAlreadyExist", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("the password is not redacted in %v", err)
+	}
+}
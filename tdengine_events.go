@@ -0,0 +1,134 @@
+package tsdb
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	events are written to the alarm stable by schemaless writes, they are of kind event:
+	alarm (`_ts` TIMESTAMP, `severity` VARCHAR, `code` VARCHAR, `message` VARCHAR, `state` VARCHAR, `acked` BOOL, ...)
+	TAGS (`device` NCHAR, `kind` NCHAR, `model` NCHAR, `project` NCHAR)
+*/
+
+func (s *tdengine) WriteEvents(ctx context.Context, events []*Event) (err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationWriteEvents, "", len(events))
+	defer func() { observer.End(ctx, err) }()
+
+	metrics := make([]*Metric, 0, len(events))
+	for _, event := range events {
+		if err = validateEvent(event); err != nil {
+			return err
+		}
+		metric := &Metric{Name: tdengineTableNameAlarm, Time: gtime.NewFromTimeStamp(event.Time)}
+		metric.AddTag(tdengineTableTagsDevice, event.DeviceId)
+		metric.AddTag(tdengineTableTagsModel, event.DeviceModelName)
+		metric.AddTag(tdengineTableTagsKind, tdengineAlarmKindEvent)
+		if event.ProjectId != "" {
+			metric.AddTag(tdengineTableTagsProject, event.ProjectId)
+		}
+		// string fields must be quoted, or they are taken as numbers
+		metric.AddField(eventFieldSeverity, lineProtocolString(event.Severity))
+		metric.AddField(eventFieldCode, lineProtocolString(event.Code))
+		metric.AddField(eventFieldMessage, lineProtocolString(event.Message))
+		metric.AddField(eventFieldState, lineProtocolString(event.State))
+		metric.AddField(eventFieldAcked, event.Acked)
+		metrics = append(metrics, metric)
+	}
+	return s.Write(ctx, metrics)
+}
+
+func (s *tdengine) ReadEvents(ctx context.Context, in ReadEventsInput) (events []*Event, total int, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationReadEvents, in.DeviceModelName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	/*
+		select count(*) from `alarm` where xxx
+		select `_ts`, `device`, `project`, `severity`, `code`, `message`, `state`, `acked` from `alarm`
+		where xxx order by `_ts` desc limit xxx offset xxx
+	*/
+	where := func(qb *sqlBuilder) *sqlBuilder {
+		qb.Raw(" FROM ").Identifier(tdengineTableNameAlarm).
+			Raw(" WHERE ").Identifier(tdengineTableTagsModel).Raw("=").Literal(in.DeviceModelName).
+			Raw(" AND ").Identifier(tdengineTableTagsKind).Raw("=").Literal(tdengineAlarmKindEvent).
+			Raw(" AND ").Identifier(tdengineColumnTimestamp).Raw(">=").Int(in.StartTime).
+			Raw(" AND ").Identifier(tdengineColumnTimestamp).Raw("<=").Int(in.EndTime)
+		if in.ProjectId != "" {
+			qb.Raw(" AND ").Identifier(tdengineTableTagsProject).Raw("=").Literal(in.ProjectId)
+		}
+		if len(in.DeviceIds) > 0 {
+			qb.Raw(" AND ").Identifier(tdengineTableTagsDevice).Raw(" IN (").Literals(in.DeviceIds).Raw(")")
+		}
+		if len(in.Severities) > 0 {
+			qb.Raw(" AND ").Identifier(eventFieldSeverity).Raw(" IN (").Literals(in.Severities).Raw(")")
+		}
+		if len(in.States) > 0 {
+			qb.Raw(" AND ").Identifier(eventFieldState).Raw(" IN (").Literals(in.States).Raw(")")
+		}
+		if in.Acked != nil {
+			qb.Raw(" AND ").Identifier(eventFieldAcked).Raw("=").Raw(gconv.String(*in.Acked))
+		}
+		return qb
+	}
+	countQs, err := where(newSqlBuilder().Raw("SELECT COUNT(*)")).Build()
+	if err != nil {
+		return nil, 0, err
+	}
+	countData, err := s.post(ctx, countQs)
+	if isSchemaNotCreated(err) {
+		return make([]*Event, 0), 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(countData.Data) > 0 && len(countData.Data[0]) > 0 {
+		total = gconv.Int(countData.Data[0][0])
+	}
+	if total == 0 || in.Offset >= total {
+		return make([]*Event, 0), total, nil
+	}
+
+	qb := where(newSqlBuilder().Raw("SELECT ").Identifiers([]string{
+		tdengineColumnTimestamp,
+		tdengineTableTagsDevice,
+		tdengineTableTagsProject,
+		eventFieldSeverity,
+		eventFieldCode,
+		eventFieldMessage,
+		eventFieldState,
+		eventFieldAcked,
+	})).Raw(" ORDER BY ").Identifier(tdengineColumnTimestamp).Raw(" DESC")
+	// OFFSET requires LIMIT
+	limit := in.Limit
+	if limit <= 0 {
+		limit = total
+	}
+	qs, err := qb.Raw(" LIMIT ").Int(int64(limit)).Raw(" OFFSET ").Int(int64(max(in.Offset, 0))).Build()
+	if err != nil {
+		return nil, 0, err
+	}
+	serializedData, err := s.post(ctx, qs)
+	if err != nil {
+		return nil, 0, err
+	}
+	events = make([]*Event, 0, len(serializedData.Data))
+	for _, row := range serializedData.Data {
+		if len(row) < 8 {
+			continue
+		}
+		events = append(events, &Event{
+			DeviceModelName: in.DeviceModelName,
+			DeviceId:        gconv.String(row[1]),
+			ProjectId:       gconv.String(row[2]),
+			Time:            gtime.New(row[0]).UnixMilli(),
+			Severity:        gconv.String(row[3]),
+			Code:            gconv.String(row[4]),
+			Message:         gconv.String(row[5]),
+			State:           gconv.String(row[6]),
+			Acked:           gconv.Bool(row[7]),
+		})
+	}
+	return events, total, nil
+}
@@ -2,6 +2,7 @@ package tsdb
 
 type TdengineHttpOutput struct {
 	Code       int      `json:"code"`
+	Desc       string   `json:"desc"`
 	ColumnMeta [][3]any `json:"column_meta"`
 	Data       [][]any  `json:"data"`
 	Rows       int      `json:"rows"`
@@ -11,3 +12,30 @@ type TdengineColumn struct {
 	ColumnName string
 	DataType   string
 }
+
+// TdengineDatabaseOptions are options of the database, unset options are left to tdengine,
+// except BUFFER, PAGES and DURATION, which have defaults of this package when the database is created
+type TdengineDatabaseOptions struct {
+	Vgroups    int
+	Replica    int
+	Buffer     int    // MB, 48 by default
+	Pages      int    // 128 by default
+	Duration   string // e.g. 6h, 10d, 6h by default
+	Precision  string // ms, us or ns
+	CacheModel string // none, last_row, last_value or both
+	WalLevel   int    // 1 or 2
+}
+
+// TdengineColumnMeta is a column or tag of a stable described by tdengine
+type TdengineColumnMeta struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`   // e.g. TIMESTAMP, DOUBLE, NCHAR
+	Length int    `json:"length"` // bytes of fixed length types, the declared length of VARCHAR, NCHAR and so on
+	IsTag  bool   `json:"isTag"`
+}
+
+type TdengineSTable struct {
+	Name    string                `json:"name"`
+	Columns []*TdengineColumnMeta `json:"columns"` // the first is the timestamp
+	Tags    []*TdengineColumnMeta `json:"tags"`
+}
@@ -0,0 +1,107 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gclient"
+)
+
+/*
+	provisioning is an explicit step before Init, it is run with the admin account:
+	1. set the admin password, the initial password is only tried if the admin password fails
+	2. create the database if it does not exist, or alter its options, see tdengine_database.go
+	3. create the application user of Config.Username, or reset its password if it exists
+	4. grant read and write on the database to the application user
+	then Init connects as the application user, it never changes users or creates the database
+*/
+
+// user names are not quoted in tdengine statements
+var tdengineUserRegex = regexp.MustCompile(`^[A-Za-z0-9_]{1,23}$`)
+
+// TdengineAdmin is the admin account used by ProvisionTdengine
+type TdengineAdmin struct {
+	Username string // root by default
+	Password string // the admin password is set to it
+	// tried if Password fails, e.g. on a new server, the default password of tdengine by default
+	InitialPassword string
+}
+
+// ProvisionTdengine prepares the admin account, the database and the application user of config
+func ProvisionTdengine(ctx context.Context, config Config, admin TdengineAdmin) (err error) {
+	s := &tdengine{client: gclient.New()}
+	if err = s.configure(config); err != nil {
+		return err
+	}
+	if admin.Username == "" {
+		admin.Username = tdengineDefaultAdmin
+	}
+	if admin.Password == "" {
+		return errors.New("admin password is required")
+	}
+	if admin.InitialPassword == "" {
+		admin.InitialPassword = tdengineDefaultPassword
+	}
+	for _, username := range []string{admin.Username, s.username} {
+		if !tdengineUserRegex.MatchString(username) {
+			return fmt.Errorf("invalid tdengine user name: %q", username)
+		}
+	}
+	defer s.client.CloseIdleConnections()
+
+	s.client.SetBasicAuth(admin.Username, admin.Password)
+	_, err = s.operateDb(ctx, "SELECT SERVER_STATUS()")
+	if errors.Is(err, ErrAuthFailed) {
+		s.client.SetBasicAuth(admin.Username, admin.InitialPassword)
+		if err = s.exec(ctx, newSqlBuilder().Raw("ALTER USER ").Raw(admin.Username).Raw(" PASS ").Literal(admin.Password)); err != nil {
+			return fmt.Errorf("failed to change admin password: %w", err)
+		}
+		g.Log().Info(ctx, "tdengine admin password has been changed!")
+		s.client.SetBasicAuth(admin.Username, admin.Password)
+	} else if err != nil {
+		return err
+	}
+
+	if err = s.createDatabase(ctx); err != nil {
+		return err
+	}
+	dbInfo, err := s.operateDb(ctx, fmt.Sprintf("SHOW CREATE DATABASE `%s`", s.database))
+	if err != nil {
+		return err
+	}
+	if _, err = s.syncDatabase(ctx, dbInfo); err != nil {
+		return fmt.Errorf("failed to alter database: %w", err)
+	}
+	if s.username == admin.Username {
+		// the admin account is used by the application
+		return nil
+	}
+	err = s.exec(ctx, newSqlBuilder().Raw("CREATE USER ").Raw(s.username).Raw(" PASS ").Literal(s.password))
+	if errors.Is(err, ErrUserAlreadyExist) {
+		err = s.exec(ctx, newSqlBuilder().Raw("ALTER USER ").Raw(s.username).Raw(" PASS ").Literal(s.password))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	for _, privilege := range []string{"READ", "WRITE"} {
+		qb := newSqlBuilder().Raw("GRANT ").Raw(privilege).Raw(" ON ").Identifier(s.database).Raw(".* TO ").Raw(s.username)
+		if err = s.exec(ctx, qb); err != nil {
+			return fmt.Errorf("failed to grant %s: %w", privilege, err)
+		}
+	}
+	g.Log().Infof(ctx, "tdengine user [ %s ] has been provisioned!", s.username)
+	return nil
+}
+
+// exec runs a statement without the database in the uri
+func (s *tdengine) exec(ctx context.Context, qb *sqlBuilder) error {
+	qs, err := qb.Build()
+	if err != nil {
+		return err
+	}
+	_, err = s.operateDb(ctx, qs)
+	return err
+}
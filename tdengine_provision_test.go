@@ -0,0 +1,120 @@
+package tsdb
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+func TestProvisionTdengine(t *testing.T) {
+	const showCreate = "SHOW CREATE DATABASE `db`"
+	cases := []struct {
+		name      string
+		username  string
+		admin     TdengineAdmin
+		newServer bool // the admin password fails, the initial password is used
+		existing  bool // the database and the application user exist, KEEP of the database is shorter
+		want      []string
+		wantUsers []string
+		wantErr   bool
+	}{
+		{
+			name:      "a new server",
+			username:  "app",
+			admin:     TdengineAdmin{Password: "admin'pass"},
+			newServer: true,
+			want: []string{
+				"SELECT SERVER_STATUS()",
+				`ALTER USER root PASS 'admin\'pass'`,
+				"CREATE DATABASE `db` BUFFER 48 PAGES 128 DURATION 6h KEEP 30d",
+				showCreate,
+				"CREATE USER app PASS 'app-pass'",
+				"GRANT READ ON `db`.* TO app",
+				"GRANT WRITE ON `db`.* TO app",
+			},
+			wantUsers: []string{
+				"root:admin'pass", "root:taosdata", "root:admin'pass", "root:admin'pass", "root:admin'pass", "root:admin'pass", "root:admin'pass",
+			},
+		},
+		{
+			name:     "provisioned again",
+			username: "app",
+			admin:    TdengineAdmin{Username: "admin", Password: "admin-pass"},
+			existing: true,
+			want: []string{
+				"SELECT SERVER_STATUS()",
+				"CREATE DATABASE `db` BUFFER 48 PAGES 128 DURATION 6h KEEP 30d",
+				showCreate,
+				"ALTER DATABASE `db` KEEP 30d",
+				"CREATE USER app PASS 'app-pass'",
+				"ALTER USER app PASS 'app-pass'",
+				"GRANT READ ON `db`.* TO app",
+				"GRANT WRITE ON `db`.* TO app",
+			},
+		},
+		{
+			name:     "the admin is the application user",
+			username: "root",
+			admin:    TdengineAdmin{Password: "admin-pass"},
+			want: []string{
+				"SELECT SERVER_STATUS()",
+				"CREATE DATABASE `db` BUFFER 48 PAGES 128 DURATION 6h KEEP 30d",
+				showCreate,
+			},
+		},
+		{name: "no admin password", username: "app", wantErr: true},
+		{name: "invalid application user", username: "app; DROP DATABASE db", admin: TdengineAdmin{Password: "admin-pass"}, wantErr: true},
+		{name: "invalid admin", username: "app", admin: TdengineAdmin{Username: "root`", Password: "admin-pass"}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			keep := "43200m,43200m,43200m"
+			if c.existing {
+				keep = "14400m,14400m,14400m"
+			}
+			_, server := newTdengineTestServer(t, func(statement string) *TdengineHttpOutput {
+				switch {
+				case statement == "SELECT SERVER_STATUS()" && c.newServer:
+					return &TdengineHttpOutput{Code: tdengineCodeAuthFailure, Desc: "Authentication failure"}
+				case strings.HasPrefix(statement, "CREATE DATABASE ") && c.existing:
+					return &TdengineHttpOutput{Code: tdengineCodeDatabaseAlreadyExist, Desc: "Database already exists"}
+				case strings.HasPrefix(statement, "CREATE USER ") && c.existing:
+					return &TdengineHttpOutput{Code: tdengineCodeUserAlreadyExist, Desc: "User already exists"}
+				case statement == showCreate:
+					createStatement := "CREATE DATABASE `db` BUFFER 48 PAGES 128 DURATION 360m KEEP " + keep
+					return &TdengineHttpOutput{Data: [][]any{{"db", createStatement}}}
+				}
+				return nil
+			})
+			serverUrl, _ := url.Parse(server.url)
+			config := Config{
+				Host:     serverUrl.Hostname(),
+				Port:     gconv.Int(serverUrl.Port()),
+				Username: c.username,
+				Password: "app-pass",
+				Database: "db",
+				DataKeep: "30d",
+			}
+			err := ProvisionTdengine(context.Background(), config, c.admin)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %t", err, c.wantErr)
+			}
+			if c.wantErr {
+				if len(server.statements) != 0 {
+					t.Fatalf("got statements %q, want none", server.statements)
+				}
+				return
+			}
+			if !reflect.DeepEqual(server.statements, c.want) {
+				t.Fatalf("got statements\n%q\nwant\n%q", server.statements, c.want)
+			}
+			if c.wantUsers != nil && !reflect.DeepEqual(server.users, c.wantUsers) {
+				t.Fatalf("got users %q, want %q", server.users, c.wantUsers)
+			}
+		})
+	}
+}
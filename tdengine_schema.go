@@ -0,0 +1,253 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	stables of device models change as firmware adds points,
+	columns are altered one statement each, since tdengine alters one column in a statement,
+	the operations altering a stable return the stable described after them
+*/
+
+// types of which the length can be modified
+var tdengineVariableLengthTypes = []string{"VARCHAR", "BINARY", "NCHAR", "VARBINARY", "GEOMETRY"}
+
+// TdengineSchemaManager is implemented by the tdengine client, use it by type assertion on GetClient()
+type TdengineSchemaManager interface {
+	DescribeSTable(ctx context.Context, stableName string) (*TdengineSTable, error)
+	// ListSTables returns names of the stables of the database in ascending order
+	ListSTables(ctx context.Context) ([]string, error)
+	CreateSTableIfNotExists(ctx context.Context, stableName string, columns []TdengineColumn) (*TdengineSTable, error)
+	// AddColumns adds the columns that do not exist yet
+	AddColumns(ctx context.Context, stableName string, columns []TdengineColumn) (*TdengineSTable, error)
+	// DropColumns drops the columns that exist, the timestamp and tags cannot be dropped
+	DropColumns(ctx context.Context, stableName string, columnNames []string) (*TdengineSTable, error)
+	// ModifyColumnLength enlarges a column or tag of a variable length type, like NCHAR
+	ModifyColumnLength(ctx context.Context, stableName string, columnName string, length int) (*TdengineSTable, error)
+	DropSTable(ctx context.Context, stableName string) error
+}
+
+func (s *tdengine) DescribeSTable(ctx context.Context, stableName string) (stable *TdengineSTable, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationDescribeSTable, stableName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	return s.describeSTable(ctx, stableName)
+}
+
+func (s *tdengine) ListSTables(ctx context.Context) (stableNames []string, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationListSTables, "", 0)
+	defer func() { observer.End(ctx, err) }()
+
+	serializedData, err := s.post(ctx, "SHOW STABLES")
+	if err != nil {
+		return nil, err
+	}
+	stableNames = make([]string, 0, len(serializedData.Data))
+	for _, row := range serializedData.Data {
+		if len(row) > 0 {
+			stableNames = append(stableNames, gconv.String(row[0]))
+		}
+	}
+	sort.Strings(stableNames)
+	return stableNames, nil
+}
+
+func (s *tdengine) CreateSTableIfNotExists(
+	ctx context.Context,
+	stableName string,
+	columns []TdengineColumn,
+) (stable *TdengineSTable, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationCreateSTable, stableName, len(columns))
+	defer func() { observer.End(ctx, err) }()
+
+	qs, err := createSTableStatement(stableName, columns, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.post(ctx, qs); err != nil {
+		return nil, err
+	}
+	return s.describeSTable(ctx, stableName)
+}
+
+func (s *tdengine) AddColumns(ctx context.Context, stableName string, columns []TdengineColumn) (stable *TdengineSTable, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationAlterSTable, stableName, len(columns))
+	defer func() { observer.End(ctx, err) }()
+
+	stable, err = s.describeSTable(ctx, stableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range columns {
+		if stable.find(column.ColumnName) != nil {
+			continue
+		}
+		// alter stable `xxx` add column `p1` DOUBLE
+		qb := newSqlBuilder().Raw("ALTER STABLE ").Identifier(stableName).
+			Raw(" ADD COLUMN ").Identifier(column.ColumnName).Raw(" ").Raw(tdengineColumnDataType(column.DataType))
+		if err = s.alterSTable(ctx, qb); err != nil {
+			return nil, err
+		}
+	}
+	return s.describeSTable(ctx, stableName)
+}
+
+func (s *tdengine) DropColumns(ctx context.Context, stableName string, columnNames []string) (stable *TdengineSTable, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationAlterSTable, stableName, len(columnNames))
+	defer func() { observer.End(ctx, err) }()
+
+	stable, err = s.describeSTable(ctx, stableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, columnName := range columnNames {
+		column := stable.find(columnName)
+		if column == nil {
+			continue
+		}
+		if column.IsTag || column == stable.Columns[0] {
+			return nil, fmt.Errorf("column [ %s ] of stable [ %s ] cannot be dropped", columnName, stableName)
+		}
+	}
+	for _, columnName := range columnNames {
+		if stable.find(columnName) == nil {
+			continue
+		}
+		// alter stable `xxx` drop column `p1`
+		qb := newSqlBuilder().Raw("ALTER STABLE ").Identifier(stableName).Raw(" DROP COLUMN ").Identifier(columnName)
+		if err = s.alterSTable(ctx, qb); err != nil {
+			return nil, err
+		}
+	}
+	return s.describeSTable(ctx, stableName)
+}
+
+func (s *tdengine) ModifyColumnLength(
+	ctx context.Context,
+	stableName string,
+	columnName string,
+	length int,
+) (stable *TdengineSTable, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationAlterSTable, stableName, 1)
+	defer func() { observer.End(ctx, err) }()
+
+	stable, err = s.describeSTable(ctx, stableName)
+	if err != nil {
+		return nil, err
+	}
+	column := stable.find(columnName)
+	if column == nil {
+		return nil, fmt.Errorf("column [ %s ] of stable [ %s ] does not exist: %w", columnName, stableName, ErrInvalidColumn)
+	}
+	if !slices.Contains(tdengineVariableLengthTypes, column.Type) {
+		return nil, fmt.Errorf("the length of column [ %s ] of type %s cannot be modified", columnName, column.Type)
+	}
+	// tdengine only enlarges columns
+	if length <= column.Length {
+		return nil, fmt.Errorf("the length of column [ %s ] is %d, it can only be enlarged", columnName, column.Length)
+	}
+	// alter stable `xxx` modify column `p1` NCHAR(64)
+	kind := " MODIFY COLUMN "
+	if column.IsTag {
+		kind = " MODIFY TAG "
+	}
+	qb := newSqlBuilder().Raw("ALTER STABLE ").Identifier(stableName).Raw(kind).Identifier(columnName).
+		Raw(" ").Raw(column.Type).Raw("(").Int(int64(length)).Raw(")")
+	if err = s.alterSTable(ctx, qb); err != nil {
+		return nil, err
+	}
+	return s.describeSTable(ctx, stableName)
+}
+
+func (s *tdengine) DropSTable(ctx context.Context, stableName string) (err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationDropSTable, stableName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	qs, err := newSqlBuilder().Raw("DROP STABLE IF EXISTS ").Identifier(stableName).Build()
+	if err != nil {
+		return err
+	}
+	_, err = s.post(ctx, qs)
+	return err
+}
+
+func (s *tdengine) describeSTable(ctx context.Context, stableName string) (*TdengineSTable, error) {
+	qs, err := newSqlBuilder().Raw("DESCRIBE ").Identifier(stableName).Build()
+	if err != nil {
+		return nil, err
+	}
+	serializedData, err := s.post(ctx, qs)
+	if err != nil {
+		return nil, err
+	}
+	// rows of DESCRIBE: field, type, length, note, newer versions have more columns after them
+	stable := &TdengineSTable{
+		Name:    stableName,
+		Columns: make([]*TdengineColumnMeta, 0),
+		Tags:    make([]*TdengineColumnMeta, 0),
+	}
+	for _, row := range serializedData.Data {
+		if len(row) < 4 {
+			continue
+		}
+		column := &TdengineColumnMeta{
+			Name:   gconv.String(row[0]),
+			Type:   strings.ToUpper(gconv.String(row[1])),
+			Length: gconv.Int(row[2]),
+			IsTag:  strings.EqualFold(gconv.String(row[3]), "TAG"),
+		}
+		if column.IsTag {
+			stable.Tags = append(stable.Tags, column)
+		} else {
+			stable.Columns = append(stable.Columns, column)
+		}
+	}
+	if len(stable.Columns) == 0 {
+		return nil, fmt.Errorf("stable [ %s ] has no columns: %w", stableName, ErrTableNotExist)
+	}
+	return stable, nil
+}
+
+func (s *tdengine) alterSTable(ctx context.Context, qb *sqlBuilder) error {
+	qs, err := qb.Build()
+	if err != nil {
+		return err
+	}
+	_, err = s.post(ctx, qs)
+	return err
+}
+
+// find returns the column or tag of the name, nil if it does not exist
+func (s *TdengineSTable) find(name string) *TdengineColumnMeta {
+	for _, column := range append(slices.Clip(s.Columns), s.Tags...) {
+		if column.Name == name {
+			return column
+		}
+	}
+	return nil
+}
+
+// createSTableStatement returns the statement creating a stable of a device model
+func createSTableStatement(stableName string, columns []TdengineColumn, ifNotExists bool) (string, error) {
+	// create stable `xxx` (`_ts` TIMESTAMP, `p1` DOUBLE) tags (`device` NCHAR(16), `project` NCHAR(16))
+	qb := newSqlBuilder().Raw("CREATE STABLE ")
+	if ifNotExists {
+		qb.Raw("IF NOT EXISTS ")
+	}
+	qb.Identifier(stableName).
+		Raw(" (").Identifier(tdengineColumnTimestamp).Raw(" TIMESTAMP")
+	for _, column := range columns {
+		qb.Raw(", ").Identifier(column.ColumnName).Raw(" ").Raw(tdengineColumnDataType(column.DataType))
+	}
+	qb.Raw(") TAGS (").
+		Identifier(tdengineTableTagsDevice).Raw(" ").Raw(tdengineTableTagsType).Raw(", ").
+		Identifier(tdengineTableTagsProject).Raw(" ").Raw(tdengineTableTagsType).Raw(")")
+	return qb.Build()
+}
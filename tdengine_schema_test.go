@@ -0,0 +1,229 @@
+package tsdb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/net/gclient"
+)
+
+// tdengineTestServer answers the REST api of tdengine by respond and records the statements
+type tdengineTestServer struct {
+	url        string
+	statements []string
+	users      []string // user:password of the basic auth of each statement
+	respond    func(statement string) *TdengineHttpOutput
+	sync.Mutex
+}
+
+func newTdengineTestServer(t *testing.T, respond func(statement string) *TdengineHttpOutput) (*tdengine, *tdengineTestServer) {
+	t.Helper()
+	recorder := &tdengineTestServer{respond: respond}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		recorder.Lock()
+		recorder.statements = append(recorder.statements, string(body))
+		username, password, _ := r.BasicAuth()
+		recorder.users = append(recorder.users, username+":"+password)
+		recorder.Unlock()
+		out := recorder.respond(string(body))
+		if out == nil {
+			out = &TdengineHttpOutput{}
+		}
+		_, _ = w.Write([]byte(gjson.MustEncodeString(out)))
+	}))
+	t.Cleanup(server.Close)
+	recorder.url = server.URL
+	return &tdengine{uri: server.URL + "/rest/sql/db", uriNoDb: server.URL + "/rest/sql", client: gclient.New()}, recorder
+}
+
+// describeOutput answers DESCRIBE of a stable with _ts, p1 DOUBLE, s1 NCHAR(32) and the tags,
+// rows have the columns of newer versions after the note
+func describeOutput(statement string) *TdengineHttpOutput {
+	if !strings.HasPrefix(statement, "DESCRIBE ") {
+		return nil
+	}
+	return &TdengineHttpOutput{Data: [][]any{
+		{"_ts", "TIMESTAMP", 8, "", "delta-i", "lz4", "medium"},
+		{"p1", "DOUBLE", 8, "", "delta-d", "lz4", "medium"},
+		{"s1", "nchar", 32, "", "disabled", "zstd", "medium"},
+		{"device", "NCHAR", 16, "TAG", "disabled", "disabled", "disabled"},
+		{"project", "NCHAR", 16, "TAG", "disabled", "disabled", "disabled"},
+		{"short"},
+	}}
+}
+
+func TestCreateSTableStatement(t *testing.T) {
+	cases := []struct {
+		name        string
+		columns     []TdengineColumn
+		ifNotExists bool
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:    "no columns",
+			columns: nil,
+			want:    "CREATE STABLE `m1` (`_ts` TIMESTAMP) TAGS (`device` NCHAR(16), `project` NCHAR(16))",
+		},
+		{
+			name:        "columns of each data type",
+			columns:     []TdengineColumn{{ColumnName: "p1", DataType: "5"}, {ColumnName: "p2", DataType: "12"}, {ColumnName: "p3", DataType: "13"}},
+			ifNotExists: true,
+			want: "CREATE STABLE IF NOT EXISTS `m1` (`_ts` TIMESTAMP, `p1` DOUBLE, `p2` BOOL, `p3` NCHAR(32)) " +
+				"TAGS (`device` NCHAR(16), `project` NCHAR(16))",
+		},
+		{name: "invalid column name", columns: []TdengineColumn{{ColumnName: "p1`", DataType: "10"}}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := createSTableStatement("m1", c.columns, c.ifNotExists)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %t", err, c.wantErr)
+			}
+			if !c.wantErr && got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDescribeSTable(t *testing.T) {
+	s, _ := newTdengineTestServer(t, describeOutput)
+	stable, err := s.DescribeSTable(context.Background(), "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &TdengineSTable{
+		Name: "m1",
+		Columns: []*TdengineColumnMeta{
+			{Name: "_ts", Type: "TIMESTAMP", Length: 8},
+			{Name: "p1", Type: "DOUBLE", Length: 8},
+			{Name: "s1", Type: "NCHAR", Length: 32},
+		},
+		Tags: []*TdengineColumnMeta{
+			{Name: "device", Type: "NCHAR", Length: 16, IsTag: true},
+			{Name: "project", Type: "NCHAR", Length: 16, IsTag: true},
+		},
+	}
+	if !reflect.DeepEqual(stable, want) {
+		t.Fatalf("got %s, want %s", gjson.MustEncodeString(stable), gjson.MustEncodeString(want))
+	}
+
+	empty, _ := newTdengineTestServer(t, func(string) *TdengineHttpOutput { return nil })
+	if _, err = empty.DescribeSTable(context.Background(), "m1"); !isSchemaNotCreated(err) {
+		t.Fatalf("got error %v of a stable without columns, want ErrTableNotExist", err)
+	}
+}
+
+func TestAlterSTable(t *testing.T) {
+	cases := []struct {
+		name    string
+		alter   func(ctx context.Context, s *tdengine) (*TdengineSTable, error)
+		want    []string // statements after DESCRIBE
+		wantErr bool
+	}{
+		{
+			name: "add a column that does not exist",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.AddColumns(ctx, "m1", []TdengineColumn{{ColumnName: "p1", DataType: "10"}, {ColumnName: "p2", DataType: "12"}})
+			},
+			want: []string{"ALTER STABLE `m1` ADD COLUMN `p2` BOOL"},
+		},
+		{
+			name: "drop a column",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.DropColumns(ctx, "m1", []string{"p1", "p9"})
+			},
+			want: []string{"ALTER STABLE `m1` DROP COLUMN `p1`"},
+		},
+		{
+			name: "drop a tag",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.DropColumns(ctx, "m1", []string{"p1", "device"})
+			},
+			wantErr: true,
+		},
+		{
+			name: "drop the timestamp",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.DropColumns(ctx, "m1", []string{"_ts"})
+			},
+			wantErr: true,
+		},
+		{
+			name: "enlarge a column",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.ModifyColumnLength(ctx, "m1", "s1", 64)
+			},
+			want: []string{"ALTER STABLE `m1` MODIFY COLUMN `s1` NCHAR(64)"},
+		},
+		{
+			name: "enlarge a tag",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.ModifyColumnLength(ctx, "m1", "device", 32)
+			},
+			want: []string{"ALTER STABLE `m1` MODIFY TAG `device` NCHAR(32)"},
+		},
+		{
+			name: "shrink a column",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.ModifyColumnLength(ctx, "m1", "s1", 16)
+			},
+			wantErr: true,
+		},
+		{
+			name: "the same length",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.ModifyColumnLength(ctx, "m1", "s1", 32)
+			},
+			wantErr: true,
+		},
+		{
+			name: "modify a column of a fixed length",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.ModifyColumnLength(ctx, "m1", "p1", 16)
+			},
+			wantErr: true,
+		},
+		{
+			name: "modify a column that does not exist",
+			alter: func(ctx context.Context, s *tdengine) (*TdengineSTable, error) {
+				return s.ModifyColumnLength(ctx, "m1", "p9", 16)
+			},
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, server := newTdengineTestServer(t, describeOutput)
+			_, err := c.alter(context.Background(), s)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %t", err, c.wantErr)
+			}
+			altered := make([]string, 0)
+			for _, statement := range server.statements {
+				if !strings.HasPrefix(statement, "DESCRIBE ") {
+					altered = append(altered, statement)
+				}
+			}
+			if c.wantErr {
+				// the guards refuse before any statement is sent
+				if len(altered) != 0 {
+					t.Fatalf("got statements %q, want none", altered)
+				}
+				return
+			}
+			if !reflect.DeepEqual(altered, c.want) {
+				t.Fatalf("got statements %q, want %q", altered, c.want)
+			}
+		})
+	}
+}
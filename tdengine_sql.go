@@ -0,0 +1,219 @@
+package tsdb
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// identifiers are always wrapped with back quotes, so only characters that are safe inside back quotes are allowed
+	tdengineIdentifierRegex = regexp.MustCompile(`^[\p{L}\p{N}_.\-]{1,192}$`)
+	// units supported by tdengine: b(ns), u(μs), a(ms), s, m, h, d, w, n(month), y
+	tdengineIntervalRegex = regexp.MustCompile(`^[1-9][0-9]*[buasmhdwny]$`)
+	// VALUE(1.5), VALUE(1, 2), VALUE, 1.5 and VALUE_F with the same forms, parentheses must be balanced
+	fillValueRegex = regexp.MustCompile(`^(VALUE|VALUE_F)\s*(?:\(\s*([^()]*?)\s*\)|,\s*([^()]*?))$`)
+)
+
+// fillOption is a parsed FillOption of ReadDeviceSeriesDataInput
+type fillOption struct {
+	Mode   string
+	Values []float64
+}
+
+func (f fillOption) String() string {
+	if len(f.Values) == 0 {
+		return f.Mode
+	}
+	values := make([]string, 0, len(f.Values))
+	for _, v := range f.Values {
+		values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return fmt.Sprintf("%s, %s", f.Mode, strings.Join(values, ", "))
+}
+
+func parseFillOption(in string) (out fillOption, err error) {
+	option := strings.ToUpper(strings.TrimSpace(in))
+	switch option {
+	case "":
+		return fillOption{Mode: fillNone}, nil
+	case fillNone, fillNull, fillPrev, fillNext, fillLinear, fillNullF:
+		return fillOption{Mode: option}, nil
+	}
+	matches := fillValueRegex.FindStringSubmatch(option)
+	if matches == nil {
+		return out, fmt.Errorf("invalid fill option: %s", in)
+	}
+	out.Mode = matches[1]
+	// values are in parentheses or after a comma
+	for _, v := range strings.Split(matches[2]+matches[3], ",") {
+		value, innErr := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if innErr != nil {
+			return out, fmt.Errorf("invalid fill value: %s", in)
+		}
+		out.Values = append(out.Values, value)
+	}
+	return out, nil
+}
+
+func validateIdentifier(name string) error {
+	if !tdengineIdentifierRegex.MatchString(name) {
+		return fmt.Errorf("invalid identifier: %q", name)
+	}
+	return nil
+}
+
+func validateInterval(interval string) error {
+	if !tdengineIntervalRegex.MatchString(interval) {
+		return fmt.Errorf("invalid interval: %q", interval)
+	}
+	return nil
+}
+
+func escapeStringLiteral(in string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(in)
+}
+
+// sqlBuilder writes a tdengine statement and keeps the first validation error,
+// user inputs must only be written through the validating methods, Raw is for constant fragments
+type sqlBuilder struct {
+	builder strings.Builder
+	err     error
+}
+
+func newSqlBuilder() *sqlBuilder {
+	return &sqlBuilder{}
+}
+
+func (b *sqlBuilder) Raw(fragment string) *sqlBuilder {
+	b.builder.WriteString(fragment)
+	return b
+}
+
+func (b *sqlBuilder) Identifier(name string) *sqlBuilder {
+	if err := validateIdentifier(name); err != nil {
+		b.setErr(err)
+		return b
+	}
+	b.builder.WriteString(WrapWithQuote(name))
+	return b
+}
+
+func (b *sqlBuilder) Identifiers(names []string) *sqlBuilder {
+	for i, name := range names {
+		if i > 0 {
+			b.builder.WriteString(", ")
+		}
+		b.Identifier(name)
+	}
+	return b
+}
+
+func (b *sqlBuilder) Literal(value string) *sqlBuilder {
+	b.builder.WriteString("'")
+	b.builder.WriteString(escapeStringLiteral(value))
+	b.builder.WriteString("'")
+	return b
+}
+
+func (b *sqlBuilder) Literals(values []string) *sqlBuilder {
+	for i, value := range values {
+		if i > 0 {
+			b.builder.WriteString(", ")
+		}
+		b.Literal(value)
+	}
+	return b
+}
+
+func (b *sqlBuilder) Int(value int64) *sqlBuilder {
+	b.builder.WriteString(strconv.FormatInt(value, 10))
+	return b
+}
+
+// Aggregate writes fn(`column`) as `alias`, fn must be a valid aggregation like "avg" or "percentile(95)"
+func (b *sqlBuilder) Aggregate(fn string, column string, alias string) *sqlBuilder {
+	aggregation, err := ParseAggregation(fn)
+	if err != nil {
+		b.setErr(err)
+		return b
+	}
+	return b.AggregateWith(aggregation, column, alias)
+}
+
+// AggregateWith writes fn(`column`) as `alias`,
+// percentile is written as apercentile(`column`, n, 't-digest'), since tdengine only computes percentile on tables,
+// but stables are queried, so it is an estimate of the exact percentile computed by redis
+func (b *sqlBuilder) AggregateWith(aggregation Aggregation, column string, alias string) *sqlBuilder {
+	if aggregation.Function == aggregationPercentile {
+		if aggregation.Percentile < 0 || aggregation.Percentile > 100 {
+			b.setErr(fmt.Errorf("invalid percentile: %v", aggregation.Percentile))
+			return b
+		}
+		b.builder.WriteString("apercentile(")
+		b.Identifier(column)
+		b.builder.WriteString(", ")
+		b.builder.WriteString(strconv.FormatFloat(aggregation.Percentile, 'f', -1, 64))
+		b.builder.WriteString(", 't-digest') as ")
+		b.Identifier(alias)
+		return b
+	}
+	if !slices.Contains(aggregationFunctions, aggregation.Function) {
+		b.setErr(fmt.Errorf("unsupported aggregation: %q", aggregation.Function))
+		return b
+	}
+	b.builder.WriteString(aggregation.Function)
+	b.builder.WriteString("(")
+	b.Identifier(column)
+	b.builder.WriteString(") as ")
+	b.Identifier(alias)
+	return b
+}
+
+func (b *sqlBuilder) Aggregates(fn string, columns []string) *sqlBuilder {
+	for i, column := range columns {
+		if i > 0 {
+			b.builder.WriteString(", ")
+		}
+		b.Aggregate(fn, column, column)
+	}
+	return b
+}
+
+func (b *sqlBuilder) Interval(interval string) *sqlBuilder {
+	if err := validateInterval(interval); err != nil {
+		b.setErr(err)
+		return b
+	}
+	b.builder.WriteString("INTERVAL(")
+	b.builder.WriteString(interval)
+	b.builder.WriteString(")")
+	return b
+}
+
+func (b *sqlBuilder) Fill(fill string) *sqlBuilder {
+	option, err := parseFillOption(fill)
+	if err != nil {
+		b.setErr(err)
+		return b
+	}
+	b.builder.WriteString("FILL(")
+	b.builder.WriteString(option.String())
+	b.builder.WriteString(")")
+	return b
+}
+
+func (b *sqlBuilder) Build() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	return b.builder.String(), nil
+}
+
+func (b *sqlBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
@@ -0,0 +1,146 @@
+package tsdb
+
+import "testing"
+
+func TestSqlBuilderIdentifier(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", in: "p1", want: "`p1`"},
+		{name: "unicode", in: "温度_1", want: "`温度_1`"},
+		{name: "dot and dash", in: "a.b-c", want: "`a.b-c`"},
+		{name: "empty", in: "", wantErr: true},
+		{name: "back quote", in: "p1`; DROP DATABASE db; --", wantErr: true},
+		{name: "space", in: "p 1", wantErr: true},
+		{name: "quote", in: "p'1", wantErr: true},
+		{name: "too long", in: string(make([]byte, 193)), wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := newSqlBuilder().Identifier(c.in).Build()
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSqlBuilderLiteral(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "d1", want: "'d1'"},
+		{name: "quote", in: "d1' OR '1'='1", want: `'d1\' OR \'1\'=\'1'`},
+		{name: "backslash", in: `d1\`, want: `'d1\\'`},
+		{name: "backslash before quote", in: `\'`, want: `'\\\''`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := newSqlBuilder().Literal(c.in).Build()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSqlBuilderIntervalAndFill(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval string
+		fill     string
+		want     string
+		wantErr  bool
+	}{
+		{name: "none", interval: "1m", fill: "", want: "INTERVAL(1m) FILL(NONE)"},
+		{name: "prev", interval: "10s", fill: "prev", want: "INTERVAL(10s) FILL(PREV)"},
+		{name: "value", interval: "1h", fill: "VALUE(1.5, 2)", want: "INTERVAL(1h) FILL(VALUE, 1.5, 2)"},
+		{name: "value with comma", interval: "1d", fill: "value, 0", want: "INTERVAL(1d) FILL(VALUE, 0)"},
+		{name: "invalid interval", interval: "1m) fill(none", fill: "", wantErr: true},
+		{name: "zero interval", interval: "0m", fill: "", wantErr: true},
+		{name: "invalid fill", interval: "1m", fill: "none); DROP DATABASE db", wantErr: true},
+		{name: "value_f", interval: "1m", fill: "VALUE_F(1)", want: "INTERVAL(1m) FILL(VALUE_F, 1)"},
+		{name: "invalid fill value", interval: "1m", fill: "VALUE(x)", wantErr: true},
+		{name: "unbalanced parenthesis", interval: "1m", fill: "VALUE(1.5", wantErr: true},
+		{name: "closing parenthesis after a comma", interval: "1m", fill: "VALUE, 1.5)", wantErr: true},
+		{name: "no value", interval: "1m", fill: "VALUE()", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := newSqlBuilder().Interval(c.interval).Raw(" ").Fill(c.fill).Build()
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSqlBuilderKeepsFirstError(t *testing.T) {
+	_, err := newSqlBuilder().Raw("SELECT ").Identifier("a`b").Raw(" FROM ").Identifier("").Build()
+	if err == nil || err.Error() != `invalid identifier: "a`+"`"+`b"` {
+		t.Fatalf("expected the error of the first identifier, got %v", err)
+	}
+}
+
+func TestSqlBuilderAggregateWith(t *testing.T) {
+	cases := []struct {
+		name        string
+		aggregation Aggregation
+		want        string
+		wantErr     bool
+	}{
+		{name: "avg", aggregation: Aggregation{Function: aggregationAvg}, want: "avg(`p1`) as `p1`"},
+		{name: "twa", aggregation: Aggregation{Function: aggregationTwa}, want: "twa(`p1`) as `p1`"},
+		{
+			name:        "percentile",
+			aggregation: Aggregation{Function: aggregationPercentile, Percentile: 99.5},
+			want:        "apercentile(`p1`, 99.5, 't-digest') as `p1`",
+		},
+		{name: "invalid percentile", aggregation: Aggregation{Function: aggregationPercentile, Percentile: 101}, wantErr: true},
+		{name: "unknown", aggregation: Aggregation{Function: "avg(`p0`), last"}, wantErr: true},
+		{name: "empty", aggregation: Aggregation{}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := newSqlBuilder().AggregateWith(c.aggregation, "p1", "p1").Build()
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package tsdb
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+/*
+	last seen times are the last timestamps of the stable of a model,
+	status events are written to the alarm stable by schemaless writes, so it is created by the first event:
+	alarm (`_ts` TIMESTAMP, `online` BOOL, ...) TAGS (`device` NCHAR, `kind` NCHAR, `model` NCHAR, `project` NCHAR),
+	status events are of kind status, see tdengine_events.go for other columns
+*/
+
+func (s *tdengine) TrackDeviceStatus(ctx context.Context, deviceModelName string) (events []*DeviceStatusEvent, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationTrackStatus, deviceModelName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	return trackDeviceStatus(ctx, s, deviceModelName, s.realTimeWindowDuration)
+}
+
+func (s *tdengine) ReadDeviceStatus(ctx context.Context, in ReadDeviceStatusInput) (devices []*DeviceStatus, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationReadStatus, in.DeviceModelName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	return readDeviceStatus(ctx, s, in, s.realTimeWindowDuration)
+}
+
+func (s *tdengine) ReadDeviceUptime(ctx context.Context, in ReadDeviceUptimeInput) (uptime *DeviceUptime, err error) {
+	ctx, observer := startOperation(ctx, ClientTypeTdengine, operationReadUptime, in.DeviceModelName, 0)
+	defer func() { observer.End(ctx, err) }()
+
+	return readDeviceUptime(ctx, s, in)
+}
+
+func (s *tdengine) lastSeen(ctx context.Context, deviceModelName string, projectId string, deviceIds []string) ([]*DeviceStatus, error) {
+	// select last(`_ts`) as `_ts`, `device`, `project` from xxx where xxx partition by `device`, `project`
+	qb := newSqlBuilder().Raw("SELECT ").
+		Aggregate("last", tdengineColumnTimestamp, tdengineColumnTimestamp).Raw(", ").
+		Identifiers([]string{tdengineTableTagsDevice, tdengineTableTagsProject}).
+		Raw(" FROM ").Identifier(deviceModelName)
+	conditions := 0
+	where := func() *sqlBuilder {
+		if conditions++; conditions == 1 {
+			return qb.Raw(" WHERE ")
+		}
+		return qb.Raw(" AND ")
+	}
+	if projectId != "" {
+		where().Identifier(tdengineTableTagsProject).Raw("=").Literal(projectId)
+	}
+	if len(deviceIds) > 0 {
+		where().Identifier(tdengineTableTagsDevice).Raw(" IN (").Literals(deviceIds).Raw(")")
+	}
+	qs, err := qb.Raw(" PARTITION BY ").Identifiers([]string{tdengineTableTagsDevice, tdengineTableTagsProject}).Build()
+	if err != nil {
+		return nil, err
+	}
+	serializedData, err := s.post(ctx, qs)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*DeviceStatus, 0, len(serializedData.Data))
+	for _, row := range serializedData.Data {
+		if len(row) < 3 {
+			continue
+		}
+		devices = append(devices, &DeviceStatus{
+			DeviceModelName: deviceModelName,
+			DeviceId:        gconv.String(row[1]),
+			ProjectId:       gconv.String(row[2]),
+			LastSeen:        gtime.New(row[0]).UnixMilli(),
+		})
+	}
+	return devices, nil
+}
+
+func (s *tdengine) lastStatusEvents(ctx context.Context, deviceModelName string) (map[deviceStatusKey]*DeviceStatusEvent, error) {
+	// select last(`_ts`) as `_ts`, last(`online`) as `online`, `device`, `project` from `alarm`
+	// where `model`='xxx' and `kind`='status' partition by `device`, `project`
+	qs, err := newSqlBuilder().Raw("SELECT ").
+		Aggregates("last", []string{tdengineColumnTimestamp, tdengineColumnAlarmOnline}).Raw(", ").
+		Identifiers([]string{tdengineTableTagsDevice, tdengineTableTagsProject}).
+		Raw(" FROM ").Identifier(tdengineTableNameAlarm).
+		Raw(" WHERE ").Identifier(tdengineTableTagsModel).Raw("=").Literal(deviceModelName).
+		Raw(" AND ").Identifier(tdengineTableTagsKind).Raw("=").Literal(tdengineAlarmKindStatus).
+		Raw(" PARTITION BY ").Identifiers([]string{tdengineTableTagsDevice, tdengineTableTagsProject}).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	events := make(map[deviceStatusKey]*DeviceStatusEvent)
+	serializedData, err := s.post(ctx, qs)
+	if isSchemaNotCreated(err) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range serializedData.Data {
+		if len(row) < 4 {
+			continue
+		}
+		// the project tag is null for devices without a project
+		event := &DeviceStatusEvent{
+			DeviceModelName: deviceModelName,
+			DeviceId:        gconv.String(row[2]),
+			ProjectId:       gconv.String(row[3]),
+			Online:          gconv.Bool(row[1]),
+			Time:            gtime.New(row[0]).UnixMilli(),
+		}
+		events[deviceStatusKey{deviceId: event.DeviceId, projectId: event.ProjectId}] = event
+	}
+	return events, nil
+}
+
+func (s *tdengine) writeStatusEvents(ctx context.Context, events []*DeviceStatusEvent) error {
+	metrics := make([]*Metric, 0, len(events))
+	for _, event := range events {
+		metric := &Metric{Name: tdengineTableNameAlarm, Time: gtime.NewFromTimeStamp(event.Time)}
+		metric.AddTag(tdengineTableTagsDevice, event.DeviceId)
+		metric.AddTag(tdengineTableTagsModel, event.DeviceModelName)
+		metric.AddTag(tdengineTableTagsKind, tdengineAlarmKindStatus)
+		if event.ProjectId != "" {
+			metric.AddTag(tdengineTableTagsProject, event.ProjectId)
+		}
+		metric.AddField(tdengineColumnAlarmOnline, event.Online)
+		metrics = append(metrics, metric)
+	}
+	return s.Write(ctx, metrics)
+}
+
+func (s *tdengine) statusEvents(
+	ctx context.Context,
+	deviceModelName string,
+	projectId string,
+	deviceId string,
+	startTime int64,
+	endTime int64,
+) ([]*DeviceStatusEvent, error) {
+	// select `_ts`, `online`, `project` from `alarm` where `model`='xxx' and `kind`='status' and `device`='xxx'
+	selectDeviceEvents := func() *sqlBuilder {
+		return newSqlBuilder().Raw("SELECT ").
+			Identifiers([]string{tdengineColumnTimestamp, tdengineColumnAlarmOnline, tdengineTableTagsProject}).
+			Raw(" FROM ").Identifier(tdengineTableNameAlarm).
+			Raw(" WHERE ").Identifier(tdengineTableTagsModel).Raw("=").Literal(deviceModelName).
+			Raw(" AND ").Identifier(tdengineTableTagsKind).Raw("=").Literal(tdengineAlarmKindStatus).
+			Raw(" AND ").Identifier(tdengineTableTagsDevice).Raw("=").Literal(deviceId)
+	}
+	if projectId == "" {
+		// the project of the last event of the device
+		lastQs, err := selectDeviceEvents().Raw(" ORDER BY ").Identifier(tdengineColumnTimestamp).Raw(" DESC LIMIT 1").Build()
+		if err != nil {
+			return nil, err
+		}
+		serializedData, err := s.post(ctx, lastQs)
+		if isSchemaNotCreated(err) {
+			return make([]*DeviceStatusEvent, 0), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(serializedData.Data) > 0 && len(serializedData.Data[0]) >= 3 {
+			projectId = gconv.String(serializedData.Data[0][2])
+		}
+	}
+	selectEvents := func() *sqlBuilder {
+		qb := selectDeviceEvents().Raw(" AND ").Identifier(tdengineTableTagsProject)
+		if projectId == "" {
+			// the project tag is null for devices without a project
+			return qb.Raw(" IS NULL")
+		}
+		return qb.Raw("=").Literal(projectId)
+	}
+	// the last event before the range tells the status at the start
+	previousQs, err := selectEvents().
+		Raw(" AND ").Identifier(tdengineColumnTimestamp).Raw("<").Int(startTime).
+		Raw(" ORDER BY ").Identifier(tdengineColumnTimestamp).Raw(" DESC LIMIT 1").
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	qs, err := selectEvents().
+		Raw(" AND ").Identifier(tdengineColumnTimestamp).Raw(">=").Int(startTime).
+		Raw(" AND ").Identifier(tdengineColumnTimestamp).Raw("<=").Int(endTime).
+		Raw(" ORDER BY ").Identifier(tdengineColumnTimestamp).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*DeviceStatusEvent, 0)
+	for _, statement := range []string{previousQs, qs} {
+		serializedData, innErr := s.post(ctx, statement)
+		if isSchemaNotCreated(innErr) {
+			return events, nil
+		}
+		if innErr != nil {
+			return nil, innErr
+		}
+		for _, row := range serializedData.Data {
+			if len(row) < 3 {
+				continue
+			}
+			events = append(events, &DeviceStatusEvent{
+				DeviceModelName: deviceModelName,
+				DeviceId:        deviceId,
+				ProjectId:       gconv.String(row[2]),
+				Online:          gconv.Bool(row[1]),
+				Time:            gtime.New(row[0]).UnixMilli(),
+			})
+		}
+	}
+	return events, nil
+}
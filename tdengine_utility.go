@@ -3,22 +3,35 @@ package tsdb
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"strings"
+	"unicode"
 
+	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 )
 
+var (
+	// lineProtocolMeasurementEscaper escapes measurements of the influxdb line protocol
+	lineProtocolMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	// lineProtocolKeyEscaper escapes tag keys, tag values and field keys of the influxdb line protocol
+	lineProtocolKeyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
 func Serialize(metrics []*Metric) *bytes.Buffer {
 	/*
 		influxdb line protocol format:
 		measurement,tag_set field_set timestamp
+		separators in measurements, tags and field keys are escaped,
+		they cannot have control characters, which the line protocol cannot escape, see validateLineProtocolNames,
+		field values are written by gconv.String, so string values must be quoted by the caller, e.g. by lineProtocolString
 	*/
 	var buffer bytes.Buffer
 	for idxM, metric := range metrics {
 		if idxM > 0 {
 			buffer.WriteByte('\n')
 		}
-		buffer.WriteString(metric.Name)
+		buffer.WriteString(lineProtocolMeasurementEscaper.Replace(metric.Name))
 		// tags and fields of a valid metric should not be empty
 		if metric.TagList == nil || len(metric.TagList) == 0 || metric.FieldList == nil || len(metric.FieldList) == 0 {
 			continue
@@ -26,9 +39,9 @@ func Serialize(metrics []*Metric) *bytes.Buffer {
 		// write tags
 		for _, tag := range metric.TagList {
 			buffer.WriteByte(',')
-			buffer.WriteString(tag.Key)
+			buffer.WriteString(lineProtocolKeyEscaper.Replace(tag.Key))
 			buffer.WriteByte('=')
-			buffer.WriteString(tag.Value)
+			buffer.WriteString(lineProtocolKeyEscaper.Replace(tag.Value))
 		}
 		buffer.WriteByte(' ')
 		// write fields
@@ -36,7 +49,7 @@ func Serialize(metrics []*Metric) *bytes.Buffer {
 			if idxF > 0 {
 				buffer.WriteByte(',')
 			}
-			buffer.WriteString(field.Key)
+			buffer.WriteString(lineProtocolKeyEscaper.Replace(field.Key))
 			buffer.WriteByte('=')
 			buffer.WriteString(gconv.String(field.Value))
 		}
@@ -46,49 +59,32 @@ func Serialize(metrics []*Metric) *bytes.Buffer {
 	return &buffer
 }
 
-func WrapWithQuote(in string) (out string) {
-	return fmt.Sprintf("`%s`", in)
-}
-
-func WrapColumnsWithBackQuote(column []string, useFunc string, withTimestamp bool, withDevice bool, withProject bool) string {
-	out := WrapWithQuoteFromSlice(column, useFunc)
-	if withProject {
-		out = fmt.Sprintf("`%s` as `%s`, ", tdengineColumnProject, tdengineColumnAliasProject) + out
-	}
-	if withDevice {
-		out = fmt.Sprintf("`%s` as `%s`, ", tdengineColumnDevice, tdengineColumnAliasDevice) + out
-	}
-	if withTimestamp {
-		if useFunc != "" {
-			out = fmt.Sprintf("%s(`%s`) as `%s`, ", useFunc, tdengineColumnTimestamp, tdengineColumnTimestamp) + out
-		} else {
-			out = fmt.Sprintf("`%s`, ", tdengineColumnTimestamp) + out
+// validateLineProtocolNames rejects control characters in measurements, tags and field keys,
+// a newline would end the line of the point, and the line protocol has no escape for them
+func validateLineProtocolNames(metrics []*Metric) error {
+	for _, metric := range metrics {
+		if strings.ContainsFunc(metric.Name, unicode.IsControl) {
+			return fmt.Errorf("measurement %q has control characters", metric.Name)
 		}
-	}
-	return out
-}
-
-func WrapWithQuoteFromSlice(in []string, useFunc string) (out string) {
-	var strBuilder strings.Builder
-	for _, v := range in {
-		if useFunc != "" {
-			strBuilder.WriteString(fmt.Sprintf("%s(`%s`) as `%s`, ", useFunc, v, v))
-		} else {
-			strBuilder.WriteString(fmt.Sprintf("`%s`, ", v))
+		for _, tag := range metric.TagList {
+			if strings.ContainsFunc(tag.Key, unicode.IsControl) || strings.ContainsFunc(tag.Value, unicode.IsControl) {
+				return fmt.Errorf("tag %q=%q of measurement %q has control characters", tag.Key, tag.Value, metric.Name)
+			}
+		}
+		for _, field := range metric.FieldList {
+			if strings.ContainsFunc(field.Key, unicode.IsControl) {
+				return fmt.Errorf("field %q of measurement %q has control characters", field.Key, metric.Name)
+			}
 		}
 	}
-	return strings.TrimRight(strBuilder.String(), ", ")
+	return nil
 }
 
-func WrapDevicesWithSingleQuote(device []string) (out string) {
-	var strBuilder strings.Builder
-	for _, v := range device {
-		strBuilder.WriteString(fmt.Sprintf("'%s', ", v))
-	}
-	return strings.TrimRight(strBuilder.String(), ", ")
+func WrapWithQuote(in string) (out string) {
+	return fmt.Sprintf("`%s`", in)
 }
 
-func WrapPointsWithDataType(in []TdengineColumn) (out string) {
+func tdengineColumnDataType(dataType string) string {
 	/*
 		if we use schemaless line protocol to write data and data types are not wrapped
 		tdengine will consider it as double by default
@@ -112,13 +108,96 @@ func WrapPointsWithDataType(in []TdengineColumn) (out string) {
 		"12": "BOOL",                  // BOOL
 		"13": "NCHAR(32)",             // STRING
 	}
-	var strBuilder strings.Builder
-	for _, v := range in {
-		dataType, ok := dataTypeMap[v.DataType]
-		if !ok {
-			dataType = tdengineDefaultDataType
+	out, ok := dataTypeMap[dataType]
+	if !ok {
+		return tdengineDefaultDataType
+	}
+	return out
+}
+
+// partitionProjects returns deviceId -> the projects of the device in rows partitioned by device and project,
+// row format: [_wstart, _wend, device, project, ...]
+func partitionProjects(rows [][]any) map[string][]string {
+	out := make(map[string][]string)
+	for _, row := range rows {
+		if len(row) < 4 {
+			continue
+		}
+		deviceId, projectId := gconv.String(row[2]), gconv.String(row[3])
+		if !slices.Contains(out[deviceId], projectId) {
+			out[deviceId] = append(out[deviceId], projectId)
+		}
+	}
+	return out
+}
+
+func alignPartitionedSeries(
+	rows [][]any,
+	deviceModelName string,
+	deviceIds []string,
+	projectIds map[string]string, // deviceId -> the project to read, rows of other projects of the device are skipped
+	pointCodes []string,
+) []*SeriesResult {
+	/*
+		row format: [_wstart, _wend, device, project, p1, p2, ...], rows of different devices may have different windows
+		results are ordered by deviceIds first and then by point codes:
+		[d1_p1, d1_p2, d2_p1, d2_p2], missing windows of a device are filled with nil
+	*/
+	const valueOffset = 4
+	deviceIndexMap := make(map[string]int, len(deviceIds))
+	for i, deviceId := range deviceIds {
+		deviceIndexMap[deviceId] = i
+	}
+	// device index -> window start -> point values
+	deviceRows := make([]map[int64][]any, len(deviceIds))
+	windowEndMap := make(map[int64]int64)
+	for _, row := range rows {
+		if len(row) < len(pointCodes)+valueOffset {
+			continue
+		}
+		deviceId := gconv.String(row[2])
+		deviceIdx, ok := deviceIndexMap[deviceId]
+		if !ok || gconv.String(row[3]) != projectIds[deviceId] {
+			continue
+		}
+		// transform "2024-03-30T14:20:25.450Z" to unix time 1711808425450
+		windowStart := gtime.New(row[0]).UnixMilli()
+		if deviceRows[deviceIdx] == nil {
+			deviceRows[deviceIdx] = make(map[int64][]any)
+		}
+		deviceRows[deviceIdx][windowStart] = row[valueOffset : len(pointCodes)+valueOffset]
+		windowEndMap[windowStart] = gtime.New(row[1]).UnixMilli()
+	}
+
+	windowStarts := make([]int64, 0, len(windowEndMap))
+	for windowStart := range windowEndMap {
+		windowStarts = append(windowStarts, windowStart)
+	}
+	slices.Sort(windowStarts)
+	windowEnds := make([]int64, 0, len(windowStarts))
+	for _, windowStart := range windowStarts {
+		windowEnds = append(windowEnds, windowEndMap[windowStart])
+	}
+
+	results := make([]*SeriesResult, 0, len(deviceIds)*len(pointCodes))
+	for deviceIdx, deviceId := range deviceIds {
+		for pointIdx, pointCode := range pointCodes {
+			values := make([]any, len(windowStarts))
+			for tsIdx, windowStart := range windowStarts {
+				if rowValues, ok := deviceRows[deviceIdx][windowStart]; ok {
+					values[tsIdx] = rowValues[pointIdx]
+				}
+			}
+			results = append(results, &SeriesResult{
+				DeviceModelName: deviceModelName,
+				DeviceId:        deviceId,
+				ProjectId:       projectIds[deviceId],
+				PointCode:       pointCode,
+				WindowStarts:    windowStarts,
+				WindowEnds:      windowEnds,
+				Values:          values,
+			})
 		}
-		strBuilder.WriteString(fmt.Sprintf("`%s` %s, ", v.ColumnName, dataType))
 	}
-	return strings.TrimRight(strBuilder.String(), ", ")
+	return results
 }
@@ -0,0 +1,223 @@
+package tsdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/os/gtime"
+)
+
+func TestAlignPartitionedSeries(t *testing.T) {
+	// d2 misses the second window, d3 has no rows, rows of d9 are not requested
+	rows := [][]any{
+		{"2024-03-30T14:01:00.000Z", "2024-03-30T14:02:00.000Z", "d2", "prj", 3.0, 4.0},
+		{"2024-03-30T14:00:00.000Z", "2024-03-30T14:01:00.000Z", "d1", "prj", 1.0, 2.0},
+		{"2024-03-30T14:01:00.000Z", "2024-03-30T14:02:00.000Z", "d1", "prj", 5.0, 6.0},
+		{"2024-03-30T14:00:00.000Z", "2024-03-30T14:01:00.000Z", "d9", "prj", 7.0, 8.0},
+	}
+	projectIds := map[string]string{"d1": "prj", "d2": "prj", "d3": ""}
+	results := alignPartitionedSeries(rows, "m", []string{"d1", "d2", "d3"}, projectIds, []string{"p1", "p2"})
+
+	type labelled struct {
+		DeviceId  string
+		PointCode string
+		Values    []any
+	}
+	got := make([]labelled, 0, len(results))
+	for _, result := range results {
+		got = append(got, labelled{DeviceId: result.DeviceId, PointCode: result.PointCode, Values: result.Values})
+	}
+	want := []labelled{
+		{DeviceId: "d1", PointCode: "p1", Values: []any{1.0, 5.0}},
+		{DeviceId: "d1", PointCode: "p2", Values: []any{2.0, 6.0}},
+		{DeviceId: "d2", PointCode: "p1", Values: []any{nil, 3.0}},
+		{DeviceId: "d2", PointCode: "p2", Values: []any{nil, 4.0}},
+		{DeviceId: "d3", PointCode: "p1", Values: []any{nil, nil}},
+		{DeviceId: "d3", PointCode: "p2", Values: []any{nil, nil}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if starts := results[0].WindowStarts; len(starts) != 2 || starts[1]-starts[0] != 60000 {
+		t.Fatalf("unexpected window starts: %v", starts)
+	}
+}
+
+func TestAlignPartitionedSeriesOfProjects(t *testing.T) {
+	// d1 is in two projects, which are separate subtables, only the chosen project is read
+	rows := [][]any{
+		{"2024-03-30T14:00:00.000Z", "2024-03-30T14:01:00.000Z", "d1", "prj1", 1.0},
+		{"2024-03-30T14:01:00.000Z", "2024-03-30T14:02:00.000Z", "d1", "prj2", 2.0},
+		{"2024-03-30T14:02:00.000Z", "2024-03-30T14:03:00.000Z", "d1", "prj1", 3.0},
+	}
+	wantProjects := map[string][]string{"d1": {"prj1", "prj2"}}
+	if got := partitionProjects(rows); !reflect.DeepEqual(got, wantProjects) {
+		t.Fatalf("got projects %v, want %v", got, wantProjects)
+	}
+	results := alignPartitionedSeries(rows, "m", []string{"d1"}, map[string]string{"d1": "prj1"}, []string{"p1"})
+	if len(results) != 1 || results[0].ProjectId != "prj1" || !reflect.DeepEqual(results[0].Values, []any{1.0, 3.0}) {
+		t.Fatalf("got %+v, want values [1 3] of prj1", results[0])
+	}
+	if starts := results[0].WindowStarts; len(starts) != 2 {
+		t.Fatalf("got window starts %v, windows of the other project must not be kept", starts)
+	}
+}
+
+func TestLastSeenProjects(t *testing.T) {
+	devices := []*DeviceStatus{
+		{DeviceId: "d1", ProjectId: "prj1", LastSeen: 10},
+		{DeviceId: "d1", ProjectId: "prj2", LastSeen: 20},
+		{DeviceId: "d2", ProjectId: "prj2", LastSeen: 10},
+		{DeviceId: "d2", ProjectId: "prj1", LastSeen: 10}, // the same time, the smaller project
+		{DeviceId: "d3", LastSeen: 10},
+	}
+	want := map[string]string{"d1": "prj2", "d2": "prj1", "d3": ""}
+	if got := lastSeenProjects(devices); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestValidateDeviceIds(t *testing.T) {
+	if err := (ReadDeviceSeriesDataInput{DeviceIds: []string{"d1", "d2"}}).validateDeviceIds(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (ReadDeviceSeriesDataInput{DeviceIds: []string{"d1", "d2", "d1"}}).validateDeviceIds(); err == nil {
+		t.Fatal("expected an error of the duplicate deviceId")
+	}
+}
+
+// lineScanner reads a point of the influxdb line protocol by its escaping rules:
+// measurements escape comma and space, tag keys, tag values and field keys escape comma, equals sign and space,
+// quoted string field values escape double quote and backslash, other backslashes are literal
+type lineScanner struct {
+	t    *testing.T
+	line string
+	pos  int
+}
+
+// token reads until an unescaped stop character
+func (s *lineScanner) token(stops string, escapes string) string {
+	var out strings.Builder
+	for ; s.pos < len(s.line) && !strings.ContainsRune(stops, rune(s.line[s.pos])); s.pos++ {
+		c := s.line[s.pos]
+		if c == '\\' && s.pos+1 < len(s.line) && strings.ContainsRune(escapes, rune(s.line[s.pos+1])) {
+			s.pos++
+			c = s.line[s.pos]
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+func (s *lineScanner) quoted() string {
+	var out strings.Builder
+	for s.pos++; s.pos < len(s.line) && s.line[s.pos] != '"'; s.pos++ {
+		c := s.line[s.pos]
+		if c == '\\' && s.pos+1 < len(s.line) && strings.ContainsRune(`"\`, rune(s.line[s.pos+1])) {
+			s.pos++
+			c = s.line[s.pos]
+		}
+		out.WriteByte(c)
+	}
+	if s.pos == len(s.line) {
+		s.t.Fatalf("unterminated string in %q", s.line)
+	}
+	s.pos++
+	return out.String()
+}
+
+func (s *lineScanner) expect(c byte) {
+	if s.pos >= len(s.line) || s.line[s.pos] != c {
+		s.t.Fatalf("expect %q at %d of %q", c, s.pos, s.line)
+	}
+	s.pos++
+}
+
+// parseLinePoint parses a point of the influxdb line protocol, string field values are unquoted
+func parseLinePoint(t *testing.T, line string) (measurement string, tags map[string]string, fields map[string]string) {
+	t.Helper()
+	s := &lineScanner{t: t, line: line}
+	tags = make(map[string]string)
+	fields = make(map[string]string)
+	measurement = s.token(", ", ", ")
+	for s.pos < len(line) && line[s.pos] == ',' {
+		s.pos++
+		key := s.token("=", ",= ")
+		s.expect('=')
+		tags[key] = s.token(", ", ",= ")
+	}
+	s.expect(' ')
+	for {
+		key := s.token("=", ",= ")
+		s.expect('=')
+		if s.pos < len(line) && line[s.pos] == '"' {
+			fields[key] = s.quoted()
+		} else {
+			fields[key] = s.token(", ", "")
+		}
+		if s.pos < len(line) && line[s.pos] == ',' {
+			s.pos++
+			continue
+		}
+		break
+	}
+	s.expect(' ')
+	if timestamp := s.token("", ""); timestamp == "" || strings.Trim(timestamp, "0123456789") != "" {
+		t.Fatalf("invalid timestamp %q in %q", timestamp, line)
+	}
+	return measurement, tags, fields
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	message := "a \"quoted\" message,\nwith a back\\slash=1, a \\n and a\r\nnewline\\"
+	metric := &Metric{Name: "alarm, 1", Time: gtime.NewFromTimeStamp(1)}
+	metric.AddTag("device", "d,1 =a")
+	metric.AddTag("project", `p\1`)
+	metric.AddField("message", lineProtocolString(message))
+	metric.AddField("acked", true)
+	if err := validateLineProtocolNames([]*Metric{metric}); err != nil {
+		t.Fatal(err)
+	}
+
+	line := Serialize([]*Metric{metric}).String()
+	measurement, tags, fields := parseLinePoint(t, line)
+	if measurement != "alarm, 1" {
+		t.Fatalf("got measurement %q", measurement)
+	}
+	wantTags := map[string]string{"device": "d,1 =a", "project": `p\1`}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Fatalf("got tags %q, want %q", tags, wantTags)
+	}
+	wantFields := map[string]string{"message": message, "acked": "true"}
+	if !reflect.DeepEqual(fields, wantFields) {
+		t.Fatalf("got fields %q, want %q", fields, wantFields)
+	}
+}
+
+func TestValidateLineProtocolNames(t *testing.T) {
+	newMetric := func(name string, tagValue string, fieldKey string) *Metric {
+		metric := &Metric{Name: name, Time: gtime.NewFromTimeStamp(1)}
+		metric.AddTag("device", tagValue)
+		metric.AddField(fieldKey, 1)
+		return metric
+	}
+	cases := []struct {
+		name    string
+		metric  *Metric
+		wantErr bool
+	}{
+		{name: "valid", metric: newMetric("m 1", "d,1", "p=1"), wantErr: false},
+		{name: "newline in a measurement", metric: newMetric("m\n1", "d1", "p1"), wantErr: true},
+		{name: "newline in a tag value", metric: newMetric("m1", "d1\n", "p1"), wantErr: true},
+		{name: "carriage return in a field key", metric: newMetric("m1", "d1", "p\r1"), wantErr: true},
+		{name: "tab in a tag value", metric: newMetric("m1", "d\t1", "p1"), wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := validateLineProtocolNames([]*Metric{c.metric}); (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %t", err, c.wantErr)
+			}
+		})
+	}
+}
@@ -26,6 +26,12 @@ type Client interface {
 	CreateSTable(ctx context.Context, stableName string, columns []TdengineColumn) error
 }
 
+// SeriesReader is implemented by all clients, use it by type assertion on GetClient()
+type SeriesReader interface {
+	// ReadSeries returns labelled series of windows aligned to the multiples of interval, as tdengine does
+	ReadSeries(ctx context.Context, in ReadDeviceSeriesDataInput) ([]*SeriesResult, error)
+}
+
 type ClientCreator func() Client
 
 type ClientFactory struct {
@@ -35,8 +41,9 @@ type ClientFactory struct {
 func NewClientFactory() *ClientFactory {
 	return &ClientFactory{
 		creators: map[ClientType]ClientCreator{
-			ClientTypeTdengine: NewTdengineClient,
-			ClientTypeRedis:    NewRedisClient,
+			ClientTypeTdengine:        NewTdengineClient,
+			ClientTypeRedis:           NewRedisClient,
+			ClientTypeRedisTimeSeries: NewRedisTimeSeriesClient,
 		},
 	}
 }
@@ -60,7 +67,7 @@ func (f *ClientFactory) CreateClient(clientType ClientType) (Client, error) {
 	if isCreated {
 		return instance, nil
 	} else {
-		supportedTypes := fmt.Sprintf("[ %s ], [ %s ]", ClientTypeTdengine, ClientTypeRedis)
+		supportedTypes := fmt.Sprintf("[ %s ], [ %s ], [ %s ]", ClientTypeTdengine, ClientTypeRedis, ClientTypeRedisTimeSeries)
 		return nil, fmt.Errorf("initial tsdb type [ %s ] is not in the support list: %s", instanceType, supportedTypes)
 	}
 }
@@ -1,6 +1,7 @@
 package tsdb
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/gogf/gf/v2/os/gtime"
@@ -10,7 +11,7 @@ func mustGetDataKeepFromConfig(config Config, clientType ClientType) (string, ti
 	var defaultDataKeepStr string
 	var defaultDataKeepDuration time.Duration
 	switch clientType {
-	case ClientTypeRedis:
+	case ClientTypeRedis, ClientTypeRedisTimeSeries:
 		defaultDataKeepStr = redisDataKeepDefaultStr
 		defaultDataKeepDuration = redisDataKeepDefaultDuration
 	case ClientTypeTdengine:
@@ -43,3 +44,49 @@ func mustGetRealTimeWindowFromConfig(config Config) (string, time.Duration) {
 		return RealTimeWindowDefaultStr, RealTimeWindowDefaultDuration
 	}
 }
+
+func mustGetRedisSweepIntervalFromConfig(config Config, dataKeep time.Duration) time.Duration {
+	if config.RedisSweepInterval == "" {
+		return dataKeep
+	}
+	sweepInterval, innErr := gtime.ParseDuration(config.RedisSweepInterval)
+	if innErr != nil || sweepInterval < 0 {
+		return dataKeep
+	}
+	if sweepInterval > 0 && sweepInterval < RealTimeWindowMinDuration {
+		return RealTimeWindowMinDuration
+	}
+	return sweepInterval
+}
+
+// getLatestKeepFromConfig returns 0 if the latest data are kept forever,
+// latest data must be kept at least for realTimeWindow, otherwise devices online would have no latest data
+func getLatestKeepFromConfig(config Config, dataKeep time.Duration, realTimeWindow time.Duration) (time.Duration, error) {
+	if config.LatestKeep == "" {
+		return dataKeep, nil
+	}
+	latestKeep, err := gtime.ParseDuration(config.LatestKeep)
+	if err != nil || latestKeep < 0 {
+		return 0, fmt.Errorf("invalid latest keep: %s", config.LatestKeep)
+	}
+	if latestKeep > 0 && latestKeep < realTimeWindow {
+		return 0, fmt.Errorf("latest keep %s is shorter than the real time window %s", config.LatestKeep, realTimeWindow)
+	}
+	return latestKeep, nil
+}
+
+func isStale(timestamp int64, realTimeWindow time.Duration) bool {
+	return timestamp < gtime.Now().Add(-1*realTimeWindow).UnixMilli()
+}
+
+// SeriesResultsToLegacy transforms results of ReadSeries to the shape of ReadToSeries
+func SeriesResultsToLegacy(results []*SeriesResult) (seriesData [][]any, timestamps []int64) {
+	seriesData = make([][]any, 0, len(results))
+	for _, result := range results {
+		if timestamps == nil {
+			timestamps = result.WindowStarts
+		}
+		seriesData = append(seriesData, result.Values)
+	}
+	return
+}
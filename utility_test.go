@@ -0,0 +1,42 @@
+package tsdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetLatestKeepFromConfig(t *testing.T) {
+	const dataKeep = 24 * time.Hour
+	const realTimeWindow = 5 * time.Minute
+	cases := []struct {
+		name       string
+		latestKeep string
+		want       time.Duration
+		wantErr    bool
+	}{
+		{name: "data keep by default", latestKeep: "", want: dataKeep},
+		{name: "forever", latestKeep: "0", want: 0},
+		{name: "days", latestKeep: "7d", want: 7 * 24 * time.Hour},
+		{name: "the real time window", latestKeep: "5m", want: realTimeWindow},
+		{name: "shorter than the real time window", latestKeep: "1m", wantErr: true},
+		{name: "negative", latestKeep: "-1h", wantErr: true},
+		{name: "invalid", latestKeep: "one day", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := getLatestKeepFromConfig(Config{LatestKeep: c.latestKeep}, dataKeep, realTimeWindow)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Fatalf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}